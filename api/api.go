@@ -2,43 +2,55 @@ package api
 
 import (
 	"html/template"
-	"math"
 	"sort"
 	"strconv"
 	"time"
 
+	"indexer/chain"
+	"indexer/config"
 	"indexer/exchange"
+	"indexer/pricing"
 	"indexer/store"
 	"indexer/token"
+	"indexer/trading"
 
+	"github.com/ericlagergren/decimal"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 )
 
 const (
 	CandlesPerPage = 500
+	MaxCandles     = 1000
+	TradesPerPage  = 1000
+	MaxTrades      = 10000
 )
 
 type Api struct {
 	engine          *gin.Engine
 	exchanges       map[string]exchange.Exchange
 	exchangeManager *exchange.ExchangeManager
+	prices          *pricing.Index
+	valuer          *pricing.Valuer
 	stores          store.StoreManager
 	logger          zerolog.Logger
 }
 
-func NewApi(exchanges map[string]exchange.Exchange, exchangeManager *exchange.ExchangeManager, stores store.StoreManager, logger zerolog.Logger) *Api {
+func NewApi(exchanges map[string]exchange.Exchange, exchangeManager *exchange.ExchangeManager, prices *pricing.Index, valuer *pricing.Valuer, stores store.StoreManager, logger zerolog.Logger) *Api {
 	apiLogger := logger.With().Str("api", "gin").Logger()
 	engine := gin.New()
 	a := &Api{
 		engine:          engine,
 		exchanges:       exchanges,
 		exchangeManager: exchangeManager,
+		prices:          prices,
+		valuer:          valuer,
 		stores:          stores,
 		logger:          apiLogger,
 	}
 	a.AddMiddleware()
 	a.AddRoutes()
+	a.AddStreamRoutes()
 	return a
 }
 
@@ -107,9 +119,13 @@ func (a *Api) AddRoutes() error {
 							<a href="/exchanges/{{ .name }}">{{ .display }}</a>
 							<ul>
 								<li><a href="/exchanges/{{ .name }}/pairs">Pairs</a></li>
+								<li><a href="/exchanges/{{ .name }}/assets">Assets</a></li>
 								<li><a href="/exchanges/{{ .name }}/tickers">Tickers</a></li>
+								<li><a href="/exchanges/{{ .name }}/summary">Summary</a></li>
 								<li><a href="/exchanges/{{ .name }}/candles">Candles</a></li>
+								<li><a href="/exchanges/{{ .name }}/pools">Pools</a></li>
 								<li><a href="/exchanges/{{ .name }}/trades">Trades</a></li>
+								<li><a href="/exchanges/{{ .name }}/flagged">Flagged trades</a></li>
 							</ul>
 						</li>
 					{{ end }}
@@ -142,6 +158,57 @@ func (a *Api) AddRoutes() error {
 		}
 		ctx.JSON(200, gin.H{"exchanges": exchanges})
 	})
+	a.engine.GET("/chains", func(ctx *gin.Context) {
+		pools := chain.Pools()
+		chains := make([]gin.H, len(pools))
+		for i, pool := range pools {
+			chains[i] = gin.H{
+				"name":      pool.Name(),
+				"active":    pool.ActiveUrl(),
+				"endpoints": pool.Status(),
+			}
+		}
+		sort.Slice(chains, func(i, j int) bool {
+			return chains[i]["name"].(string) < chains[j]["name"].(string)
+		})
+		ctx.JSON(200, gin.H{"chains": chains})
+	})
+	a.engine.GET("/prices/:base/:quote", func(ctx *gin.Context) {
+		pair := &token.Pair{
+			Base:  ctx.Param("base"),
+			Quote: ctx.Param("quote"),
+		}
+		mode := ctx.DefaultQuery("mode", a.prices.Mode())
+		_, ok := pricing.Modes[mode]
+		if !ok {
+			ctx.JSON(400, gin.H{"error": "invalid mode"})
+			return
+		}
+		price, err := a.prices.Price(pair, mode)
+		if err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, gin.H{"price": price})
+	})
+	a.engine.GET("/routes/:base/:quote", func(ctx *gin.Context) {
+		names := []string{}
+		exchangeName := ctx.Query("exchange")
+		if exchangeName != "" {
+			_, ok := a.exchanges[exchangeName]
+			if !ok {
+				ctx.JSON(404, gin.H{"error": "exchange not found"})
+				return
+			}
+			names = append(names, exchangeName)
+		}
+		route, err := a.prices.Route(ctx.Param("base"), ctx.Param("quote"), names)
+		if err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, gin.H{"route": route})
+	})
 	a.engine.GET("/exchanges/:exchange", func(ctx *gin.Context) {
 		exchangeName := ctx.Param("exchange")
 		e, ok := a.exchanges[exchangeName]
@@ -177,6 +244,19 @@ func (a *Api) AddRoutes() error {
 		})
 		ctx.JSON(200, gin.H{"pairs": pairStrings})
 	})
+	a.engine.GET("/exchanges/:exchange/assets", func(ctx *gin.Context) {
+		exchangeName := ctx.Param("exchange")
+		e, ok := a.exchanges[exchangeName]
+		if !ok {
+			ctx.JSON(404, gin.H{"error": "exchange not found"})
+			return
+		}
+		assets := append([]*token.Asset{}, e.Assets()...)
+		sort.Slice(assets, func(i, j int) bool {
+			return assets[i].Symbol < assets[j].Symbol
+		})
+		ctx.JSON(200, gin.H{"assets": assets})
+	})
 	a.engine.GET("/exchanges/:exchange/tickers", func(ctx *gin.Context) {
 		exchangeName := ctx.Param("exchange")
 		_, ok := a.exchanges[exchangeName]
@@ -189,11 +269,57 @@ func (a *Api) AddRoutes() error {
 			ctx.JSON(404, gin.H{"error": "exchange tickers not found"})
 			return
 		}
+		tickers, ok = filterStale(ctx, tickers)
+		if !ok {
+			return
+		}
+		valuation := a.valuer.Exchange(exchangeName)
+		for i, ticker := range tickers {
+			tickers[i] = valuation.Ticker(ticker)
+		}
 		sort.Slice(tickers, func(i, j int) bool {
 			return tickers[i].BaseAsset < tickers[j].BaseAsset
 		})
 		ctx.JSON(200, gin.H{"tickers": tickers})
 	})
+	a.engine.GET("/exchanges/:exchange/summary", func(ctx *gin.Context) {
+		exchangeName := ctx.Param("exchange")
+		_, ok := a.exchanges[exchangeName]
+		if !ok {
+			ctx.JSON(404, gin.H{"error": "exchange not found"})
+			return
+		}
+		tickers, err := a.exchangeManager.Tickers(exchangeName)
+		if err != nil {
+			ctx.JSON(404, gin.H{"error": "exchange tickers not found"})
+			return
+		}
+		valuation := a.valuer.Exchange(exchangeName)
+		total := &decimal.Big{}
+		pairs := []gin.H{}
+		unvalued := []string{}
+		for _, ticker := range tickers {
+			pair := ticker.BaseAsset + "/" + ticker.QuoteAsset
+			volume := valuation.Ticker(ticker).UsdVolume
+			if volume == nil {
+				unvalued = append(unvalued, pair)
+				continue
+			}
+			total.Add(total, volume)
+			pairs = append(pairs, gin.H{"pair": pair, "usd_volume": volume})
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i]["usd_volume"].(*decimal.Big).Cmp(pairs[j]["usd_volume"].(*decimal.Big)) > 0
+		})
+		sort.Strings(unvalued)
+		ctx.JSON(200, gin.H{"summary": gin.H{
+			"exchange":   exchangeName,
+			"currency":   a.valuer.Currency(),
+			"usd_volume": total,
+			"pairs":      pairs,
+			"unvalued":   unvalued,
+		}})
+	})
 	a.engine.GET("/exchanges/:exchange/candles", func(ctx *gin.Context) {
 		exchangeName := ctx.Param("exchange")
 		_, ok := a.exchanges[exchangeName]
@@ -228,7 +354,7 @@ func (a *Api) AddRoutes() error {
 			ctx.JSON(404, gin.H{"error": "tickers not found"})
 			return
 		}
-		ctx.JSON(200, gin.H{"ticker": ticker})
+		ctx.JSON(200, gin.H{"ticker": a.valuer.Exchange(exchangeName).Ticker(ticker)})
 	})
 	a.engine.GET("/exchanges/:exchange/candles/:base/:quote", func(ctx *gin.Context) {
 		exchangeName := ctx.Param("exchange")
@@ -241,54 +367,133 @@ func (a *Api) AddRoutes() error {
 			Base:  ctx.Param("base"),
 			Quote: ctx.Param("quote"),
 		}
-		candles, err := a.exchangeManager.Candles(exchangeName, pair)
-		isReversed := false
-		if err != nil {
-			candles, err = a.exchangeManager.Candles(exchangeName, pair.Reversed())
+		a.queryCandles(ctx, exchangeName, "", pair)
+	})
+	a.engine.GET("/exchanges/:exchange/averages/:base/:quote", func(ctx *gin.Context) {
+		exchangeName := ctx.Param("exchange")
+		_, ok := a.exchanges[exchangeName]
+		if !ok {
+			ctx.JSON(404, gin.H{"error": "exchange not found"})
+			return
+		}
+		pair := &token.Pair{
+			Base:  ctx.Param("base"),
+			Quote: ctx.Param("quote"),
+		}
+		windows := []time.Duration{}
+		for _, param := range ctx.QueryArray("window") {
+			window, err := time.ParseDuration(param)
 			if err != nil {
-				ctx.JSON(404, gin.H{"error": "candles not found"})
+				ctx.JSON(400, gin.H{"error": "invalid window '" + param + "'"})
+				return
+			}
+			tracked := false
+			for _, configured := range config.Cfg.AverageWindows {
+				if configured == window {
+					tracked = true
+				}
+			}
+			if !tracked {
+				ctx.JSON(400, gin.H{"error": "window '" + param + "' is not configured"})
 				return
 			}
-			isReversed = true
+			windows = append(windows, window)
 		}
-		numCandles := candles.Len() - 1
-		numPages := int(math.Ceil(float64(numCandles) / CandlesPerPage))
-		page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-		if err != nil || page > numPages || page < 1 {
-			ctx.JSON(400, gin.H{"error": "invalid page"})
+		averages, err := a.exchangeManager.Averages(exchangeName, pair, windows)
+		if err != nil {
+			ctx.JSON(404, gin.H{"error": "averages not found"})
+			return
+		}
+		ctx.JSON(200, gin.H{"base_asset": pair.Base, "quote_asset": pair.Quote, "averages": averages})
+	})
+	a.engine.GET("/exchanges/:exchange/pools", func(ctx *gin.Context) {
+		exchangeName := ctx.Param("exchange")
+		_, ok := a.exchanges[exchangeName]
+		if !ok {
+			ctx.JSON(404, gin.H{"error": "exchange not found"})
 			return
 		}
-		pageStart := (page-1)*CandlesPerPage + 1
-		pageEnd := pageStart + CandlesPerPage + 1
-		if pageEnd > numCandles {
-			pageEnd = numCandles
+		pools, err := a.exchangeManager.Pools(exchangeName)
+		if err != nil {
+			ctx.JSON(404, gin.H{"error": "exchange pools not found"})
+			return
 		}
-		candlesList := candles.ListRange(pageStart, pageEnd)
-		if isReversed {
-			for i, candle := range candlesList {
-				candlesList[i] = candle.Reversed()
+		poolList := make([]gin.H, 0, len(pools))
+		for pool, pairs := range pools {
+			pairStrings := make([]string, len(pairs))
+			for i, pair := range pairs {
+				pairStrings[i] = pair.String()
 			}
+			sort.Strings(pairStrings)
+			poolList = append(poolList, gin.H{"pool": pool, "pairs": pairStrings})
 		}
-		pagedCandles := gin.H{"page": gin.H{"current": page, "total": numPages}, "candles": candlesList}
-		ctx.JSON(200, pagedCandles)
+		sort.Slice(poolList, func(i, j int) bool {
+			return poolList[i]["pool"].(string) < poolList[j]["pool"].(string)
+		})
+		ctx.JSON(200, gin.H{"pools": poolList})
 	})
-	a.engine.GET("/exchanges/:exchange/trades/:base/:quote", func(ctx *gin.Context) {
+	a.engine.GET("/exchanges/:exchange/pools/:pool/tickers", func(ctx *gin.Context) {
 		exchangeName := ctx.Param("exchange")
 		_, ok := a.exchanges[exchangeName]
 		if !ok {
 			ctx.JSON(404, gin.H{"error": "exchange not found"})
 			return
 		}
-		store, err := a.stores.Store(exchangeName)
+		tickers, err := a.exchangeManager.PoolTickers(exchangeName, ctx.Param("pool"))
 		if err != nil {
-			ctx.JSON(500, gin.H{"error": err.Error()})
+			ctx.JSON(404, gin.H{"error": "pool tickers not found"})
+			return
+		}
+		tickers, ok = filterStale(ctx, tickers)
+		if !ok {
+			return
+		}
+		valuation := a.valuer.Exchange(exchangeName)
+		for i, ticker := range tickers {
+			tickers[i] = valuation.Ticker(ticker)
+		}
+		sort.Slice(tickers, func(i, j int) bool {
+			return tickers[i].BaseAsset < tickers[j].BaseAsset
+		})
+		ctx.JSON(200, gin.H{"tickers": tickers})
+	})
+	a.engine.GET("/exchanges/:exchange/pools/:pool/candles", func(ctx *gin.Context) {
+		exchangeName := ctx.Param("exchange")
+		_, ok := a.exchanges[exchangeName]
+		if !ok {
+			ctx.JSON(404, gin.H{"error": "exchange not found"})
 			return
 		}
+		poolPath := "/exchanges/" + exchangeName + "/pools/" + ctx.Param("pool")
+		ctx.JSON(400, gin.H{"error": "must provide base/quote pair in request, e.g. " + poolPath + "/candles/BASE/QUOTE"})
+	})
+	a.engine.GET("/exchanges/:exchange/pools/:pool/candles/:base/:quote", func(ctx *gin.Context) {
+		exchangeName := ctx.Param("exchange")
+		_, ok := a.exchanges[exchangeName]
+		if !ok {
+			ctx.JSON(404, gin.H{"error": "exchange not found"})
+			return
+		}
+		pool := ctx.Param("pool")
 		pair := &token.Pair{
 			Base:  ctx.Param("base"),
 			Quote: ctx.Param("quote"),
 		}
-		period, err := time.ParseDuration(ctx.DefaultQuery("period", "1h"))
+		a.queryCandles(ctx, exchangeName, pool, pair)
+	})
+	a.engine.GET("/exchanges/:exchange/flagged", func(ctx *gin.Context) {
+		exchangeName := ctx.Param("exchange")
+		_, ok := a.exchanges[exchangeName]
+		if !ok {
+			ctx.JSON(404, gin.H{"error": "exchange not found"})
+			return
+		}
+		store, err := a.stores.Store(exchangeName)
+		if err != nil {
+			ctx.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		period, err := time.ParseDuration(ctx.DefaultQuery("period", "24h"))
 		if err != nil {
 			ctx.JSON(400, gin.H{"error": "invalid period"})
 			return
@@ -304,16 +509,55 @@ func (a *Api) AddRoutes() error {
 				return
 			}
 		}
-		trades, err := store.Trades(pair, end.Add(-period), end)
+		trades, err := store.FlaggedTrades(end.Add(-period), end)
+		if err != nil {
+			ctx.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, gin.H{"flagged": trades})
+	})
+	a.engine.GET("/exchanges/:exchange/trades/:base/:quote", func(ctx *gin.Context) {
+		exchangeName := ctx.Param("exchange")
+		_, ok := a.exchanges[exchangeName]
+		if !ok {
+			ctx.JSON(404, gin.H{"error": "exchange not found"})
+			return
+		}
+		store, err := a.stores.Store(exchangeName)
 		if err != nil {
 			ctx.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		ctx.JSON(200, gin.H{"trades": trades})
+		pair := &token.Pair{
+			Base:  ctx.Param("base"),
+			Quote: ctx.Param("quote"),
+		}
+		a.queryTrades(ctx, store, pair)
 	})
 	return nil
 }
 
+// filterStale keeps only the stale or only the fresh tickers when the stale query
+// parameter is set, responding with an error if it is invalid.
+func filterStale(ctx *gin.Context, tickers []*trading.Ticker) ([]*trading.Ticker, bool) {
+	param, ok := ctx.GetQuery("stale")
+	if !ok {
+		return tickers, true
+	}
+	stale, err := strconv.ParseBool(param)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "invalid stale"})
+		return nil, false
+	}
+	filtered := []*trading.Ticker{}
+	for _, ticker := range tickers {
+		if ticker.Stale == stale {
+			filtered = append(filtered, ticker)
+		}
+	}
+	return filtered, true
+}
+
 func (a *Api) Start() {
 	a.engine.Run()
 }
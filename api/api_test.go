@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"indexer/config"
+	"indexer/exchange"
+	"indexer/pricing"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/ericlagergren/decimal"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+const testExchange = "test"
+
+var (
+	testPair  = &token.Pair{Base: "ATOM", Quote: "USDC"}
+	testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// TestMain keeps ten one minute candles in memory, so that older candles are
+// rebuilt from the store.
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	config.Cfg.CandlesInterval = time.Minute
+	config.Cfg.CandlesPeriod = 10 * time.Minute
+	config.Cfg.AverageWindows = nil
+	config.Cfg.Staleness = config.StalenessConfig{MaxAge: time.Hour}
+	config.Cfg.Filter = config.FilterConfig{}
+	os.Exit(m.Run())
+}
+
+func newTestTrade(t *testing.T, at time.Time, base string, quote string) *trading.Trade {
+	t.Helper()
+	trade := &trading.Trade{
+		Base:  token.Token{Symbol: testPair.Base},
+		Quote: token.Token{Symbol: testPair.Quote},
+		Time:  at,
+	}
+	_, ok := trade.Base.Amount.SetString(base)
+	if !ok {
+		t.Fatalf("invalid base amount %s", base)
+	}
+	_, ok = trade.Quote.Amount.SetString(quote)
+	if !ok {
+		t.Fatalf("invalid quote amount %s", quote)
+	}
+	return trade
+}
+
+// newTestApi serves an exchange whose store holds the given trades, with the
+// clock at now.
+func newTestApi(t *testing.T, now time.Time, trades []*trading.Trade) *Api {
+	t.Helper()
+	logger := zerolog.Nop()
+	stores, err := store.NewMemoryManager(&store.MemoryConfig{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := stores.Store(testExchange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trade := range trades {
+		s.SaveTrade(trade)
+	}
+	mock := exchange.NewMockExchange(testExchange, s, logger)
+	exchanges := map[string]exchange.Exchange{testExchange: mock}
+	manager, err := exchange.NewExchangeManager(exchanges, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetClock(fixedClock(now))
+	manager.Start()
+	mock.AddPairs(testPair)
+	deadline := time.Now().Add(5 * time.Second)
+	for manager.ReadCandles(testExchange, "", testPair, func(*trading.Candles) {}) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("pair was not added")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	prices, err := pricing.NewIndex(manager, config.Cfg.Prices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valuer := pricing.NewValuer(prices, config.Cfg.Valuation, logger)
+	return NewApi(exchanges, manager, prices, valuer, stores, logger)
+}
+
+// get requests path and decodes the JSON response into v.
+func get(t *testing.T, a *Api, path string, v any) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	a.engine.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	if recorder.Code != 200 {
+		t.Fatalf("GET %s: got status %d: %s", path, recorder.Code, recorder.Body.String())
+	}
+	err := json.Unmarshal(recorder.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+func checkDecimal(t *testing.T, name string, got *decimal.Big, want int64) {
+	t.Helper()
+	if got.Cmp(decimal.New(want, 0)) != 0 {
+		t.Errorf("got %s %s, want %d", name, got.String(), want)
+	}
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"indexer/config"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/gin-gonic/gin"
+)
+
+type candlesQuery struct {
+	start          time.Time
+	end            time.Time
+	limit          int
+	descending     bool
+	includeCurrent bool
+}
+
+// queryCandles responds with the candles of a pair, or of the pair in a pool,
+// that start within the requested range. Pages hold up to limit candles, and
+// the cursor of the next page is returned while there are more. Every interval
+// of the range has a candle, empty ones included, so pages are found by time
+// alone. Candles older than those kept in memory are rebuilt from the store.
+func (a *Api) queryCandles(ctx *gin.Context, exchangeName string, pool string, pair *token.Pair) {
+	query, err := parseCandlesQuery(ctx)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	interval := config.Cfg.CandlesInterval
+	var (
+		ringPair token.Pair
+		oldest   time.Time
+		start    time.Time
+		end      time.Time
+		cursor   time.Time
+		candles  []*trading.Candle
+		// the end of the range the pages move towards, kept in the cursor
+		bound time.Time
+	)
+	read := func(ring *trading.Candles) {
+		ringPair = ring.Pair
+		oldest = ring.Oldest()
+		current := ring.Current()
+		start, end = oldest, current.Start
+		if query.includeCurrent {
+			end = current.End
+		}
+		if !query.end.IsZero() && query.end.Before(end) {
+			end = alignUp(query.end, interval)
+			// without a start, the range is the candle period before end
+			start = end.Add(-config.Cfg.CandlesPeriod)
+		}
+		if !query.start.IsZero() {
+			start = alignUp(query.start, interval)
+		}
+		if query.descending {
+			pageStart := end.Add(-time.Duration(query.limit) * interval)
+			if pageStart.After(start) {
+				bound, cursor = start, pageStart
+				start = pageStart
+			}
+		} else {
+			pageEnd := start.Add(time.Duration(query.limit) * interval)
+			if pageEnd.Before(end) {
+				bound, cursor = end, pageEnd
+				end = pageEnd
+			}
+		}
+		candles = ring.Range(start, end)
+	}
+	err = a.exchangeManager.ReadCandles(exchangeName, pool, pair, read)
+	if err != nil {
+		err = a.exchangeManager.ReadCandles(exchangeName, pool, pair.Reversed(), read)
+		if err != nil {
+			ctx.JSON(404, gin.H{"error": "candles not found"})
+			return
+		}
+	}
+	if start.Before(oldest) && start.Before(end) {
+		older, err := a.storedCandles(exchangeName, pool, &ringPair, start, minTime(end, oldest))
+		if err != nil {
+			ctx.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		candles = append(older, candles...)
+	}
+	valuation := a.valuer.Exchange(exchangeName)
+	list := make([]*trading.Candle, len(candles))
+	for i, candle := range candles {
+		candle = valuation.Candle(candle)
+		if ringPair != *pair {
+			candle = candle.Reversed()
+		}
+		if query.descending {
+			list[len(candles)-1-i] = candle
+		} else {
+			list[i] = candle
+		}
+	}
+	res := gin.H{"candles": list}
+	if !cursor.IsZero() {
+		res["next_cursor"] = encodeCursor(cursor, bound)
+	}
+	ctx.JSON(200, res)
+}
+
+func parseCandlesQuery(ctx *gin.Context) (*candlesQuery, error) {
+	query := &candlesQuery{}
+	var err error
+	query.limit, err = strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(CandlesPerPage)))
+	if err != nil || query.limit < 1 || query.limit > MaxCandles {
+		return nil, fmt.Errorf("invalid limit, must be between 1 and %d", MaxCandles)
+	}
+	switch ctx.DefaultQuery("order", "desc") {
+	case "desc":
+		query.descending = true
+	case "asc":
+	default:
+		return nil, fmt.Errorf("invalid order, must be asc or desc")
+	}
+	query.includeCurrent, err = strconv.ParseBool(ctx.DefaultQuery("include_current", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid include_current")
+	}
+	for name, t := range map[string]*time.Time{"start": &query.start, "end": &query.end} {
+		param := ctx.Query(name)
+		if param == "" {
+			continue
+		}
+		*t, err = time.Parse(time.RFC3339, param)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s", name)
+		}
+	}
+	// the cursor continues the range from where the previous page ended
+	param := ctx.Query("cursor")
+	if param != "" {
+		cursor, bound, err := decodeCursor(param)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		if query.descending {
+			query.start, query.end = bound, cursor
+		} else {
+			query.start, query.end = cursor, bound
+		}
+	}
+	return query, nil
+}
+
+// storedCandles builds the candles starting within [start, end) from the
+// trades in the store.
+func (a *Api) storedCandles(exchangeName string, pool string, pair *token.Pair, start time.Time, end time.Time) ([]*trading.Candle, error) {
+	s, err := a.stores.Store(exchangeName)
+	if err != nil {
+		return nil, err
+	}
+	interval := config.Cfg.CandlesInterval
+	var candles *trading.Candles
+	if pool == "" {
+		candles, err = store.CandlesFromStore(s, pair, end, end.Sub(start), interval)
+	} else {
+		candles, err = store.PoolCandlesFromStore(s, pool, pair, end, end.Sub(start), interval)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return candles.Range(start, end), nil
+}
+
+// alignUp returns the start of the first candle starting at or after t.
+func alignUp(t time.Time, interval time.Duration) time.Time {
+	aligned := t.Truncate(interval)
+	if aligned.Before(t) {
+		aligned = aligned.Add(interval)
+	}
+	return aligned
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// encodeCursor encodes where the next page starts, along with the end of the
+// range the pages move towards.
+func encodeCursor(t time.Time, bound time.Time) string {
+	cursor := t.UTC().Format(time.RFC3339) + "," + bound.UTC().Format(time.RFC3339)
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+func decodeCursor(s string) (time.Time, time.Time, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	parts := strings.SplitN(string(b), ",", 2)
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid cursor")
+	}
+	t, err := time.Parse(time.RFC3339, parts[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	bound, err := time.Parse(time.RFC3339, parts[1])
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return t, bound, nil
+}
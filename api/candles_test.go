@@ -0,0 +1,116 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"indexer/trading"
+)
+
+// newCandlesTestApi trades once a minute for 30 minutes at a price of 10 plus
+// the minute, with the clock in the last minute. The ten minutes before it are
+// kept in memory.
+func newCandlesTestApi(t *testing.T) *Api {
+	trades := []*trading.Trade{}
+	for i := 0; i < 30; i++ {
+		at := testStart.Add(time.Duration(i)*time.Minute + 30*time.Second)
+		trades = append(trades, newTestTrade(t, at, "1", fmt.Sprint(10+i)))
+	}
+	return newTestApi(t, testStart.Add(29*time.Minute+45*time.Second), trades)
+}
+
+func minute(i int) string {
+	return testStart.Add(time.Duration(i) * time.Minute).Format(time.RFC3339)
+}
+
+// readCandlePages follows next_cursor from the first page of query, sending
+// only the order, the limit and the cursor along, and returns the minutes of
+// the candles on each page.
+func readCandlePages(t *testing.T, a *Api, query url.Values) [][]int {
+	t.Helper()
+	path := "/exchanges/" + testExchange + "/candles/ATOM/USDC?"
+	pages := [][]int{}
+	params := query
+	for len(pages) < 10 {
+		res := struct {
+			Candles    []*trading.Candle `json:"candles"`
+			NextCursor string            `json:"next_cursor"`
+		}{}
+		get(t, a, path+params.Encode(), &res)
+		page := []int{}
+		for _, candle := range res.Candles {
+			i := int(candle.Start.Sub(testStart) / time.Minute)
+			checkDecimal(t, fmt.Sprintf("minute %d base volume", i), &candle.BaseVolume, 1)
+			checkDecimal(t, fmt.Sprintf("minute %d close", i), &candle.Close, int64(10+i))
+			page = append(page, i)
+		}
+		pages = append(pages, page)
+		if res.NextCursor == "" {
+			return pages
+		}
+		params = url.Values{"cursor": {res.NextCursor}}
+		for _, name := range []string{"order", "limit"} {
+			if query.Has(name) {
+				params.Set(name, query.Get(name))
+			}
+		}
+	}
+	t.Fatalf("still paging after %v", pages)
+	return nil
+}
+
+func TestQueryCandlesPages(t *testing.T) {
+	a := newCandlesTestApi(t)
+	tests := []struct {
+		name  string
+		query url.Values
+		pages [][]int
+	}{
+		{
+			name:  "desc",
+			query: url.Values{"limit": {"3"}},
+			pages: [][]int{{28, 27, 26}, {25, 24, 23}, {22, 21, 20}, {19}},
+		},
+		{
+			name:  "asc",
+			query: url.Values{"order": {"asc"}, "limit": {"4"}},
+			pages: [][]int{{19, 20, 21, 22}, {23, 24, 25, 26}, {27, 28}},
+		},
+		{
+			name:  "include current",
+			query: url.Values{"limit": {"3"}, "include_current": {"true"}},
+			pages: [][]int{{29, 28, 27}, {26, 25, 24}, {23, 22, 21}, {20, 19}},
+		},
+		{
+			name:  "asc with end",
+			query: url.Values{"order": {"asc"}, "start": {minute(20)}, "end": {minute(25)}, "limit": {"2"}},
+			pages: [][]int{{20, 21}, {22, 23}, {24}},
+		},
+		{
+			name:  "asc from the store",
+			query: url.Values{"order": {"asc"}, "start": {minute(15)}, "end": {minute(25)}, "limit": {"4"}},
+			pages: [][]int{{15, 16, 17, 18}, {19, 20, 21, 22}, {23, 24}},
+		},
+		{
+			name:  "desc across the store and memory",
+			query: url.Values{"start": {minute(12)}, "end": {minute(22)}, "limit": {"6"}},
+			pages: [][]int{{21, 20, 19, 18, 17, 16}, {15, 14, 13, 12}},
+		},
+		{
+			name:  "only end before memory",
+			query: url.Values{"end": {minute(10)}, "limit": {"4"}},
+			pages: [][]int{{9, 8, 7, 6}, {5, 4, 3, 2}, {1, 0}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pages := readCandlePages(t, a, test.query)
+			if !reflect.DeepEqual(pages, test.pages) {
+				t.Errorf("got pages %v, want %v", pages, test.pages)
+			}
+		})
+	}
+}
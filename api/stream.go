@@ -0,0 +1,209 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"indexer/stream"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	streamWriteTimeout = 10 * time.Second
+	streamReadLimit    = 4096
+)
+
+var upgrader = websocket.Upgrader{
+	// the API is public and read only
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+type (
+	streamRequest struct {
+		Action string `json:"action"`
+		Topic  string `json:"topic"`
+	}
+
+	streamReply struct {
+		Type   string   `json:"type"`
+		Topic  string   `json:"topic,omitempty"`
+		Topics []string `json:"topics,omitempty"`
+		Error  string   `json:"error,omitempty"`
+	}
+)
+
+func (a *Api) AddStreamRoutes() {
+	a.engine.GET("/stream/ws", a.streamWebsocket)
+	a.engine.GET("/stream/events", a.streamEvents)
+}
+
+// subscribe opens a subscription to the topics given in the request query.
+func (a *Api) subscribe(ctx *gin.Context) (*stream.Subscription, error) {
+	subscription, err := a.exchangeManager.Stream().Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	for _, param := range ctx.QueryArray("topic") {
+		err = a.addTopic(subscription, param)
+		if err != nil {
+			a.exchangeManager.Stream().Unsubscribe(subscription)
+			return nil, err
+		}
+	}
+	return subscription, nil
+}
+
+func (a *Api) addTopic(subscription *stream.Subscription, s string) error {
+	topic, err := a.parseTopic(s)
+	if err != nil {
+		return err
+	}
+	return subscription.Add(topic)
+}
+
+func (a *Api) parseTopic(s string) (*stream.Topic, error) {
+	topic, err := stream.ParseTopic(s)
+	if err != nil {
+		return nil, err
+	}
+	_, ok := a.exchanges[topic.Exchange]
+	if !ok {
+		return nil, fmt.Errorf("exchange not found")
+	}
+	return topic, nil
+}
+
+// streamWebsocket streams events over a WebSocket. Topics are given in the query
+// and changed with subscribe and unsubscribe messages. The connection is pinged
+// every heartbeat and closed when no pong arrives within two.
+func (a *Api) streamWebsocket(ctx *gin.Context) {
+	subscription, err := a.subscribe(ctx)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	defer a.exchangeManager.Stream().Unsubscribe(subscription)
+	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	heartbeat := a.exchangeManager.Stream().Heartbeat()
+	replies := make(chan *streamReply, 16)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(streamReadLimit)
+		conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+		})
+		for {
+			request := &streamRequest{}
+			err := conn.ReadJSON(request)
+			if err != nil {
+				return
+			}
+			reply := a.handleStreamRequest(subscription, request)
+			select {
+			case replies <- reply:
+			case <-subscription.Done():
+				return
+			}
+		}
+	}()
+	ping := time.NewTicker(heartbeat)
+	defer ping.Stop()
+	write := func(v any) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(v)
+	}
+	err = write(&streamReply{Type: "subscribed", Topics: subscription.Topics()})
+	for err == nil {
+		select {
+		case event := <-subscription.Events():
+			err = write(event)
+		case reply := <-replies:
+			err = write(reply)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+		case <-subscription.Done():
+			message := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, subscription.Reason())
+			conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(streamWriteTimeout))
+			return
+		case <-closed:
+			return
+		}
+	}
+}
+
+func (a *Api) handleStreamRequest(subscription *stream.Subscription, request *streamRequest) *streamReply {
+	reply := &streamReply{Topic: request.Topic}
+	topic, err := a.parseTopic(request.Topic)
+	if err == nil {
+		switch request.Action {
+		case "subscribe":
+			err = subscription.Add(topic)
+			reply.Type = "subscribed"
+		case "unsubscribe":
+			subscription.Remove(topic)
+			reply.Type = "unsubscribed"
+		default:
+			err = fmt.Errorf("invalid action '%s'", request.Action)
+		}
+	}
+	if err != nil {
+		reply.Type = "error"
+		reply.Error = err.Error()
+	}
+	return reply
+}
+
+// streamEvents streams events to the topics in the query as server-sent events,
+// named after their channel, with a comment line every heartbeat.
+func (a *Api) streamEvents(ctx *gin.Context) {
+	if len(ctx.QueryArray("topic")) == 0 {
+		ctx.JSON(400, gin.H{"error": "must provide at least one topic, e.g. ?topic=tickers:EXCHANGE"})
+		return
+	}
+	subscription, err := a.subscribe(ctx)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	defer a.exchangeManager.Stream().Unsubscribe(subscription)
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(200)
+	controller := http.NewResponseController(ctx.Writer)
+	heartbeat := time.NewTicker(a.exchangeManager.Stream().Heartbeat())
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-subscription.Events():
+			controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			ctx.SSEvent(event.Channel, event)
+		case <-heartbeat.C:
+			controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			fmt.Fprint(ctx.Writer, ": heartbeat\n\n")
+		case <-subscription.Done():
+			controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			ctx.SSEvent("error", gin.H{"error": subscription.Reason()})
+			controller.Flush()
+			return
+		case <-ctx.Request.Context().Done():
+			return
+		}
+		err = controller.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
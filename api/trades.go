@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/ericlagergren/decimal"
+	"github.com/gin-gonic/gin"
+)
+
+// queryTrades responds with a page of the stored trades of a pair. The range
+// is given by start and end, or by a period ending at end. The cursor of the
+// next page is returned while the page is full.
+func (a *Api) queryTrades(ctx *gin.Context, s store.Store, pair *token.Pair) {
+	query, err := parseTradesQuery(ctx, pair)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	// fetch one more trade to know whether there is a next page
+	limit := query.Limit
+	query.Limit++
+	trades, err := s.QueryTrades(query)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	res := gin.H{}
+	if len(trades) > limit {
+		trades = trades[:limit]
+		res["next_cursor"] = nextTradesCursor(query, trades)
+	}
+	res["trades"] = trades
+	ctx.JSON(200, res)
+}
+
+func parseTradesQuery(ctx *gin.Context, pair *token.Pair) (*store.TradesQuery, error) {
+	query := &store.TradesQuery{Pair: pair}
+	var err error
+	query.Limit, err = strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(TradesPerPage)))
+	if err != nil || query.Limit < 1 || query.Limit > MaxTrades {
+		return nil, fmt.Errorf("invalid limit, must be between 1 and %d", MaxTrades)
+	}
+	switch ctx.DefaultQuery("order", "desc") {
+	case "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return nil, fmt.Errorf("invalid order, must be asc or desc")
+	}
+	param := ctx.Query("min_size")
+	if param != "" {
+		minSize, ok := (&decimal.Big{}).SetString(param)
+		if !ok || !minSize.IsFinite() || minSize.Sign() < 0 {
+			return nil, fmt.Errorf("invalid min_size")
+		}
+		query.MinSize = minSize
+	}
+	period, err := time.ParseDuration(ctx.DefaultQuery("period", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid period")
+	}
+	endStr := ctx.DefaultQuery("end", "now")
+	if endStr == "now" {
+		query.End = time.Now()
+	} else {
+		query.End, err = time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end")
+		}
+	}
+	query.Start = query.End.Add(-period)
+	param = ctx.Query("start")
+	if param != "" {
+		query.Start, err = time.Parse(time.RFC3339, param)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start")
+		}
+	}
+	// the cursor moves the range past the trades already returned, keeping the
+	// other end of the range where the first page had it
+	param = ctx.Query("cursor")
+	if param != "" {
+		t, offset, bound, err := decodeTradesCursor(param)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		if query.Ascending {
+			query.Start, query.End = t, bound
+		} else {
+			query.Start, query.End = bound, t.Add(time.Nanosecond)
+		}
+		query.Offset = offset
+	}
+	return query, nil
+}
+
+// nextTradesCursor points after the last trade of the page, counting the trades
+// at its time that were already returned. It keeps the end of the range that
+// the pages move towards, which would otherwise follow the clock or period.
+func nextTradesCursor(query *store.TradesQuery, trades []*trading.Trade) string {
+	last := trades[len(trades)-1].Time
+	offset := 0
+	for _, trade := range trades {
+		if trade.Time.Equal(last) {
+			offset++
+		}
+	}
+	first, bound := query.Start, query.End
+	if !query.Ascending {
+		first, bound = query.End.Add(-time.Nanosecond), query.Start
+	}
+	if offset == len(trades) && last.Equal(first) {
+		offset += query.Offset
+	}
+	return encodeTradesCursor(last, offset, bound)
+}
+
+func encodeTradesCursor(t time.Time, offset int, bound time.Time) string {
+	cursor := t.UTC().Format(time.RFC3339Nano) + "," + strconv.Itoa(offset) + "," + bound.UTC().Format(time.RFC3339Nano)
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+func decodeTradesCursor(s string) (time.Time, int, time.Time, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return time.Time{}, 0, time.Time{}, err
+	}
+	parts := strings.SplitN(string(b), ",", 3)
+	if len(parts) != 3 {
+		return time.Time{}, 0, time.Time{}, fmt.Errorf("invalid cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, 0, time.Time{}, err
+	}
+	offset, err := strconv.Atoi(parts[1])
+	if err != nil || offset < 0 {
+		return time.Time{}, 0, time.Time{}, fmt.Errorf("invalid cursor")
+	}
+	bound, err := time.Parse(time.RFC3339Nano, parts[2])
+	if err != nil {
+		return time.Time{}, 0, time.Time{}, err
+	}
+	return t, offset, bound, nil
+}
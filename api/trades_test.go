@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"indexer/trading"
+)
+
+// readTradePages follows next_cursor from the first page of query, sending
+// only the order, the limit and the cursor along, and returns the transaction
+// hashes of the trades on each page.
+func readTradePages(t *testing.T, a *Api, query url.Values) [][]string {
+	t.Helper()
+	path := "/exchanges/" + testExchange + "/trades/ATOM/USDC?"
+	pages := [][]string{}
+	params := query
+	for len(pages) < 10 {
+		res := struct {
+			Trades []struct {
+				TxHash string `json:"tx_hash"`
+			} `json:"trades"`
+			NextCursor string `json:"next_cursor"`
+		}{}
+		get(t, a, path+params.Encode(), &res)
+		page := []string{}
+		for _, trade := range res.Trades {
+			page = append(page, trade.TxHash)
+		}
+		pages = append(pages, page)
+		if res.NextCursor == "" {
+			return pages
+		}
+		params = url.Values{"cursor": {res.NextCursor}}
+		for _, name := range []string{"order", "limit"} {
+			if query.Has(name) {
+				params.Set(name, query.Get(name))
+			}
+		}
+	}
+	t.Fatalf("still paging after %v", pages)
+	return nil
+}
+
+func TestQueryTradesPages(t *testing.T) {
+	trades := []*trading.Trade{}
+	for _, trade := range []struct {
+		hash   string
+		offset time.Duration
+	}{
+		{"a", time.Minute},
+		{"b", 2 * time.Minute},
+		{"c", 2 * time.Minute},
+		{"d", 2 * time.Minute},
+		{"e", 2 * time.Minute},
+		{"f", 3 * time.Minute},
+	} {
+		stored := newTestTrade(t, testStart.Add(trade.offset), "1", "10")
+		stored.TxHash = trade.hash
+		trades = append(trades, stored)
+	}
+	a := newTestApi(t, testStart.Add(5*time.Minute+30*time.Second), trades)
+	tests := []struct {
+		name  string
+		query url.Values
+		pages [][]string
+	}{
+		{
+			name:  "asc",
+			query: url.Values{"order": {"asc"}, "limit": {"2"}},
+			pages: [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}},
+		},
+		{
+			name:  "desc",
+			query: url.Values{"limit": {"2"}},
+			pages: [][]string{{"f", "e"}, {"d", "c"}, {"b", "a"}},
+		},
+		{
+			name:  "asc one at a time",
+			query: url.Values{"order": {"asc"}, "limit": {"1"}},
+			pages: [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}, {"f"}},
+		},
+		{
+			name:  "desc one at a time",
+			query: url.Values{"limit": {"1"}},
+			pages: [][]string{{"f"}, {"e"}, {"d"}, {"c"}, {"b"}, {"a"}},
+		},
+		{
+			name:  "asc full last page",
+			query: url.Values{"order": {"asc"}, "limit": {"3"}},
+			pages: [][]string{{"a", "b", "c"}, {"d", "e", "f"}},
+		},
+		{
+			name:  "desc full last page",
+			query: url.Values{"limit": {"3"}},
+			pages: [][]string{{"f", "e", "d"}, {"c", "b", "a"}},
+		},
+		{
+			name:  "asc within the range",
+			query: url.Values{"order": {"asc"}, "limit": {"2"}, "start": {minute(2)}, "end": {minute(3)}},
+			pages: [][]string{{"b", "c"}, {"d", "e"}},
+		},
+		{
+			name:  "desc within the range",
+			query: url.Values{"limit": {"3"}, "start": {minute(2)}, "end": {minute(3)}},
+			pages: [][]string{{"e", "d", "c"}, {"b"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{"end": {minute(10)}, "period": {"1h"}}
+			for name, values := range test.query {
+				query[name] = values
+			}
+			pages := readTradePages(t, a, query)
+			if !reflect.DeepEqual(pages, test.pages) {
+				t.Errorf("got pages %v, want %v", pages, test.pages)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package chain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/cometbft/cometbft/types"
+)
+
+const DefaultBlockTimeCacheSize = 256
+
+type BlockTimeCache struct {
+	mu      sync.Mutex
+	times   map[int64]time.Time
+	heights []int64
+	next    int
+}
+
+func NewBlockTimeCache(size int) *BlockTimeCache {
+	return &BlockTimeCache{
+		times:   make(map[int64]time.Time, size),
+		heights: make([]int64, size),
+	}
+}
+
+func (b *BlockTimeCache) Get(height int64) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.times[height]
+	return t, ok
+}
+
+func (b *BlockTimeCache) Set(height int64, t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.times[height]; ok {
+		return
+	}
+	// evict the oldest inserted height once the ring wraps around
+	delete(b.times, b.heights[b.next])
+	b.heights[b.next] = height
+	b.times[height] = t
+	b.next = (b.next + 1) % len(b.heights)
+}
+
+func EventHeight(event *coretypes.ResultEvent) (int64, error) {
+	if data, ok := event.Data.(types.EventDataTx); ok && data.Height > 0 {
+		return data.Height, nil
+	}
+	heights, ok := event.Events[types.TxHeightKey]
+	if !ok || len(heights) == 0 {
+		return 0, fmt.Errorf("event missing %s", types.TxHeightKey)
+	}
+	return strconv.ParseInt(heights[0], 10, 64)
+}
+
+func EventTxHash(event *coretypes.ResultEvent) string {
+	hashes, ok := event.Events[types.TxHashKey]
+	if ok && len(hashes) > 0 {
+		return strings.ToUpper(hashes[0])
+	}
+	if data, ok := event.Data.(types.EventDataTx); ok && len(data.Tx) > 0 {
+		return fmt.Sprintf("%X", types.Tx(data.Tx).Hash())
+	}
+	return ""
+}
@@ -2,18 +2,38 @@ package chain
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	abci "github.com/cometbft/cometbft/abci/types"
 	rpcclient "github.com/cometbft/cometbft/rpc/client"
 	rpchttp "github.com/cometbft/cometbft/rpc/client/http"
 	coretypes "github.com/cometbft/cometbft/rpc/core/types"
 	jsonrpcclient "github.com/cometbft/cometbft/rpc/jsonrpc/client"
+	"github.com/cometbft/cometbft/types"
 	"github.com/rs/zerolog"
 )
 
+const (
+	SubscriptionCapacity = 1000
+	StatusTimeout        = 5 * time.Second
+)
+
+type AppError struct {
+	Path string
+	Code uint32
+	Log  string
+}
+
+func (e *AppError) Error() string {
+	return fmt.Sprintf("query %s failed with code %d: %s", e.Path, e.Code, e.Log)
+}
+
 type CometRpc struct {
 	ctx context.Context
 	client rpcclient.Client
 	url string
+	blockTimes *BlockTimeCache
 	logger zerolog.Logger
 }
 
@@ -33,12 +53,28 @@ func NewCometRpc(url string, logger zerolog.Logger) (*CometRpc, error) {
 		ctx: context.Background(),
 		client: rpcClient,
 		url: url,
+		blockTimes: NewBlockTimeCache(DefaultBlockTimeCacheSize),
 		logger: cometLogger,
 	}
 	c.logger.Debug().Str("url", url).Msg("client connected")
 	return c, nil
 }
 
+func (c *CometRpc) Url() string {
+	return c.url
+}
+
+func (c *CometRpc) Status() (*coretypes.ResultStatus, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, StatusTimeout)
+	defer cancel()
+	status, err := c.client.Status(ctx)
+	if err != nil {
+		c.logger.Debug().Err(err).Str("method", "status").Msg("failed to get status")
+		return nil, err
+	}
+	return status, nil
+}
+
 func (c *CometRpc) Height() (int64, error) {
 	status, err := c.client.Status(c.ctx)
 	if err != nil {
@@ -59,16 +95,102 @@ func (c *CometRpc) Block(height int64) (*coretypes.ResultBlock, error) {
 	return block, nil
 }
 
-func (c *CometRpc) Subscribe(query string) (<-chan coretypes.ResultEvent, error) {
-	err := c.client.Start()
+func (c *CometRpc) BlockTime(height int64) (time.Time, error) {
+	blockTime, ok := c.blockTimes.Get(height)
+	if ok {
+		return blockTime, nil
+	}
+	header, err := c.client.Header(c.ctx, &height)
+	if err != nil {
+		c.logger.Error().Err(err).Str("method", "header").Int64("height", height).Msg("failed to get block header")
+		return time.Time{}, err
+	}
+	blockTime = header.Header.Time.UTC()
+	c.blockTimes.Set(height, blockTime)
+	c.logger.Trace().Int64("height", height).Time("time", blockTime).Msg("got block time")
+	return blockTime, nil
+}
+
+func (c *CometRpc) TxEvents(height int64) ([]coretypes.ResultEvent, error) {
+	block, err := c.Block(height)
+	if err != nil {
+		return nil, err
+	}
+	c.blockTimes.Set(height, block.Block.Time.UTC())
+	results, err := c.client.BlockResults(c.ctx, &height)
+	if err != nil {
+		c.logger.Error().Err(err).Str("method", "block_results").Int64("height", height).Msg("failed to get block results")
+		return nil, err
+	}
+	if len(results.TxsResults) != len(block.Block.Txs) {
+		return nil, fmt.Errorf("block %d has %d txs but %d results", height, len(block.Block.Txs), len(results.TxsResults))
+	}
+	events := make([]coretypes.ResultEvent, len(block.Block.Txs))
+	for i, tx := range block.Block.Txs {
+		result := results.TxsResults[i]
+		attributes := map[string][]string{
+			types.EventTypeKey: {types.EventTx},
+			types.TxHashKey:    {fmt.Sprintf("%X", tx.Hash())},
+			types.TxHeightKey:  {fmt.Sprintf("%d", height)},
+		}
+		for _, event := range result.Events {
+			for _, attribute := range event.Attributes {
+				key := event.Type + "." + attribute.Key
+				attributes[key] = append(attributes[key], attribute.Value)
+			}
+		}
+		events[i] = coretypes.ResultEvent{
+			Data: types.EventDataTx{TxResult: abci.TxResult{
+				Height: height,
+				Index:  uint32(i),
+				Tx:     tx,
+				Result: *result,
+			}},
+			Events: attributes,
+		}
+	}
+	c.logger.Debug().Int64("height", height).Int("num_txs", len(events)).Msg("got tx events")
+	return events, nil
+}
+
+func (c *CometRpc) AbciQuery(path string, data []byte) ([]byte, error) {
+	result, err := c.client.ABCIQuery(c.ctx, path, data)
 	if err != nil {
-		c.logger.Error().Err(err).Str("method", "start").Msg("failed to start client")
+		c.logger.Error().Err(err).Str("method", "abci_query").Str("path", path).Msg("failed to query app")
 		return nil, err
 	}
-	channel, err := c.client.Subscribe(c.ctx, "", query)
+	if result.Response.Code != 0 {
+		return nil, &AppError{Path: path, Code: result.Response.Code, Log: result.Response.Log}
+	}
+	c.logger.Trace().Str("path", path).Int("size", len(result.Response.Value)).Msg("got query response")
+	return result.Response.Value, nil
+}
+
+func (c *CometRpc) Subscribe(query string) (<-chan coretypes.ResultEvent, error) {
+	if !c.client.IsRunning() {
+		err := c.client.Start()
+		if err != nil {
+			c.logger.Error().Err(err).Str("method", "start").Msg("failed to start client")
+			return nil, err
+		}
+	}
+	channel, err := c.client.Subscribe(c.ctx, "", query, SubscriptionCapacity)
 	if err != nil {
 		c.logger.Error().Err(err).Str("method", "subscribe").Msg("failed to subscribe")
 	}
 	c.logger.Debug().Str("query", query).Msg("subscribed")
 	return channel, err
-}
\ No newline at end of file
+}
+
+func (c *CometRpc) Unsubscribe(query string) error {
+	if !c.client.IsRunning() {
+		return nil
+	}
+	err := c.client.Unsubscribe(c.ctx, "", query)
+	if err != nil {
+		c.logger.Error().Err(err).Str("method", "unsubscribe").Msg("failed to unsubscribe")
+		return err
+	}
+	c.logger.Debug().Str("query", query).Msg("unsubscribed")
+	return nil
+}
@@ -0,0 +1,29 @@
+package chain
+
+import (
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/cometbft/cometbft/types"
+)
+
+// EventAttributes returns the attributes of every event of the given type in a tx,
+// in emission order. Events received without tx result data only carry the
+// flattened attribute map, which cannot be split back into events reliably, so
+// they yield no attributes.
+func EventAttributes(event *coretypes.ResultEvent, eventType string) []map[string]string {
+	events := []map[string]string{}
+	data, ok := event.Data.(types.EventDataTx)
+	if !ok {
+		return events
+	}
+	for _, e := range data.Result.Events {
+		if e.Type != eventType {
+			continue
+		}
+		attributes := make(map[string]string, len(e.Attributes))
+		for _, attribute := range e.Attributes {
+			attributes[attribute.Key] = attribute.Value
+		}
+		events = append(events, attributes)
+	}
+	return events
+}
@@ -0,0 +1,490 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"indexer/config"
+
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/rs/zerolog"
+)
+
+const (
+	DefaultHealthCheckInterval = 30 * time.Second
+	DefaultMaxHeightLag        = 5
+	DefaultMaxBlockAge         = time.Minute
+)
+
+type (
+	PoolOptions struct {
+		HealthCheckInterval time.Duration
+		MaxHeightLag        int64
+		MaxBlockAge         time.Duration
+		Record              RecorderOptions
+	}
+
+	EndpointStatus struct {
+		Url       string        `json:"url"`
+		Active    bool          `json:"active"`
+		Healthy   bool          `json:"healthy"`
+		Height    int64         `json:"height"`
+		BlockTime time.Time     `json:"block_time"`
+		Latency   time.Duration `json:"latency"`
+		Failures  int           `json:"failures"`
+		Checked   time.Time     `json:"checked"`
+		Error     string        `json:"error,omitempty"`
+	}
+
+	// SubscriptionEvent is an event received from a pool subscription, or, with
+	// Missed set, a marker that events may have been lost since the last one.
+	SubscriptionEvent struct {
+		Event  coretypes.ResultEvent
+		Missed bool
+	}
+
+	endpoint struct {
+		rpc    *CometRpc
+		status EndpointStatus
+	}
+
+	CometPool struct {
+		mu        sync.RWMutex
+		name      string
+		endpoints []*endpoint
+		active    int
+		switched  chan struct{}
+		options   PoolOptions
+		recorder  *Recorder
+		records   chan *coretypes.ResultEvent
+		logger    zerolog.Logger
+	}
+)
+
+func NewCometPool(name string, urls []string, options PoolOptions, logger zerolog.Logger) (*CometPool, error) {
+	poolLogger := logger.With().Str("chain", name).Logger()
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no rpc endpoints configured for chain %s", name)
+	}
+	if options.HealthCheckInterval <= 0 {
+		options.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if options.MaxHeightLag <= 0 {
+		options.MaxHeightLag = DefaultMaxHeightLag
+	}
+	if options.MaxBlockAge <= 0 {
+		options.MaxBlockAge = DefaultMaxBlockAge
+	}
+	endpoints := make([]*endpoint, len(urls))
+	for i, url := range urls {
+		rpc, err := NewCometRpc(url, poolLogger.With().Str("rpc", url).Logger())
+		if err != nil {
+			return nil, err
+		}
+		endpoints[i] = &endpoint{
+			rpc:    rpc,
+			status: EndpointStatus{Url: url, Healthy: true},
+		}
+	}
+	p := &CometPool{
+		name:      name,
+		endpoints: endpoints,
+		switched:  make(chan struct{}),
+		options:   options,
+		logger:    poolLogger,
+	}
+	if options.Record.Dir != "" {
+		recorder, err := NewRecorder(name, options.Record, poolLogger)
+		if err != nil {
+			return nil, err
+		}
+		p.recorder = recorder
+		p.records = make(chan *coretypes.ResultEvent, SubscriptionCapacity)
+		go p.recordEvents()
+	}
+	p.CheckHealth()
+	go func() {
+		for {
+			time.Sleep(p.options.HealthCheckInterval)
+			p.CheckHealth()
+		}
+	}()
+	return p, nil
+}
+
+func (p *CometPool) Name() string {
+	return p.name
+}
+
+func (p *CometPool) ActiveUrl() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.endpoints[p.active].status.Url
+}
+
+func (p *CometPool) Status() []EndpointStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	statuses := make([]EndpointStatus, len(p.endpoints))
+	for i, e := range p.endpoints {
+		statuses[i] = e.status
+		statuses[i].Active = i == p.active
+	}
+	return statuses
+}
+
+// CheckHealth queries every endpoint's status, marks endpoints that error, lag the
+// highest known height or report an old block as unhealthy, then fails over if
+// the active endpoint is no longer healthy.
+func (p *CometPool) CheckHealth() {
+	type result struct {
+		status  *coretypes.ResultStatus
+		latency time.Duration
+		err     error
+	}
+	results := make([]result, len(p.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range p.endpoints {
+		wg.Add(1)
+		go func(i int, rpc *CometRpc) {
+			defer wg.Done()
+			start := time.Now()
+			status, err := rpc.Status()
+			results[i] = result{status: status, latency: time.Since(start), err: err}
+		}(i, e.rpc)
+	}
+	wg.Wait()
+	now := time.Now().UTC()
+	var maxHeight int64
+	for _, r := range results {
+		if r.err == nil && r.status.SyncInfo.LatestBlockHeight > maxHeight {
+			maxHeight = r.status.SyncInfo.LatestBlockHeight
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, e := range p.endpoints {
+		r := results[i]
+		e.status.Checked = now
+		e.status.Latency = r.latency
+		if r.err != nil {
+			e.status.Healthy = false
+			e.status.Failures++
+			e.status.Error = r.err.Error()
+			continue
+		}
+		e.status.Height = r.status.SyncInfo.LatestBlockHeight
+		e.status.BlockTime = r.status.SyncInfo.LatestBlockTime.UTC()
+		switch {
+		case r.status.SyncInfo.CatchingUp:
+			e.status.Healthy = false
+			e.status.Error = "catching up"
+		case maxHeight-e.status.Height > p.options.MaxHeightLag:
+			e.status.Healthy = false
+			e.status.Error = fmt.Sprintf("height lags by %d blocks", maxHeight-e.status.Height)
+		case now.Sub(e.status.BlockTime) > p.options.MaxBlockAge:
+			e.status.Healthy = false
+			e.status.Error = fmt.Sprintf("latest block is %s old", now.Sub(e.status.BlockTime).Truncate(time.Second))
+		default:
+			e.status.Healthy = true
+			e.status.Failures = 0
+			e.status.Error = ""
+		}
+	}
+	if !p.endpoints[p.active].status.Healthy {
+		p.failover()
+	}
+	p.logger.Trace().Str("active", p.endpoints[p.active].status.Url).Int64("height", maxHeight).Msg("checked endpoint health")
+}
+
+// failover switches to the healthy endpoint with the lowest latency, falling back to
+// the endpoint with the fewest failures. Must be called with the lock held.
+func (p *CometPool) failover() {
+	order := make([]int, len(p.endpoints))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a := p.endpoints[order[i]].status
+		b := p.endpoints[order[j]].status
+		if a.Healthy != b.Healthy {
+			return a.Healthy
+		}
+		if a.Healthy {
+			return a.Latency < b.Latency
+		}
+		return a.Failures < b.Failures
+	})
+	next := order[0]
+	if next == p.active {
+		return
+	}
+	p.logger.Warn().
+		Str("from", p.endpoints[p.active].status.Url).
+		Str("to", p.endpoints[next].status.Url).
+		Str("reason", p.endpoints[p.active].status.Error).
+		Msg("switching rpc endpoint")
+	p.active = next
+	close(p.switched)
+	p.switched = make(chan struct{})
+}
+
+func (p *CometPool) current() (*CometRpc, <-chan struct{}) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.endpoints[p.active].rpc, p.switched
+}
+
+func (p *CometPool) fail(rpc *CometRpc, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.endpoints {
+		if e.rpc == rpc {
+			e.status.Healthy = false
+			e.status.Failures++
+			e.status.Error = err.Error()
+		}
+	}
+	if p.endpoints[p.active].rpc == rpc {
+		p.failover()
+	}
+}
+
+// do runs fn against the active endpoint, failing over and retrying once per
+// endpoint on error.
+func (p *CometPool) do(fn func(rpc *CometRpc) error) error {
+	var err error
+	for range p.endpoints {
+		rpc, _ := p.current()
+		err = fn(rpc)
+		if err == nil {
+			return nil
+		}
+		p.fail(rpc, err)
+	}
+	return err
+}
+
+func (p *CometPool) Height() (int64, error) {
+	var height int64
+	err := p.do(func(rpc *CometRpc) (err error) {
+		height, err = rpc.Height()
+		return err
+	})
+	return height, err
+}
+
+func (p *CometPool) Block(height int64) (*coretypes.ResultBlock, error) {
+	var block *coretypes.ResultBlock
+	err := p.do(func(rpc *CometRpc) (err error) {
+		block, err = rpc.Block(height)
+		return err
+	})
+	return block, err
+}
+
+func (p *CometPool) BlockTime(height int64) (time.Time, error) {
+	var blockTime time.Time
+	err := p.do(func(rpc *CometRpc) (err error) {
+		blockTime, err = rpc.BlockTime(height)
+		return err
+	})
+	return blockTime, err
+}
+
+// SetBlockTime seeds the block time caches, e.g. from recorded events.
+func (p *CometPool) SetBlockTime(height int64, blockTime time.Time) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, e := range p.endpoints {
+		e.rpc.blockTimes.Set(height, blockTime)
+	}
+}
+
+func (p *CometPool) TxEvents(height int64) ([]coretypes.ResultEvent, error) {
+	var events []coretypes.ResultEvent
+	err := p.do(func(rpc *CometRpc) (err error) {
+		events, err = rpc.TxEvents(height)
+		return err
+	})
+	return events, err
+}
+
+// AbciQuery does not fail over on query errors reported by the app, since those
+// would be returned by every endpoint.
+func (p *CometPool) AbciQuery(path string, data []byte) ([]byte, error) {
+	var value []byte
+	var queryErr error
+	err := p.do(func(rpc *CometRpc) error {
+		value, queryErr = rpc.AbciQuery(path, data)
+		if queryErr != nil && errors.As(queryErr, new(*AppError)) {
+			return nil
+		}
+		return queryErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, queryErr
+}
+
+// Subscribe returns a channel of events for query that survives failover by
+// resubscribing on the newly active endpoint. Events are dropped rather than
+// left to back up when the subscriber falls behind. Either way the next event
+// sent is a Missed marker.
+func (p *CometPool) Subscribe(query string) (<-chan SubscriptionEvent, error) {
+	rpc, switched := p.current()
+	in, err := rpc.Subscribe(query)
+	if err != nil {
+		p.fail(rpc, err)
+		rpc, switched = p.current()
+		in, err = rpc.Subscribe(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := make(chan SubscriptionEvent, SubscriptionCapacity)
+	go func() {
+		missed := false
+		for {
+			select {
+			case event, ok := <-in:
+				if !ok {
+					p.fail(rpc, fmt.Errorf("subscription closed"))
+					rpc, switched, in = p.resubscribe(rpc, query)
+					missed = true
+					continue
+				}
+				p.Record(&event)
+				if missed {
+					select {
+					case out <- SubscriptionEvent{Missed: true}:
+						missed = false
+					default:
+					}
+				}
+				if missed {
+					continue
+				}
+				select {
+				case out <- SubscriptionEvent{Event: event}:
+				default:
+					p.logger.Warn().Str("query", query).Msg("subscriber fell behind, dropping events")
+					missed = true
+				}
+			case <-switched:
+				rpc.Unsubscribe(query)
+				rpc, switched, in = p.resubscribe(rpc, query)
+				missed = true
+			}
+		}
+	}()
+	return out, nil
+}
+
+// resubscribe subscribes to query on the active endpoint, which may still be
+// the previous one if no other is healthier, retrying until it succeeds.
+func (p *CometPool) resubscribe(previous *CometRpc, query string) (*CometRpc, <-chan struct{}, <-chan coretypes.ResultEvent) {
+	for {
+		rpc, switched := p.current()
+		if rpc == previous {
+			// a closed subscription is still registered with the client
+			rpc.Unsubscribe(query)
+		}
+		in, err := rpc.Subscribe(query)
+		if err == nil {
+			p.logger.Info().Str("rpc", rpc.Url()).Str("query", query).Msg("resubscribed")
+			return rpc, switched, in
+		}
+		p.fail(rpc, err)
+		time.Sleep(p.options.HealthCheckInterval)
+	}
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = map[string]*CometPool{}
+)
+
+// Record queues an event to be written to the event archive, if recording is
+// enabled. Events are dropped rather than left to hold up the caller when the
+// recorder falls behind.
+func (p *CometPool) Record(event *coretypes.ResultEvent) {
+	if p.recorder == nil {
+		return
+	}
+	select {
+	case p.records <- event:
+	default:
+		p.logger.Warn().Msg("recorder fell behind, dropping event")
+	}
+}
+
+// recordEvents writes queued events with their block time, which may have to be
+// fetched from the chain.
+func (p *CometPool) recordEvents() {
+	for event := range p.records {
+		p.record(event)
+	}
+}
+
+func (p *CometPool) record(event *coretypes.ResultEvent) {
+	height, err := EventHeight(event)
+	if err != nil {
+		p.logger.Warn().Err(err).Msg("recording event without height")
+	}
+	var blockTime time.Time
+	if height > 0 {
+		blockTime, err = p.BlockTime(height)
+		if err != nil {
+			p.logger.Warn().Err(err).Int64("height", height).Msg("recording event without block time")
+		}
+	}
+	err = p.recorder.Record(event, height, blockTime)
+	if err != nil {
+		p.logger.Error().Err(err).Msg("failed to record event")
+	}
+}
+
+// PoolFromConfig returns the shared pool for the named chain, creating it from
+// the [chain.<name>] config section on first use.
+func PoolFromConfig(name string, logger zerolog.Logger) (*CometPool, error) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	pool, ok := pools[name]
+	if ok {
+		return pool, nil
+	}
+	cfg, ok := config.Cfg.ChainConfig[name]
+	if !ok {
+		return nil, fmt.Errorf("chain not configured: %s", name)
+	}
+	pool, err := NewCometPool(name, cfg.Rpcs, PoolOptions{
+		HealthCheckInterval: cfg.HealthCheckInterval,
+		MaxHeightLag:        cfg.MaxHeightLag,
+		MaxBlockAge:         cfg.MaxBlockAge,
+		Record: RecorderOptions{
+			Dir:     cfg.RecordDir,
+			MaxSize: cfg.RecordMaxSize,
+			MaxAge:  cfg.RecordMaxAge,
+		},
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	pools[name] = pool
+	return pool, nil
+}
+
+func Pools() []*CometPool {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	list := make([]*CometPool, 0, len(pools))
+	for _, pool := range pools {
+		list = append(list, pool)
+	}
+	return list
+}
@@ -0,0 +1,232 @@
+package chain
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	rpctypes "github.com/cometbft/cometbft/rpc/jsonrpc/types"
+	"github.com/cometbft/cometbft/types"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+)
+
+const testQuery = "tm.event='Tx'"
+
+// fakeRpc serves the subset of the CometBFT RPC used by the pool: status over
+// JSON-RPC and event subscriptions over a WebSocket.
+type fakeRpc struct {
+	mu         sync.Mutex
+	server     *httptest.Server
+	height     int64
+	blockTime  time.Time
+	subscribed chan string
+	// subscriptions holds the subscribe request of each connection
+	subscriptions map[*websocket.Conn]rpctypes.RPCRequest
+}
+
+func newFakeRpc(t *testing.T, height int64) *fakeRpc {
+	f := &fakeRpc{
+		height:        height,
+		blockTime:     time.Now(),
+		subscribed:    make(chan string, 16),
+		subscriptions: map[*websocket.Conn]rpctypes.RPCRequest{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", f.serveHttp)
+	mux.HandleFunc("/websocket", f.serveWebsocket)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeRpc) setHeight(height int64, blockTime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.height = height
+	f.blockTime = blockTime
+}
+
+func (f *fakeRpc) status() *coretypes.ResultStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &coretypes.ResultStatus{SyncInfo: coretypes.SyncInfo{
+		LatestBlockHeight: f.height,
+		LatestBlockTime:   f.blockTime,
+	}}
+}
+
+func (f *fakeRpc) serveHttp(w http.ResponseWriter, r *http.Request) {
+	request := rpctypes.RPCRequest{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var response rpctypes.RPCResponse
+	switch request.Method {
+	case "status":
+		response = rpctypes.NewRPCSuccessResponse(request.ID, f.status())
+	default:
+		response = rpctypes.NewRPCErrorResponse(request.ID, -32601, "method not found", request.Method)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (f *fakeRpc) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	for {
+		request := rpctypes.RPCRequest{}
+		err := conn.ReadJSON(&request)
+		if err != nil {
+			return
+		}
+		params := struct {
+			Query string `json:"query"`
+		}{}
+		json.Unmarshal(request.Params, &params)
+		f.mu.Lock()
+		conn.WriteJSON(rpctypes.NewRPCSuccessResponse(request.ID, struct{}{}))
+		if request.Method == "subscribe" {
+			f.subscriptions[conn] = request
+		}
+		f.mu.Unlock()
+		if request.Method == "subscribe" {
+			f.subscribed <- params.Query
+		}
+	}
+}
+
+// publish sends a transaction event at height to every subscribed client.
+func (f *fakeRpc) publish(height int64) {
+	event := &coretypes.ResultEvent{
+		Query: testQuery,
+		Data:  types.EventDataTx{},
+		Events: map[string][]string{
+			types.EventTypeKey: {types.EventTx},
+			types.TxHeightKey:  {strconv.FormatInt(height, 10)},
+		},
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn, request := range f.subscriptions {
+		// like CometBFT, events are sent as responses to the subscribe request
+		conn.WriteJSON(rpctypes.NewRPCSuccessResponse(request.ID, event))
+	}
+}
+
+func (f *fakeRpc) waitSubscribed(t *testing.T) {
+	select {
+	case query := <-f.subscribed:
+		if query != testQuery {
+			t.Fatalf("subscribed to %q, want %q", query, testQuery)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no subscription received")
+	}
+}
+
+func newTestPool(t *testing.T, rpcs ...*fakeRpc) *CometPool {
+	urls := make([]string, len(rpcs))
+	for i, rpc := range rpcs {
+		urls[i] = rpc.server.URL
+	}
+	pool, err := NewCometPool("test", urls, PoolOptions{
+		HealthCheckInterval: time.Hour,
+		MaxHeightLag:        5,
+		MaxBlockAge:         time.Minute,
+	}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func receive(t *testing.T, events <-chan SubscriptionEvent) SubscriptionEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return SubscriptionEvent{}
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	healthy := newFakeRpc(t, 100)
+	old := newFakeRpc(t, 100)
+	old.setHeight(100, time.Now().Add(-time.Hour))
+	pool := newTestPool(t, healthy, old)
+	statuses := pool.Status()
+	if !statuses[0].Healthy || !statuses[0].Active || statuses[0].Height != 100 {
+		t.Errorf("first endpoint: got %+v, want healthy and active at height 100", statuses[0])
+	}
+	if statuses[1].Healthy || statuses[1].Error == "" {
+		t.Errorf("endpoint with an old block: got %+v, want unhealthy", statuses[1])
+	}
+	old.server.Close()
+	pool.CheckHealth()
+	statuses = pool.Status()
+	if statuses[1].Healthy || statuses[1].Failures != 1 {
+		t.Errorf("unreachable endpoint: got %+v, want unhealthy with one failure", statuses[1])
+	}
+}
+
+func TestFailoverOnStaleHeight(t *testing.T) {
+	first := newFakeRpc(t, 100)
+	second := newFakeRpc(t, 100)
+	pool := newTestPool(t, first, second)
+	if pool.ActiveUrl() != first.server.URL {
+		t.Fatalf("active endpoint is %s, want %s", pool.ActiveUrl(), first.server.URL)
+	}
+	second.setHeight(110, time.Now())
+	pool.CheckHealth()
+	if pool.ActiveUrl() != second.server.URL {
+		t.Fatalf("active endpoint is %s after the first fell 10 blocks behind, want %s", pool.ActiveUrl(), second.server.URL)
+	}
+	height, err := pool.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 110 {
+		t.Errorf("got height %d, want 110", height)
+	}
+}
+
+func TestResubscribeAfterFailover(t *testing.T) {
+	first := newFakeRpc(t, 100)
+	second := newFakeRpc(t, 100)
+	pool := newTestPool(t, first, second)
+	events, err := pool.Subscribe(testQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.waitSubscribed(t)
+	first.publish(100)
+	event := receive(t, events)
+	height, err := EventHeight(&event.Event)
+	if event.Missed || err != nil || height != 100 {
+		t.Fatalf("got event %+v, want the event at height 100", event)
+	}
+	second.setHeight(110, time.Now())
+	pool.CheckHealth()
+	second.waitSubscribed(t)
+	second.publish(110)
+	event = receive(t, events)
+	if !event.Missed {
+		t.Fatalf("got event %+v after failover, want a missed marker", event)
+	}
+	event = receive(t, events)
+	height, err = EventHeight(&event.Event)
+	if event.Missed || err != nil || height != 110 {
+		t.Fatalf("got event %+v, want the event at height 110 from the new endpoint", event)
+	}
+}
@@ -0,0 +1,215 @@
+package chain
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cmtjson "github.com/cometbft/cometbft/libs/json"
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/rs/zerolog"
+)
+
+const (
+	DefaultRecordMaxSize = 64 << 20
+	DefaultRecordMaxAge  = time.Hour
+
+	RecordFileExt    = ".jsonl.gz"
+	recordPartialExt = ".part"
+)
+
+type (
+	RecorderOptions struct {
+		Dir     string
+		MaxSize int64
+		MaxAge  time.Duration
+	}
+
+	// RecordedEvent is one line of an event archive. Block time is recorded when
+	// known so that reprocessing does not depend on the chain keeping old blocks.
+	RecordedEvent struct {
+		Height    int64                 `json:"height"`
+		BlockTime time.Time             `json:"block_time"`
+		Received  time.Time             `json:"received"`
+		Event     coretypes.ResultEvent `json:"event"`
+	}
+
+	// Recorder writes received events to gzipped JSON lines files, starting a new
+	// file once the current one reaches the max size or age. Files are suffixed
+	// with .part until they are rotated out.
+	Recorder struct {
+		mu      sync.Mutex
+		name    string
+		options RecorderOptions
+		file    *os.File
+		gzip    *gzip.Writer
+		path    string
+		written int64
+		opened  time.Time
+		logger  zerolog.Logger
+	}
+)
+
+func NewRecorder(name string, options RecorderOptions, logger zerolog.Logger) (*Recorder, error) {
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultRecordMaxSize
+	}
+	if options.MaxAge <= 0 {
+		options.MaxAge = DefaultRecordMaxAge
+	}
+	err := os.MkdirAll(options.Dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		name:    name,
+		options: options,
+		logger:  logger.With().Str("recorder", name).Logger(),
+	}
+	r.logger.Info().Str("dir", options.Dir).Msg("recording events")
+	return r, nil
+}
+
+func (r *Recorder) Record(event *coretypes.ResultEvent, height int64, blockTime time.Time) error {
+	b, err := cmtjson.Marshal(RecordedEvent{
+		Height:    height,
+		BlockTime: blockTime,
+		Received:  time.Now().UTC(),
+		Event:     *event,
+	})
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.gzip == nil || r.written >= r.options.MaxSize || time.Since(r.opened) >= r.options.MaxAge {
+		err = r.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	n, err := r.gzip.Write(append(b, '\n'))
+	r.written += int64(n)
+	if err != nil {
+		return err
+	}
+	// flush every event so a crash loses at most the event being written
+	return r.gzip.Flush()
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.close()
+}
+
+func (r *Recorder) rotate() error {
+	err := r.close()
+	if err != nil {
+		r.logger.Error().Err(err).Str("file", r.path).Msg("failed to close event archive")
+	}
+	now := time.Now().UTC()
+	path := filepath.Join(r.options.Dir, fmt.Sprintf("%s-%s%s", r.name, now.Format("20060102T150405.000000000Z"), RecordFileExt))
+	file, err := os.Create(path + recordPartialExt)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.gzip = gzip.NewWriter(file)
+	r.path = path
+	r.written = 0
+	r.opened = now
+	r.logger.Debug().Str("file", path).Msg("opened event archive")
+	return nil
+}
+
+func (r *Recorder) close() error {
+	if r.gzip == nil {
+		return nil
+	}
+	err := r.gzip.Close()
+	if err == nil {
+		err = r.file.Close()
+	} else {
+		r.file.Close()
+	}
+	r.gzip = nil
+	r.file = nil
+	if err != nil {
+		return err
+	}
+	return os.Rename(r.path+recordPartialExt, r.path)
+}
+
+// RecordFiles expands directories into the event archives they contain, in
+// recording order. Unfinished .part files are included.
+func RecordFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		dirFiles := []string{}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !(strings.HasSuffix(name, RecordFileExt) || strings.HasSuffix(name, RecordFileExt+recordPartialExt)) {
+				continue
+			}
+			dirFiles = append(dirFiles, filepath.Join(path, name))
+		}
+		sort.Strings(dirFiles)
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
+
+// ReadRecordedEvents calls fn for every event in an archive. Archives cut short
+// by a crash are read up to the last complete event.
+func ReadRecordedEvents(path string, fn func(*RecordedEvent) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadBytes('\n')
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		event := &RecordedEvent{}
+		err = cmtjson.Unmarshal(line, event)
+		if err != nil {
+			return fmt.Errorf("invalid event in %s: %v", path, err)
+		}
+		err = fn(event)
+		if err != nil {
+			return err
+		}
+	}
+}
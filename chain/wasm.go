@@ -0,0 +1,143 @@
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	WasmSmartContractStatePath = "/cosmwasm.wasm.v1.Query/SmartContractState"
+	WasmContractsByCodePath    = "/cosmwasm.wasm.v1.Query/ContractsByCode"
+	wasmContractsByCodeLimit   = 100
+)
+
+// WasmSmartQuery runs a JSON smart query against a CosmWasm contract and decodes
+// the JSON response into result.
+func (p *CometPool) WasmSmartQuery(contract string, query any, result any) error {
+	queryData, err := json.Marshal(query)
+	if err != nil {
+		return err
+	}
+	req := protowire.AppendTag(nil, 1, protowire.BytesType)
+	req = protowire.AppendString(req, contract)
+	req = protowire.AppendTag(req, 2, protowire.BytesType)
+	req = protowire.AppendBytes(req, queryData)
+	res, err := p.AbciQuery(WasmSmartContractStatePath, req)
+	if err != nil {
+		return err
+	}
+	fields, err := ParseProtoFields(res)
+	if err != nil {
+		return err
+	}
+	data := fields.Bytes(1)
+	if data == nil {
+		return fmt.Errorf("empty smart query response from %s", contract)
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (p *CometPool) WasmContractsByCode(codeId uint64) ([]string, error) {
+	contracts := []string{}
+	var nextKey []byte
+	for {
+		pagination := []byte{}
+		if len(nextKey) > 0 {
+			pagination = protowire.AppendTag(pagination, 1, protowire.BytesType)
+			pagination = protowire.AppendBytes(pagination, nextKey)
+		}
+		pagination = protowire.AppendTag(pagination, 3, protowire.VarintType)
+		pagination = protowire.AppendVarint(pagination, wasmContractsByCodeLimit)
+		req := protowire.AppendTag(nil, 1, protowire.VarintType)
+		req = protowire.AppendVarint(req, codeId)
+		req = protowire.AppendTag(req, 2, protowire.BytesType)
+		req = protowire.AppendBytes(req, pagination)
+		res, err := p.AbciQuery(WasmContractsByCodePath, req)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := ParseProtoFields(res)
+		if err != nil {
+			return nil, err
+		}
+		for _, contract := range fields.All(1) {
+			contracts = append(contracts, string(contract.Bytes))
+		}
+		pageFields, err := ParseProtoFields(fields.Bytes(2))
+		if err != nil {
+			return nil, err
+		}
+		nextKey = pageFields.Bytes(1)
+		if len(nextKey) == 0 {
+			return contracts, nil
+		}
+	}
+}
+
+type (
+	ProtoField struct {
+		Number protowire.Number
+		Varint uint64
+		Bytes  []byte
+	}
+
+	ProtoFields []ProtoField
+)
+
+// ParseProtoFields decodes the top level fields of a protobuf message, which is
+// enough to read the few query responses used without generated types.
+func ParseProtoFields(b []byte) (ProtoFields, error) {
+	fields := ProtoFields{}
+	for len(b) > 0 {
+		number, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		field := ProtoField{Number: number}
+		switch typ {
+		case protowire.VarintType:
+			field.Varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			field.Bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(number, typ, b)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func (f ProtoFields) Bytes(number protowire.Number) []byte {
+	for _, field := range f {
+		if field.Number == number {
+			return field.Bytes
+		}
+	}
+	return nil
+}
+
+func (f ProtoFields) Varint(number protowire.Number) uint64 {
+	for _, field := range f {
+		if field.Number == number {
+			return field.Varint
+		}
+	}
+	return 0
+}
+
+func (f ProtoFields) All(number protowire.Number) []ProtoField {
+	all := []ProtoField{}
+	for _, field := range f {
+		if field.Number == number {
+			all = append(all, field)
+		}
+	}
+	return all
+}
@@ -1,10 +1,14 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/rs/zerolog"
 )
 
@@ -19,9 +23,17 @@ type (
 		OsmosisAssetsJsonUrl         string
 		OsmosisAssetsRefreshInterval string
 		OsmosisAssetsRetryInterval   string
+		OsmosisRpcs                  string
+		FinAssetsJsonUrl             string
+		FinAssetsRefreshInterval     string
+		FinAssetsRetryInterval       string
+		FinCodeIds                   string
+		FinContracts                 string
+		KujiraRpcs                   string
 		TradesMaxAge                 string
 		CandlesInterval              string
 		CandlesPeriod                string
+		AssetsCacheDir               string
 	}
 
 	StoreConfig struct {
@@ -31,25 +43,118 @@ type (
 		Path         string `toml:"path"`
 	}
 
+	ChainConfig struct {
+		Rpcs                []string      `toml:"rpcs"`
+		HealthCheckInterval time.Duration `toml:"health_check_interval"`
+		MaxHeightLag        int64         `toml:"max_height_lag"`
+		MaxBlockAge         time.Duration `toml:"max_block_age"`
+		RecordDir           string        `toml:"record_dir"`
+		RecordMaxSize       int64         `toml:"record_max_size"`
+		RecordMaxAge        time.Duration `toml:"record_max_age"`
+	}
+
 	ExchangeConfig struct {
-		AssetsUrl             string        `toml:"assets_url"`
-		AssetsRefreshInterval time.Duration `toml:"assets_refresh_interval"`
-		AssetsRetryInterval   time.Duration `toml:"assets_retry_interval"`
+		Type                  string            `toml:"type"`
+		Chain                 string            `toml:"chain"`
+		AssetsUrl             string            `toml:"assets_url"`
+		AssetsFiles           []string          `toml:"assets_files"`
+		Symbols               map[string]string `toml:"symbols"`
+		AssetsRefreshInterval time.Duration     `toml:"assets_refresh_interval"`
+		AssetsRetryInterval   time.Duration     `toml:"assets_retry_interval"`
+	}
+
+	// PricesConfig controls the cross-exchange price index. Sources whose price
+	// deviates from the median by more than MaxDeviation, a fraction, or whose
+	// last trade is older than MaxAge are left out. Routed prices go through at
+	// most MaxHops pairs and may target any asset a quote alias stands for.
+	PricesConfig struct {
+		Mode         string              `toml:"mode"`
+		Exchanges    []string            `toml:"exchanges"`
+		MaxDeviation float64             `toml:"max_deviation"`
+		MaxAge       time.Duration       `toml:"max_age"`
+		MinSources   int                 `toml:"min_sources"`
+		MaxHops      int                 `toml:"max_hops"`
+		QuoteAliases map[string][]string `toml:"quote_aliases"`
 	}
 
+	// ValuationConfig sets the reference currency volumes are valued in, the
+	// stablecoins pegged to it and an optional feed of reference prices. Pairs
+	// whose last trade is older than MaxAge are not used to route prices.
+	ValuationConfig struct {
+		Currency     string        `toml:"currency"`
+		Stablecoins  []string      `toml:"stablecoins"`
+		FeedUrl      string        `toml:"feed_url"`
+		FeedInterval time.Duration `toml:"feed_interval"`
+		MaxAge       time.Duration `toml:"max_age"`
+	}
+
+	// StalenessConfig sets how long a pair may go without trades before its
+	// price is marked stale, by default and per pair. Pair keys are BASE/QUOTE and
+	// apply to both orientations.
+	StalenessConfig struct {
+		MaxAge time.Duration            `toml:"max_age"`
+		Pairs  map[string]time.Duration `toml:"pairs"`
+	}
+
+	// FilterConfig controls the check of trade prices before they reach candles.
+	// A trade is flagged when its price is more than MaxDeviation, a fraction, or
+	// MaxSigma deviations away from the median of its pair over Window, or more
+	// than MaxDeviation away from the median of the pair's other pools. Flagged
+	// trades are excluded from candles in exclude mode.
+	FilterConfig struct {
+		Mode         string        `toml:"mode"`
+		Window       time.Duration `toml:"window"`
+		MinTrades    int           `toml:"min_trades"`
+		MaxDeviation float64       `toml:"max_deviation"`
+		MaxSigma     float64       `toml:"max_sigma"`
+	}
+
+	// StreamConfig limits the streaming API. Each connection buffers up to Buffer
+	// events and is dropped when a slow client lets the buffer fill up.
+	StreamConfig struct {
+		MaxConnections   int           `toml:"max_connections"`
+		MaxSubscriptions int           `toml:"max_subscriptions"`
+		Buffer           int           `toml:"buffer"`
+		Heartbeat        time.Duration `toml:"heartbeat"`
+	}
+
+	// Options holds a raw config section so that registered stores and exchanges
+	// can decode it into their own typed config.
+	Options map[string]any
+
 	Config struct {
 		Exchanges       []string                  `toml:"exchanges"`
 		LogLevel        zerolog.Level             `toml:"log_level"`
 		StoreBackend    string                    `toml:"store_backend"`
 		StoreConfig     map[string]StoreConfig    `toml:"store"`
+		ChainConfig     map[string]ChainConfig    `toml:"chain"`
 		ExchangeConfig  map[string]ExchangeConfig `toml:"exchange"`
+		StoreOptions    map[string]Options        `toml:"-"`
+		ExchangeOptions map[string]Options        `toml:"-"`
 		TradesMaxAge    time.Duration             `toml:"trades_max_age"`
 		CandlesInterval time.Duration             `toml:"candles_interval"`
 		CandlesPeriod   time.Duration             `toml:"candle_period"`
+		AverageWindows  []time.Duration           `toml:"average_windows"`
+		AssetsCacheDir  string                    `toml:"assets_cache_dir"`
+		Prices          PricesConfig              `toml:"prices"`
+		Valuation       ValuationConfig           `toml:"valuation"`
+		Staleness       StalenessConfig           `toml:"staleness"`
+		Filter          FilterConfig              `toml:"filter"`
+		Stream          StreamConfig              `toml:"stream"`
 	}
 )
 
-var Cfg = InitConfig()
+const (
+	DefaultAssetsRefreshInterval = 15 * time.Minute
+	DefaultAssetsRetryInterval   = 30 * time.Second
+)
+
+var (
+	Cfg = InitConfig()
+
+	storeBackendsMu sync.RWMutex
+	storeBackends   = map[string]struct{}{}
+)
 
 func (sc *StringConfig) Validate() (*Config, error) {
 	exchanges := strings.Split(sc.Exchanges, ",")
@@ -58,34 +163,64 @@ func (sc *StringConfig) Validate() (*Config, error) {
 		logLevel = zerolog.InfoLevel
 	}
 
-	supportedBackends := map[string]struct{}{
-		"influxdb2": {},
-		"sqlite":    {},
-	}
-	_, found := supportedBackends[sc.StoreBackend]
-	if !found {
+	if sc.StoreBackend == "" {
 		return nil, fmt.Errorf("invalid store backend")
 	}
 
-	exchangeConfig := map[string]ExchangeConfig{}
-
-	for _, exchange := range exchanges {
-		switch exchange {
-		case "osmosis":
-			assetsRefreshInterval, err := time.ParseDuration(sc.OsmosisAssetsRefreshInterval)
-			if err != nil {
-				return nil, fmt.Errorf("invalid osmosis assetlist refresh interval")
-			}
-			assetsRetryInterval, err := time.ParseDuration(sc.OsmosisAssetsRetryInterval)
-			if err != nil {
-				return nil, fmt.Errorf("invalid osmosis assetlist retry interval")
-			}
-
-			exchangeConfig[exchange] = ExchangeConfig{
-				AssetsRefreshInterval: assetsRefreshInterval,
-				AssetsRetryInterval:   assetsRetryInterval,
-			}
+	// the built-in exchanges get their defaults whether they are enabled here or
+	// in the config file
+	osmosisRefreshInterval, err := time.ParseDuration(sc.OsmosisAssetsRefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid osmosis assetlist refresh interval")
+	}
+	osmosisRetryInterval, err := time.ParseDuration(sc.OsmosisAssetsRetryInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid osmosis assetlist retry interval")
+	}
+	finRefreshInterval, err := time.ParseDuration(sc.FinAssetsRefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fin assetlist refresh interval")
+	}
+	finRetryInterval, err := time.ParseDuration(sc.FinAssetsRetryInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fin assetlist retry interval")
+	}
+	codeIds := []uint64{}
+	for _, codeIdStr := range splitList(sc.FinCodeIds) {
+		codeId, err := strconv.ParseUint(codeIdStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fin code id: %s", codeIdStr)
 		}
+		codeIds = append(codeIds, codeId)
+	}
+
+	chainConfig := map[string]ChainConfig{
+		"osmosis": {
+			Rpcs: strings.Split(sc.OsmosisRpcs, ","),
+		},
+		"kujira": {
+			Rpcs: strings.Split(sc.KujiraRpcs, ","),
+		},
+	}
+	exchangeConfig := map[string]ExchangeConfig{
+		"osmosis": {
+			Chain:                 "osmosis",
+			AssetsUrl:             sc.OsmosisAssetsJsonUrl,
+			AssetsRefreshInterval: osmosisRefreshInterval,
+			AssetsRetryInterval:   osmosisRetryInterval,
+		},
+		"fin": {
+			Chain:                 "kujira",
+			AssetsUrl:             sc.FinAssetsJsonUrl,
+			AssetsRefreshInterval: finRefreshInterval,
+			AssetsRetryInterval:   finRetryInterval,
+		},
+	}
+	exchangeOptions := map[string]Options{
+		"fin": {
+			"code_ids":  codeIds,
+			"contracts": splitList(sc.FinContracts),
+		},
 	}
 
 	tradesMaxAge, err := time.ParseDuration(sc.TradesMaxAge)
@@ -106,7 +241,13 @@ func (sc *StringConfig) Validate() (*Config, error) {
 			Url:          sc.StoreUrl,
 			Token:        sc.InfluxdbToken,
 			Organization: sc.InfluxdbOrganization,
-			Path:         "TODO path",
+		},
+	}
+	storeOptions := map[string]Options{
+		sc.StoreBackend: {
+			"url":   sc.StoreUrl,
+			"token": sc.InfluxdbToken,
+			"org":   sc.InfluxdbOrganization,
 		},
 	}
 
@@ -115,13 +256,200 @@ func (sc *StringConfig) Validate() (*Config, error) {
 		LogLevel:        logLevel,
 		StoreBackend:    sc.StoreBackend,
 		StoreConfig:     storeConfig,
+		ChainConfig:     chainConfig,
 		ExchangeConfig:  exchangeConfig,
+		StoreOptions:    storeOptions,
+		ExchangeOptions: exchangeOptions,
 		TradesMaxAge:    tradesMaxAge,
 		CandlesInterval: candlesInterval,
 		CandlesPeriod:   candlesPeriod,
+		AverageWindows:  []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour},
+		AssetsCacheDir:  sc.AssetsCacheDir,
+		Prices: PricesConfig{
+			Mode:         "vwap",
+			MaxDeviation: 0.1,
+			MaxAge:       time.Hour,
+			MinSources:   1,
+			MaxHops:      3,
+			QuoteAliases: map[string][]string{
+				"USD": {"USDC", "USDT"},
+			},
+		},
+		Valuation: ValuationConfig{
+			Currency:     "USD",
+			Stablecoins:  []string{"USDC", "USDT"},
+			FeedInterval: 5 * time.Minute,
+			MaxAge:       24 * time.Hour,
+		},
+		Staleness: StalenessConfig{
+			MaxAge: 6 * time.Hour,
+		},
+		Filter: FilterConfig{
+			Window:       time.Hour,
+			MinTrades:    10,
+			MaxDeviation: 0.25,
+			MaxSigma:     5,
+		},
+		Stream: StreamConfig{
+			MaxConnections:   1000,
+			MaxSubscriptions: 50,
+			Buffer:           256,
+			Heartbeat:        30 * time.Second,
+		},
 	}, nil
 }
 
+func (s *StalenessConfig) Threshold(base string, quote string) time.Duration {
+	maxAge, ok := s.Pairs[base+"/"+quote]
+	if !ok {
+		maxAge, ok = s.Pairs[quote+"/"+base]
+	}
+	if !ok {
+		return s.MaxAge
+	}
+	return maxAge
+}
+
+// LoadFile decodes a TOML config file over the current config, keeping the raw
+// [store.<name>] and [exchange.<name>] sections for typed decoding, and checks
+// the result. A [chain.<name>] or
+// [exchange.<name>] section replaces the defaults of that name, so the settings
+// it leaves out are carried over.
+func (c *Config) LoadFile(path string) error {
+	chainDefaults := map[string]ChainConfig{}
+	for name, cfg := range c.ChainConfig {
+		chainDefaults[name] = cfg
+	}
+	exchangeDefaults := map[string]ExchangeConfig{}
+	for name, cfg := range c.ExchangeConfig {
+		exchangeDefaults[name] = cfg
+	}
+	_, err := toml.DecodeFile(path, c)
+	if err != nil {
+		return err
+	}
+	for name, cfg := range c.ChainConfig {
+		if len(cfg.Rpcs) == 0 {
+			cfg.Rpcs = chainDefaults[name].Rpcs
+		}
+		c.ChainConfig[name] = cfg
+	}
+	if c.ExchangeConfig == nil {
+		c.ExchangeConfig = map[string]ExchangeConfig{}
+	}
+	for _, name := range c.Exchanges {
+		_, ok := c.ExchangeConfig[name]
+		if !ok {
+			c.ExchangeConfig[name] = ExchangeConfig{}
+		}
+	}
+	for name, cfg := range c.ExchangeConfig {
+		c.ExchangeConfig[name] = cfg.withDefaults(exchangeDefaults[name])
+	}
+	raw := struct {
+		Store    map[string]Options `toml:"store"`
+		Exchange map[string]Options `toml:"exchange"`
+	}{}
+	_, err = toml.DecodeFile(path, &raw)
+	if err != nil {
+		return err
+	}
+	c.StoreOptions = mergeOptions(c.StoreOptions, raw.Store)
+	c.ExchangeOptions = mergeOptions(c.ExchangeOptions, raw.Exchange)
+	return c.Check()
+}
+
+// Check rejects the settings Validate cannot check, since they are only known
+// once the store backends have registered or the config file has been read.
+func (c *Config) Check() error {
+	storeBackendsMu.RLock()
+	_, ok := storeBackends[c.StoreBackend]
+	storeBackendsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unsupported store backend: %s", c.StoreBackend)
+	}
+	if c.Stream.Buffer <= 0 {
+		return fmt.Errorf("invalid stream buffer")
+	}
+	if c.Stream.Heartbeat <= 0 {
+		return fmt.Errorf("invalid stream heartbeat")
+	}
+	return nil
+}
+
+// RegisterStoreBackend makes a store backend valid in the config. It is called
+// by store.Register.
+func RegisterStoreBackend(backend string) {
+	storeBackendsMu.Lock()
+	defer storeBackendsMu.Unlock()
+	storeBackends[backend] = struct{}{}
+}
+
+// withDefaults fills the chain, asset list and intervals left unset from
+// defaults, and the intervals of exchanges without defaults from
+// DefaultAssetsRefreshInterval and DefaultAssetsRetryInterval.
+func (e ExchangeConfig) withDefaults(defaults ExchangeConfig) ExchangeConfig {
+	if e.Chain == "" {
+		e.Chain = defaults.Chain
+	}
+	if e.AssetsUrl == "" {
+		e.AssetsUrl = defaults.AssetsUrl
+	}
+	if e.AssetsRefreshInterval <= 0 {
+		e.AssetsRefreshInterval = defaults.AssetsRefreshInterval
+	}
+	if e.AssetsRefreshInterval <= 0 {
+		e.AssetsRefreshInterval = DefaultAssetsRefreshInterval
+	}
+	if e.AssetsRetryInterval <= 0 {
+		e.AssetsRetryInterval = defaults.AssetsRetryInterval
+	}
+	if e.AssetsRetryInterval <= 0 {
+		e.AssetsRetryInterval = DefaultAssetsRetryInterval
+	}
+	return e
+}
+
+func (c *Config) DecodeStoreConfig(name string, v any) error {
+	return c.StoreOptions[name].Decode(v)
+}
+
+func (c *Config) DecodeExchangeConfig(name string, v any) error {
+	return c.ExchangeOptions[name].Decode(v)
+}
+
+// Decode round-trips the options through TOML so that v receives the same
+// conversions (durations, nested tables) as the rest of the config.
+func (o Options) Decode(v any) error {
+	if len(o) == 0 {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	err := toml.NewEncoder(buf).Encode(map[string]any(o))
+	if err != nil {
+		return err
+	}
+	_, err = toml.Decode(buf.String(), v)
+	return err
+}
+
+func mergeOptions(base map[string]Options, overlay map[string]Options) map[string]Options {
+	if base == nil {
+		base = map[string]Options{}
+	}
+	for name, options := range overlay {
+		merged, ok := base[name]
+		if !ok {
+			merged = Options{}
+		}
+		for key, value := range options {
+			merged[key] = value
+		}
+		base[name] = merged
+	}
+	return base
+}
+
 func (s *StringConfig) MustValidate() *Config {
 	cfg, err := s.Validate()
 	if err != nil {
@@ -162,6 +490,27 @@ func MergeConfig(base *StringConfig, overlay *StringConfig) *StringConfig {
 	if overlay.OsmosisAssetsRetryInterval != "" {
 		base.OsmosisAssetsRetryInterval = overlay.OsmosisAssetsRetryInterval
 	}
+	if overlay.OsmosisRpcs != "" {
+		base.OsmosisRpcs = overlay.OsmosisRpcs
+	}
+	if overlay.FinAssetsJsonUrl != "" {
+		base.FinAssetsJsonUrl = overlay.FinAssetsJsonUrl
+	}
+	if overlay.FinAssetsRefreshInterval != "" {
+		base.FinAssetsRefreshInterval = overlay.FinAssetsRefreshInterval
+	}
+	if overlay.FinAssetsRetryInterval != "" {
+		base.FinAssetsRetryInterval = overlay.FinAssetsRetryInterval
+	}
+	if overlay.FinCodeIds != "" {
+		base.FinCodeIds = overlay.FinCodeIds
+	}
+	if overlay.FinContracts != "" {
+		base.FinContracts = overlay.FinContracts
+	}
+	if overlay.KujiraRpcs != "" {
+		base.KujiraRpcs = overlay.KujiraRpcs
+	}
 	if overlay.TradesMaxAge != "" {
 		base.TradesMaxAge = overlay.TradesMaxAge
 	}
@@ -171,5 +520,19 @@ func MergeConfig(base *StringConfig, overlay *StringConfig) *StringConfig {
 	if overlay.CandlesPeriod != "" {
 		base.CandlesPeriod = overlay.CandlesPeriod
 	}
+	if overlay.AssetsCacheDir != "" {
+		base.AssetsCacheDir = overlay.AssetsCacheDir
+	}
 	return base
 }
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -10,6 +10,11 @@ func DefaultConfig() *StringConfig {
 		OsmosisAssetsJsonUrl:         "https://raw.githubusercontent.com/osmosis-labs/assetlists/main/osmosis-1/osmosis-1.assetlist.json",
 		OsmosisAssetsRefreshInterval: "15m",
 		OsmosisAssetsRetryInterval:   "30s",
+		OsmosisRpcs:                  "https://osmosis-rpc.polkachu.com:443",
+		FinAssetsJsonUrl:             "https://raw.githubusercontent.com/cosmos/chain-registry/master/kujira/assetlist.json",
+		FinAssetsRefreshInterval:     "15m",
+		FinAssetsRetryInterval:       "30s",
+		KujiraRpcs:                   "https://kujira-rpc.polkachu.com:443",
 		TradesMaxAge:                 "48h",
 		CandlesInterval:              "1m",
 		CandlesPeriod:                "48h",
@@ -12,9 +12,17 @@ const (
 	EnvOsmosisAssetsJsonUrl         = "OSMOSIS_ASSETS_JSON_URL"
 	EnvOsmosisAssetsRefreshInterval = "OSMOSIS_ASSETS_REFRESH_INTERVAL"
 	EnvOsmosisAssetsRetryInterval   = "OSMOSIS_ASSETS_RETRY_INTERVAL"
+	EnvOsmosisRpcs                  = "OSMOSIS_RPCS"
+	EnvFinAssetsJsonUrl             = "FIN_ASSETS_JSON_URL"
+	EnvFinAssetsRefreshInterval     = "FIN_ASSETS_REFRESH_INTERVAL"
+	EnvFinAssetsRetryInterval       = "FIN_ASSETS_RETRY_INTERVAL"
+	EnvFinCodeIds                   = "FIN_CODE_IDS"
+	EnvFinContracts                 = "FIN_CONTRACTS"
+	EnvKujiraRpcs                   = "KUJIRA_RPCS"
 	EnvTradesMaxAge                 = "TRADES_MAX_AGE"
 	EnvCandlesInterval              = "CANDLES_INTERVAL"
 	EnvCandlesPeriod                = "CANDLES_PERIOD"
+	EnvAssetsCacheDir               = "ASSETS_CACHE_DIR"
 )
 
 func EnvConfig() *StringConfig {
@@ -28,8 +36,16 @@ func EnvConfig() *StringConfig {
 		OsmosisAssetsJsonUrl:         os.Getenv(EnvOsmosisAssetsJsonUrl),
 		OsmosisAssetsRefreshInterval: os.Getenv(EnvOsmosisAssetsRefreshInterval),
 		OsmosisAssetsRetryInterval:   os.Getenv(EnvOsmosisAssetsRetryInterval),
+		OsmosisRpcs:                  os.Getenv(EnvOsmosisRpcs),
+		FinAssetsJsonUrl:             os.Getenv(EnvFinAssetsJsonUrl),
+		FinAssetsRefreshInterval:     os.Getenv(EnvFinAssetsRefreshInterval),
+		FinAssetsRetryInterval:       os.Getenv(EnvFinAssetsRetryInterval),
+		FinCodeIds:                   os.Getenv(EnvFinCodeIds),
+		FinContracts:                 os.Getenv(EnvFinContracts),
+		KujiraRpcs:                   os.Getenv(EnvKujiraRpcs),
 		TradesMaxAge:                 os.Getenv(EnvTradesMaxAge),
 		CandlesInterval:              os.Getenv(EnvCandlesInterval),
 		CandlesPeriod:                os.Getenv(EnvCandlesPeriod),
+		AssetsCacheDir:               os.Getenv(EnvAssetsCacheDir),
 	}
 }
@@ -0,0 +1,58 @@
+package exchange
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"indexer/config"
+	"indexer/token"
+
+	"github.com/rs/zerolog"
+)
+
+// NewAssetRegistry creates the asset registry of an exchange from its assets_url
+// and assets_files. Denom to symbol overrides from the exchange's symbols config
+// take precedence over the adapter defaults. The registry starts out with the
+// cached asset list, if any, until the first refresh.
+func NewAssetRegistry(name string, defaults map[string]string, logger zerolog.Logger) *token.AssetRegistry {
+	cfg := config.Cfg.ExchangeConfig[name]
+	overrides := make(map[string]string, len(defaults)+len(cfg.Symbols))
+	for denom, symbol := range defaults {
+		overrides[denom] = symbol
+	}
+	for denom, symbol := range cfg.Symbols {
+		overrides[denom] = symbol
+	}
+	sources := []token.AssetSource{}
+	if cfg.AssetsUrl != "" {
+		sources = append(sources, token.NewAssetListSource(cfg.AssetsUrl, assetsCacheFile(name, cfg.AssetsUrl, logger), logger))
+	}
+	for _, file := range cfg.AssetsFiles {
+		sources = append(sources, token.NewAssetListSource(file, "", logger))
+	}
+	registry := token.NewAssetRegistry(sources, overrides, logger)
+	err := registry.LoadCache()
+	if err != nil {
+		logger.Info().Err(err).Msg("starting without cached asset list")
+	} else {
+		logger.Info().Int("num_assets", registry.Len()).Msg("loaded cached asset list")
+	}
+	return registry
+}
+
+func assetsCacheFile(name string, location string, logger zerolog.Logger) string {
+	dir := config.Cfg.AssetsCacheDir
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			logger.Warn().Err(err).Msg("no cache directory, asset list will not be cached")
+			return ""
+		}
+		dir = filepath.Join(cacheDir, "currents", "assets")
+	}
+	sum := sha1.Sum([]byte(location))
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.json", name, hex.EncodeToString(sum[:])[:12]))
+}
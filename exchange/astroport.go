@@ -0,0 +1,326 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"indexer/chain"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/rs/zerolog"
+)
+
+const (
+	AstroportDefaultSwapEvent = "wasm-swap"
+	astroportPairsLimit       = 30
+)
+
+func init() {
+	Register("astroport", NewAstroportExchangeFromConfig)
+}
+
+type (
+	AstroportConfig struct {
+		Factory   string `toml:"factory"`
+		SwapEvent string `toml:"swap_event"`
+	}
+
+	AstroportExchange struct {
+		*ChainExchange
+		cfg       *AstroportConfig
+		swapEvent string
+		pools     map[string]*AstroportPool
+	}
+
+	AstroportPool struct {
+		Contract string
+		Assets   []string
+		Type     string
+	}
+
+	AstroportSwap struct {
+		Pool         string
+		OfferAsset   string
+		AskAsset     string
+		OfferAmount  string
+		ReturnAmount string
+	}
+
+	AstroportAssetInfo struct {
+		NativeToken *struct {
+			Denom string `json:"denom"`
+		} `json:"native_token,omitempty"`
+		Token *struct {
+			ContractAddr string `json:"contract_addr"`
+		} `json:"token,omitempty"`
+	}
+
+	astroportPairInfo struct {
+		AssetInfos   []AstroportAssetInfo       `json:"asset_infos"`
+		ContractAddr string                     `json:"contract_addr"`
+		PairType     map[string]json.RawMessage `json:"pair_type"`
+	}
+
+	astroportPairsResponse struct {
+		Pairs []astroportPairInfo `json:"pairs"`
+	}
+
+	cw20TokenInfoResponse struct {
+		Symbol   string `json:"symbol"`
+		Decimals int64  `json:"decimals"`
+	}
+)
+
+func NewAstroportExchangeFromConfig(name string, cfg *AstroportConfig, store store.Store, logger zerolog.Logger) (Exchange, error) {
+	rpc, err := ChainPool(name, logger)
+	if err != nil {
+		return nil, err
+	}
+	return NewAstroportExchange(name, cfg, rpc, store, logger)
+}
+
+func NewAstroportExchange(name string, cfg *AstroportConfig, rpc *chain.CometPool, store store.Store, logger zerolog.Logger) (*AstroportExchange, error) {
+	swapEvent := cfg.SwapEvent
+	if swapEvent == "" {
+		swapEvent = AstroportDefaultSwapEvent
+	}
+	a := &AstroportExchange{
+		cfg:       cfg,
+		swapEvent: swapEvent,
+		pools:     map[string]*AstroportPool{},
+	}
+	query := fmt.Sprintf("tm.event='Tx' AND %s.offer_asset EXISTS", swapEvent)
+	if swapEvent == "wasm" {
+		query = "tm.event='Tx' AND wasm.action='swap'"
+	}
+	assets := NewAssetRegistry(name, nil, logger)
+	a.ChainExchange = NewChainExchange(name, rpc, assets, store, query, a.GetTrades, logger)
+	a.Poll(a.RefreshPools)
+	return a, nil
+}
+
+func (a *AstroportExchange) DisplayName() string {
+	suffix := strings.TrimPrefix(a.name, "astroport")
+	suffix = strings.Trim(suffix, "-_")
+	if suffix == "" {
+		return "Astroport"
+	}
+	return fmt.Sprintf("Astroport (%s)", strings.ToUpper(suffix[:1])+suffix[1:])
+}
+
+func (a *AstroportExchange) GetTrades(event *coretypes.ResultEvent) []trading.Trade {
+	trades := []trading.Trade{}
+	swaps, err := ParseAstroportSwaps(event, a.swapEvent)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("failed to parse swap event")
+		return trades
+	}
+	if len(swaps) == 0 {
+		return trades
+	}
+	if a.assets.Len() == 0 {
+		a.logger.Warn().Msg("cannot process trades when asset list is empty")
+		return trades
+	}
+	height, err := chain.EventHeight(event)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("failed to get swap event height")
+		return trades
+	}
+	txHash := chain.EventTxHash(event)
+	blockTime, err := a.rpc.BlockTime(height)
+	if err != nil {
+		a.logger.Warn().Err(err).Int64("height", height).Msg("falling back to receive time for trades")
+		blockTime = time.Now().UTC()
+	}
+	for _, swap := range swaps {
+		_, ok := a.pools[swap.Pool]
+		if !ok {
+			// swap events from contracts outside the factory are ignored
+			continue
+		}
+		base, err := a.RebaseAmount(swap.OfferAmount, swap.OfferAsset)
+		if err != nil {
+			a.logger.Debug().Err(err).Str("asset", swap.OfferAsset).Msg("failed to rebase offer amount")
+			continue
+		}
+		quote, err := a.RebaseAmount(swap.ReturnAmount, swap.AskAsset)
+		if err != nil {
+			a.logger.Debug().Err(err).Str("asset", swap.AskAsset).Msg("failed to rebase return amount")
+			continue
+		}
+		if base.Amount.Sign() == 0 || quote.Amount.Sign() == 0 {
+			continue
+		}
+		trades = append(trades, trading.Trade{
+			Base:   *base,
+			Quote:  *quote,
+			Time:   blockTime,
+			Height: height,
+			TxHash: txHash,
+			Pool:   swap.Pool,
+		})
+	}
+	return trades
+}
+
+func (a *AstroportExchange) RebaseAmount(amount string, id string) (*token.Token, error) {
+	t := &token.Token{Symbol: id}
+	_, ok := t.Amount.SetString(amount)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %s", amount)
+	}
+	return a.assets.Rebase(t)
+}
+
+// RefreshPools updates the pools registered with the factory and returns their
+// pairs, loading cw20 tokens missing from the asset list from their contracts.
+func (a *AstroportExchange) RefreshPools() ([]*token.Pair, error) {
+	pools, err := a.DiscoverPools(a.cfg.Factory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover pools of factory %s: %v", a.cfg.Factory, err)
+	}
+	pairs := []*token.Pair{}
+	seen := map[string]struct{}{}
+	for _, pool := range pools {
+		symbols := []string{}
+		for _, id := range pool.Assets {
+			asset, ok := a.assets.Asset(id)
+			if !ok {
+				asset, err = a.LoadCw20Asset(id)
+				if err != nil {
+					a.logger.Debug().Err(err).Str("asset", id).Str("pool", pool.Contract).Msg("skipping unlisted asset")
+					continue
+				}
+				a.assets.Add(*asset)
+			}
+			symbols = append(symbols, asset.Symbol)
+		}
+		for i := 0; i < len(symbols); i++ {
+			for j := i + 1; j < len(symbols); j++ {
+				pair := &token.Pair{
+					Base:  symbols[i],
+					Quote: symbols[j],
+				}
+				_, ok := seen[pair.String()]
+				if ok {
+					continue
+				}
+				seen[pair.String()] = struct{}{}
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+	a.pools = pools
+	a.logger.Debug().Int("num_pools", len(pools)).Msg("refreshed pools")
+	return pairs, nil
+}
+
+// DiscoverPools pages through every pair registered with the factory contract.
+func (a *AstroportExchange) DiscoverPools(factory string) (map[string]*AstroportPool, error) {
+	if factory == "" {
+		return nil, fmt.Errorf("factory contract not configured")
+	}
+	pools := map[string]*AstroportPool{}
+	var startAfter []AstroportAssetInfo
+	for {
+		query := map[string]any{
+			"pairs": map[string]any{
+				"start_after": startAfter,
+				"limit":       astroportPairsLimit,
+			},
+		}
+		res := astroportPairsResponse{}
+		err := a.rpc.WasmSmartQuery(factory, query, &res)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range res.Pairs {
+			pool := &AstroportPool{
+				Contract: pair.ContractAddr,
+				Assets:   make([]string, len(pair.AssetInfos)),
+			}
+			for pairType := range pair.PairType {
+				pool.Type = pairType
+			}
+			for i, info := range pair.AssetInfos {
+				pool.Assets[i] = info.Id()
+			}
+			pools[pool.Contract] = pool
+		}
+		if len(res.Pairs) < astroportPairsLimit {
+			return pools, nil
+		}
+		startAfter = res.Pairs[len(res.Pairs)-1].AssetInfos
+	}
+}
+
+// LoadCw20Asset builds asset metadata for a cw20 token missing from the asset list
+// from the token contract's own info.
+func (a *AstroportExchange) LoadCw20Asset(address string) (*token.Asset, error) {
+	if strings.Contains(address, "/") {
+		return nil, fmt.Errorf("not a cw20 token")
+	}
+	res := cw20TokenInfoResponse{}
+	err := a.rpc.WasmSmartQuery(address, map[string]any{"token_info": struct{}{}}, &res)
+	if err != nil {
+		return nil, err
+	}
+	asset := &token.Asset{
+		Denom:    address,
+		Symbol:   res.Symbol,
+		Exponent: int(res.Decimals),
+	}
+	return asset, nil
+}
+
+func (i *AstroportAssetInfo) Id() string {
+	if i.NativeToken != nil {
+		return i.NativeToken.Denom
+	}
+	if i.Token != nil {
+		return i.Token.ContractAddr
+	}
+	return ""
+}
+
+// ParseAstroportSwaps reads swaps from either dedicated swap events or, for older
+// pair contracts, generic wasm events with action=swap. Other contracts use the
+// same event names, so events without the swap attributes are skipped.
+func ParseAstroportSwaps(event *coretypes.ResultEvent, swapEvent string) ([]AstroportSwap, error) {
+	swaps := []AstroportSwap{}
+	for _, attributes := range chain.EventAttributes(event, swapEvent) {
+		if swapEvent == "wasm" && attributes["action"] != "swap" {
+			continue
+		}
+		swap, ok := parseAstroportSwap(attributes)
+		if !ok {
+			continue
+		}
+		swaps = append(swaps, *swap)
+	}
+	return swaps, nil
+}
+
+func parseAstroportSwap(attributes map[string]string) (*AstroportSwap, bool) {
+	swap := &AstroportSwap{Pool: attributes["_contract_address"]}
+	fields := map[string]*string{
+		"offer_asset":   &swap.OfferAsset,
+		"ask_asset":     &swap.AskAsset,
+		"offer_amount":  &swap.OfferAmount,
+		"return_amount": &swap.ReturnAmount,
+	}
+	for key, field := range fields {
+		value, ok := attributes[key]
+		if !ok {
+			return nil, false
+		}
+		*field = value
+	}
+	return swap, true
+}
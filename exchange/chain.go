@@ -0,0 +1,169 @@
+package exchange
+
+import (
+	"sync"
+	"time"
+
+	"indexer/chain"
+	"indexer/config"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/rs/zerolog"
+)
+
+type (
+	// TradeParser maps a chain event to the trades it contains.
+	TradeParser func(event *coretypes.ResultEvent) []trading.Trade
+
+	// PairRefresher lists the pairs of an exchange after its asset list has been
+	// refreshed.
+	PairRefresher func() ([]*token.Pair, error)
+
+	// ChainExchange is embedded by exchanges that derive trades from chain events.
+	// It fans trades and pairs out to subscribers, ingests events from the chain
+	// and keeps the asset list and pairs up to date.
+	ChainExchange struct {
+		mu                 sync.RWMutex
+		name               string
+		rpc                *chain.CometPool
+		assets             *token.AssetRegistry
+		pairs              []*token.Pair
+		store              store.Store
+		ingester           *Ingester
+		parse              TradeParser
+		ready              chan struct{}
+		readyOnce          sync.Once
+		tradeSubscriptions []chan *trading.Trade
+		pairSubscriptions  []chan []*token.Pair
+		pending            sync.WaitGroup
+		logger             zerolog.Logger
+	}
+)
+
+func NewChainExchange(name string, rpc *chain.CometPool, assets *token.AssetRegistry, store store.Store, query string, parse TradeParser, logger zerolog.Logger) *ChainExchange {
+	c := &ChainExchange{
+		name:   name,
+		rpc:    rpc,
+		assets: assets,
+		pairs:  []*token.Pair{},
+		store:  store,
+		parse:  parse,
+		ready:  make(chan struct{}),
+		logger: logger,
+	}
+	c.ingester = NewIngester(rpc, store, query, c.HandleEvent, c.Flush, logger)
+	c.logger.Info().Str("rpc", rpc.ActiveUrl()).Msg("exchange connected")
+	return c
+}
+
+func (c *ChainExchange) Name() string {
+	return c.name
+}
+
+func (c *ChainExchange) Start() error {
+	err := c.ingester.Start(c.ready)
+	if err != nil {
+		return err
+	}
+	c.logger.Info().Str("query", c.ingester.query).Msg("subscribed to trade events")
+	return nil
+}
+
+func (c *ChainExchange) Ready() <-chan struct{} {
+	return c.ready
+}
+
+func (c *ChainExchange) HandleEvent(event *coretypes.ResultEvent) {
+	trades := c.parse(event)
+	for i := range trades {
+		trade := &trades[i]
+		c.logger.Debug().Str("base", trade.Base.String()).Str("quote", trade.Quote.String()).Msg("trade")
+		for _, subscription := range c.tradeSubscriptions {
+			c.pending.Add(1)
+			subscription <- trade
+		}
+	}
+}
+
+// Ack marks a trade sent to a subscriber as saved.
+func (c *ChainExchange) Ack(trade *trading.Trade) {
+	c.pending.Done()
+}
+
+// Flush waits until every trade sent to subscribers has been acknowledged as
+// saved.
+func (c *ChainExchange) Flush() {
+	c.pending.Wait()
+}
+
+func (c *ChainExchange) SubscribeTrades() chan *trading.Trade {
+	channel := make(chan *trading.Trade)
+	c.tradeSubscriptions = append(c.tradeSubscriptions, channel)
+	return channel
+}
+
+func (c *ChainExchange) SubscribePairs() chan []*token.Pair {
+	channel := make(chan []*token.Pair)
+	c.pairSubscriptions = append(c.pairSubscriptions, channel)
+	return channel
+}
+
+func (c *ChainExchange) Pairs() ([]*token.Pair, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pairs, nil
+}
+
+func (c *ChainExchange) Assets() []*token.Asset {
+	return c.assets.Assets()
+}
+
+func (c *ChainExchange) Store() store.Store {
+	return c.store
+}
+
+// Poll refreshes the asset list and then the pairs in the background, every
+// assets refresh interval. The exchange is ready once both have loaded.
+func (c *ChainExchange) Poll(refresh PairRefresher) {
+	go func() {
+		for {
+			cfg := config.Cfg.ExchangeConfig[c.name]
+			wait, ok := c.RefreshAssets(cfg)
+			if !ok {
+				time.Sleep(wait)
+				continue
+			}
+			pairs, err := refresh()
+			if err != nil {
+				c.logger.Error().Err(err).Msg("failed to refresh pairs")
+				time.Sleep(cfg.AssetsRetryInterval)
+				continue
+			}
+			c.mu.Lock()
+			c.pairs = pairs
+			c.mu.Unlock()
+			for _, subscription := range c.pairSubscriptions {
+				subscription <- pairs
+			}
+			c.readyOnce.Do(func() { close(c.ready) })
+			c.logger.Debug().Int("num_assets", c.assets.Len()).Int("num_pairs", len(pairs)).Msg("refreshed pairs")
+			time.Sleep(wait)
+		}
+	}()
+}
+
+// RefreshAssets reloads the asset list and returns how long to wait before the
+// next refresh. A failed refresh is retried sooner, while the exchange carries
+// on with the cached list until the network is back. ok is false if there is
+// no asset list to carry on with.
+func (c *ChainExchange) RefreshAssets(cfg config.ExchangeConfig) (wait time.Duration, ok bool) {
+	err := c.assets.Refresh()
+	if err != nil {
+		c.logger.Error().Err(err).Msg("failed to load asset list")
+		return cfg.AssetsRetryInterval, c.assets.Len() > 0
+	}
+	return cfg.AssetsRefreshInterval, true
+}
@@ -2,13 +2,17 @@ package exchange
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"indexer/config"
 	"indexer/store"
+	"indexer/stream"
 	"indexer/token"
 	"indexer/trading"
 
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/ericlagergren/decimal"
 	"github.com/rs/zerolog"
 )
 
@@ -16,43 +20,125 @@ type (
 	ExchangeManager struct {
 		Exchanges map[string]Exchange
 		data      map[string]*ExchangeData
+		stream    *stream.Broadcaster
+		clock     Clock
 		logger    zerolog.Logger
 	}
 
+	// Clock provides the current time used to align candles, so that replays can
+	// run on the time of the recorded trades.
+	Clock interface {
+		Now() time.Time
+	}
+
+	systemClock struct{}
+
 	Exchange interface {
 		Name() string
 		DisplayName() string
 		Start() error
 		Pairs() ([]*token.Pair, error)
+		Assets() []*token.Asset
 		Store() store.Store
 		SubscribeTrades() chan *trading.Trade
 		SubscribePairs() chan []*token.Pair
 	}
 
+	// EventExchange is an exchange that derives trades from chain events. Ready is
+	// closed once the exchange can map events to trades.
+	EventExchange interface {
+		Exchange
+		Ready() <-chan struct{}
+		HandleEvent(event *coretypes.ResultEvent)
+	}
+
+	// AckExchange is an exchange that is told once each trade it sent has been
+	// saved, or left out by the trade filter, so that it only checkpoints what
+	// has reached the store.
+	AckExchange interface {
+		Exchange
+		Ack(trade *trading.Trade)
+	}
+
+	// LiquidityExchange is an exchange that knows the reserves behind its pairs.
+	// Liquidity returns the reserves of the quote asset across the pair's pools,
+	// in display units.
+	LiquidityExchange interface {
+		Exchange
+		Liquidity(pair *token.Pair) (*decimal.Big, bool)
+	}
+
 	ExchangeData struct {
-		pairs   chan []*token.Pair
-		trades  chan *trading.Trade
-		candles map[string]*trading.Candles
-		tickers map[string]*trading.Ticker
-		db      store.Store
-		logger  zerolog.Logger
+		mu          sync.RWMutex
+		name        string
+		pairs       chan []*token.Pair
+		trades      chan *trading.Trade
+		candles     map[string]*trading.Candles
+		tickers     map[string]*trading.Ticker
+		stale       map[string]bool
+		closed      map[string]time.Time
+		poolCandles map[string]map[string]*trading.Candles
+		poolTickers map[string]map[string]*trading.Ticker
+		db          store.Store
+		ack         func(trade *trading.Trade)
+		stream      *stream.Broadcaster
+		clock       Clock
+		logger      zerolog.Logger
 	}
 )
 
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
 func NewExchangeManager(exchanges map[string]Exchange, logger zerolog.Logger) (*ExchangeManager, error) {
+	_, ok := FilterModes[config.Cfg.Filter.Mode]
+	if !ok {
+		return nil, fmt.Errorf("invalid filter mode '%s'", config.Cfg.Filter.Mode)
+	}
 	e := &ExchangeManager{
 		Exchanges: exchanges,
 		data:      map[string]*ExchangeData{},
+		stream:    stream.NewBroadcaster(config.Cfg.Stream, logger),
+		clock:     SystemClock,
 		logger:    logger,
 	}
 	return e, nil
 }
 
+// SetClock replaces the clock used by exchange data started after the call.
+func (e *ExchangeManager) SetClock(clock Clock) {
+	e.clock = clock
+}
+
+func (e *ExchangeManager) Clock() Clock {
+	return e.clock
+}
+
+// Stream is the broadcaster of the trades, ticker updates and closed candles of
+// every exchange.
+func (e *ExchangeManager) Stream() *stream.Broadcaster {
+	return e.stream
+}
+
 func (e *ExchangeManager) Start() {
 	for _, exchange := range e.Exchanges {
+		ack := func(*trading.Trade) {}
+		acker, ok := exchange.(AckExchange)
+		if ok {
+			ack = acker.Ack
+		}
 		trades := exchange.SubscribeTrades()
+		if config.Cfg.Filter.Mode != "" {
+			filter := NewTradeFilter(config.Cfg.Filter, exchange.Store(), e.logger.With().Str("exchange", exchange.Name()).Logger())
+			filter.ack = ack
+			trades = filter.Filter(trades)
+		}
 		pairs := exchange.SubscribePairs()
-		exchangeData := NewExchangeData(pairs, trades, exchange.Store(), e.logger)
+		exchangeData := NewExchangeData(exchange.Name(), pairs, trades, exchange.Store(), e.stream, e.clock, e.logger)
+		exchangeData.ack = ack
 		e.data[exchange.Name()] = exchangeData
 		exchangeData.Start()
 		err := exchange.Start()
@@ -71,6 +157,14 @@ func (e *ExchangeManager) Candles(exchange string, pair *token.Pair) (*trading.C
 	return exchangeData.Candles(pair)
 }
 
+func (e *ExchangeManager) ReadCandles(exchange string, pool string, pair *token.Pair, read func(candles *trading.Candles)) error {
+	exchangeData, ok := e.data[exchange]
+	if !ok {
+		return fmt.Errorf("exchange not found")
+	}
+	return exchangeData.ReadCandles(pool, pair, read)
+}
+
 func (e *ExchangeManager) Tickers(exchange string) ([]*trading.Ticker, error) {
 	exchangeData, ok := e.data[exchange]
 	if !ok {
@@ -87,97 +181,296 @@ func (e *ExchangeManager) Ticker(exchange string, pair *token.Pair) (*trading.Ti
 	return exchangeData.Ticker(pair)
 }
 
-func NewExchange(name string, store store.Store, logger zerolog.Logger) (Exchange, error) {
-	exchangeLogger := logger.With().Str("exchange", name).Logger()
-	switch name {
-	case "osmosis":
-		return NewOsmosisExchange("https://osmosis-rpc.polkachu.com:443", store, exchangeLogger)
-	default:
-		return nil, fmt.Errorf("unsupported exchange: %s", name)
+func (e *ExchangeManager) Averages(exchange string, pair *token.Pair, windows []time.Duration) ([]*trading.AverageValue, error) {
+	exchangeData, ok := e.data[exchange]
+	if !ok {
+		return nil, fmt.Errorf("exchange not found")
 	}
+	return exchangeData.Averages(pair, windows)
 }
 
-func NewExchangeData(pairs chan []*token.Pair, trades chan *trading.Trade, db store.Store, logger zerolog.Logger) *ExchangeData {
+func (e *ExchangeManager) Pools(exchange string) (map[string][]*token.Pair, error) {
+	exchangeData, ok := e.data[exchange]
+	if !ok {
+		return nil, fmt.Errorf("exchange not found")
+	}
+	return exchangeData.Pools(), nil
+}
+
+func (e *ExchangeManager) PoolCandles(exchange string, pool string, pair *token.Pair) (*trading.Candles, error) {
+	exchangeData, ok := e.data[exchange]
+	if !ok {
+		return nil, fmt.Errorf("exchange not found")
+	}
+	return exchangeData.PoolCandles(pool, pair)
+}
+
+func (e *ExchangeManager) PoolTickers(exchange string, pool string) ([]*trading.Ticker, error) {
+	exchangeData, ok := e.data[exchange]
+	if !ok {
+		return nil, fmt.Errorf("exchange not found")
+	}
+	return exchangeData.PoolTickers(pool)
+}
+
+func NewExchangeData(name string, pairs chan []*token.Pair, trades chan *trading.Trade, db store.Store, stream *stream.Broadcaster, clock Clock, logger zerolog.Logger) *ExchangeData {
 	return &ExchangeData{
-		pairs:   pairs,
-		trades:  trades,
-		candles: map[string]*trading.Candles{},
-		tickers: map[string]*trading.Ticker{},
-		db:      db,
-		logger:  logger,
+		name:        name,
+		pairs:       pairs,
+		trades:      trades,
+		candles:     map[string]*trading.Candles{},
+		tickers:     map[string]*trading.Ticker{},
+		stale:       map[string]bool{},
+		closed:      map[string]time.Time{},
+		poolCandles: map[string]map[string]*trading.Candles{},
+		poolTickers: map[string]map[string]*trading.Ticker{},
+		db:          db,
+		ack:         func(*trading.Trade) {},
+		stream:      stream,
+		clock:       clock,
+		logger:      logger,
 	}
 }
 
 func (e *ExchangeData) Start() {
-	go e.SubscribePairs()
-	go e.SubscribeTrades()
+	go e.Subscribe()
 	go e.FillCandles()
 }
 
-func (e *ExchangeData) SubscribePairs() {
-	for pairs := range e.pairs {
-		e.SetPairs(pairs)
+// Subscribe handles pair updates and trades from a single goroutine so that a
+// trade sent after its pair is always applied after the pair exists.
+func (e *ExchangeData) Subscribe() {
+	pairs, trades := e.pairs, e.trades
+	for pairs != nil || trades != nil {
+		select {
+		case p, ok := <-pairs:
+			if !ok {
+				pairs = nil
+				continue
+			}
+			e.SetPairs(p)
+		case trade, ok := <-trades:
+			if !ok {
+				trades = nil
+				continue
+			}
+			e.PushTrade(trade)
+		}
 	}
 }
 
-func (e *ExchangeData) SubscribeTrades() {
-	for trade := range e.trades {
+// PushTrade saves a trade and adds it to the candles of its pair, and to those of
+// its pool if the exchange reports one. Routed trades are only saved and
+// streamed, since their hops are already traded on their own pairs.
+func (e *ExchangeData) PushTrade(trade *trading.Trade) {
+	if trade.Routed {
 		e.db.SaveTrade(trade)
-		pair := trade.Pair()
-		candles, ok := e.candles[pair.String()]
+		e.ack(trade)
+		e.stream.Publish(stream.NewTradeEvent(e.name, trade))
+		return
+	}
+	loaded := e.loadPoolCandles(trade)
+	e.db.SaveTrade(trade)
+	e.ack(trade)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	pair := trade.Pair()
+	candles, ok := e.candles[pair.String()]
+	if !ok {
+		candles, ok = e.candles[pair.Reversed().String()]
 		if !ok {
-			candles, ok = e.candles[pair.Reversed().String()]
-			if !ok {
-				e.logger.Error().Str("pair", pair.String()).Msg("pair not found")
-				continue
-			}
-			trade = trade.Reversed()
-			pair = pair.Reversed()
+			e.logger.Error().Str("pair", pair.String()).Msg("pair not found")
+			return
 		}
-		err := candles.PushTrade(trade)
-		if err != nil {
-			e.logger.Error().
-				Err(err).
-				Str("pair", pair.String()).
-				Time("trade_time", trade.Time).
-				Msg("failed to add trade to candles")
-			continue
+		trade = trade.Reversed()
+		pair = pair.Reversed()
+	}
+	err := candles.PushTrade(trade)
+	if err != nil {
+		e.logger.Error().
+			Err(err).
+			Str("pair", pair.String()).
+			Time("trade_time", trade.Time).
+			Msg("failed to add trade to candles")
+		return
+	}
+	now := e.clock.Now().UTC()
+	e.tickers[pair.String()] = candles.Ticker()
+	e.updateStale(pair.String(), now)
+	e.stream.Publish(stream.NewTradeEvent(e.name, trade))
+	e.stream.Publish(stream.NewTickerEvent(e.name, fresh(e.tickers[pair.String()], now)))
+	e.publishClosed(pair.String(), candles)
+	if trade.Pool == "" {
+		return
+	}
+	poolCandles, ok := e.poolCandles[trade.Pool][pair.String()]
+	if !ok {
+		if loaded == nil {
+			return
+		}
+		poolCandles = loaded
+		_, ok = e.poolCandles[trade.Pool]
+		if !ok {
+			e.poolCandles[trade.Pool] = map[string]*trading.Candles{}
+			e.poolTickers[trade.Pool] = map[string]*trading.Ticker{}
 		}
-		e.tickers[pair.String()] = candles.Ticker()
+		e.poolCandles[trade.Pool][pair.String()] = poolCandles
+	}
+	err = poolCandles.PushTrade(trade)
+	if err != nil {
+		e.logger.Error().
+			Err(err).
+			Str("pair", pair.String()).
+			Str("pool", trade.Pool).
+			Time("trade_time", trade.Time).
+			Msg("failed to add trade to pool candles")
+		return
+	}
+	e.poolTickers[trade.Pool][pair.String()] = poolTicker(poolCandles, trade.Pool)
+}
+
+// loadPoolCandles builds the candles of a pool the first time it trades a pair,
+// from the pool's trades in the store. Pool candles follow the orientation of
+// the exchange's pair.
+func (e *ExchangeData) loadPoolCandles(trade *trading.Trade) *trading.Candles {
+	if trade.Pool == "" {
+		return nil
+	}
+	pair := trade.Pair()
+	e.mu.RLock()
+	_, ok := e.candles[pair.String()]
+	if !ok {
+		pair = pair.Reversed()
+		_, ok = e.candles[pair.String()]
+	}
+	_, loaded := e.poolCandles[trade.Pool][pair.String()]
+	e.mu.RUnlock()
+	if !ok || loaded {
+		return nil
 	}
+	end := e.clock.Now().UTC().Truncate(config.Cfg.CandlesInterval).Add(config.Cfg.CandlesInterval)
+	candles, err := store.PoolCandlesFromStore(e.db, trade.Pool, pair, end, config.Cfg.CandlesPeriod, config.Cfg.CandlesInterval)
+	if err != nil {
+		e.logger.Error().Err(err).Str("pair", pair.String()).Str("pool", trade.Pool).Msg("failed to load pool candles from store")
+		return nil
+	}
+	e.logger.Trace().Str("pair", pair.String()).Str("pool", trade.Pool).Msg("new pool")
+	return candles
+}
+
+func poolTicker(candles *trading.Candles, pool string) *trading.Ticker {
+	ticker := candles.Ticker()
+	ticker.Pool = pool
+	return ticker
 }
 
 func (e *ExchangeData) FillCandles() {
 	for {
-		end := time.Now().UTC().Truncate(config.Cfg.CandlesInterval).Add(config.Cfg.CandlesInterval)
+		end := e.clock.Now().UTC().Truncate(config.Cfg.CandlesInterval).Add(config.Cfg.CandlesInterval)
+		now := e.clock.Now().UTC()
+		e.mu.Lock()
 		for symbol, candles := range e.candles {
 			candles.Extend(end)
 			e.tickers[symbol] = candles.Ticker()
+			e.updateStale(symbol, now)
+			e.publishClosed(symbol, candles)
+		}
+		for pool, pairs := range e.poolCandles {
+			for symbol, candles := range pairs {
+				candles.Extend(end)
+				e.poolTickers[pool][symbol] = poolTicker(candles, pool)
+			}
 		}
+		e.mu.Unlock()
 		e.logger.Debug().Time("end", end).Msg("filled candles")
 		time.Sleep(time.Until(time.Now().Truncate(config.Cfg.CandlesInterval).Add(config.Cfg.CandlesInterval)))
 	}
 }
 
+// SetPairs adds the candles of new pairs from the store, along with those of the
+// pools that traded them within the candles period, so that pools are listed
+// after a restart without waiting for their next trade.
 func (e *ExchangeData) SetPairs(pairs []*token.Pair) {
-	candlesEnd := time.Now().UTC().Truncate(config.Cfg.CandlesInterval).Add(config.Cfg.CandlesInterval)
+	candlesEnd := e.clock.Now().UTC().Truncate(config.Cfg.CandlesInterval).Add(config.Cfg.CandlesInterval)
 	for _, pair := range pairs {
+		e.mu.RLock()
 		_, ok := e.candles[pair.String()]
+		e.mu.RUnlock()
 		if !ok {
-			candles, err := store.CandlesFromStore(e.db, pair, candlesEnd, config.Cfg.CandlesPeriod, config.Cfg.CandlesInterval)
+			candles, pools, err := store.PairCandlesFromStore(e.db, pair, candlesEnd, config.Cfg.CandlesPeriod, config.Cfg.CandlesInterval, config.Cfg.AverageWindows...)
 			if err != nil {
 				e.logger.Error().Err(err).Str("pair", pair.String()).Msg("failed to load candles from store")
 				continue
 			}
+			e.mu.Lock()
 			e.candles[pair.String()] = candles
 			e.tickers[pair.String()] = candles.Ticker()
+			e.updateStale(pair.String(), e.clock.Now().UTC())
+			e.publishClosed(pair.String(), candles)
+			for pool, poolCandles := range pools {
+				_, ok = e.poolCandles[pool]
+				if !ok {
+					e.poolCandles[pool] = map[string]*trading.Candles{}
+					e.poolTickers[pool] = map[string]*trading.Ticker{}
+				}
+				e.poolCandles[pool][pair.String()] = poolCandles
+				e.poolTickers[pool][pair.String()] = poolTicker(poolCandles, pool)
+			}
+			e.mu.Unlock()
 			e.logger.Trace().Str("pair", pair.String()).Msg("new pair")
 		}
 	}
 	e.logger.Debug().Int("num_pairs", len(pairs)).Msg("updated pairs")
 }
 
+// updateStale logs and streams when the pair's ticker goes stale or recovers.
+// The state of a new pair is only recorded. The lock must be held.
+func (e *ExchangeData) updateStale(symbol string, now time.Time) {
+	ticker := fresh(e.tickers[symbol], now)
+	stale, known := e.stale[symbol]
+	e.stale[symbol] = ticker.Stale
+	if !known || stale == ticker.Stale {
+		return
+	}
+	if ticker.Stale {
+		e.logger.Warn().
+			Str("pair", symbol).
+			Time("last_trade", ticker.LastTrade).
+			Dur("max_age", config.Cfg.Staleness.Threshold(ticker.BaseAsset, ticker.QuoteAsset)).
+			Msg("pair went stale")
+	} else {
+		e.logger.Info().Str("pair", symbol).Time("last_trade", ticker.LastTrade).Msg("pair recovered")
+	}
+	e.stream.Publish(stream.NewStaleEvent(e.name, ticker))
+}
+
+// publishClosed streams the pair's most recent closed candle once, if it had
+// trades. The candle closed when a pair is added is only recorded. The lock
+// must be held.
+func (e *ExchangeData) publishClosed(symbol string, candles *trading.Candles) {
+	candle := candles.Closed()
+	if candle == nil {
+		return
+	}
+	last, known := e.closed[symbol]
+	if known && !candle.Start.After(last) {
+		return
+	}
+	e.closed[symbol] = candle.Start
+	if !known || candle.BaseVolume.Sign() == 0 {
+		return
+	}
+	e.stream.Publish(stream.NewCandleEvent(e.name, candle))
+}
+
+// fresh returns a copy of the ticker with its freshness as of now.
+func fresh(ticker *trading.Ticker, now time.Time) *trading.Ticker {
+	return ticker.Fresh(now, config.Cfg.Staleness.Threshold(ticker.BaseAsset, ticker.QuoteAsset))
+}
+
 func (e *ExchangeData) Candles(pair *token.Pair) (*trading.Candles, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	candles, ok := e.candles[pair.String()]
 	if !ok {
 		return nil, fmt.Errorf("candles not found for pair")
@@ -185,15 +478,40 @@ func (e *ExchangeData) Candles(pair *token.Pair) (*trading.Candles, error) {
 	return candles, nil
 }
 
+// ReadCandles calls read with the candles of the pair, or of the pair in the
+// pool if one is given, holding the lock so they are not updated meanwhile.
+func (e *ExchangeData) ReadCandles(pool string, pair *token.Pair, read func(candles *trading.Candles)) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var candles *trading.Candles
+	var ok bool
+	if pool == "" {
+		candles, ok = e.candles[pair.String()]
+	} else {
+		candles, ok = e.poolCandles[pool][pair.String()]
+	}
+	if !ok {
+		return fmt.Errorf("candles not found for pair")
+	}
+	read(candles)
+	return nil
+}
+
 func (e *ExchangeData) Tickers() ([]*trading.Ticker, error) {
+	now := e.clock.Now().UTC()
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	tickers := []*trading.Ticker{}
 	for _, ticker := range e.tickers {
-		tickers = append(tickers, ticker)
+		tickers = append(tickers, fresh(ticker, now))
 	}
 	return tickers, nil
 }
 
 func (e *ExchangeData) Ticker(pair *token.Pair) (*trading.Ticker, error) {
+	now := e.clock.Now().UTC()
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	ticker, ok := e.tickers[pair.String()]
 	if !ok {
 		ticker, ok = e.tickers[pair.Reversed().String()]
@@ -202,5 +520,62 @@ func (e *ExchangeData) Ticker(pair *token.Pair) (*trading.Ticker, error) {
 		}
 		ticker = ticker.Reversed()
 	}
-	return ticker, nil
+	return fresh(ticker, now), nil
+}
+
+// Averages returns the pair's averages over the given windows, or over every
+// configured window if none are given.
+func (e *ExchangeData) Averages(pair *token.Pair, windows []time.Duration) ([]*trading.AverageValue, error) {
+	now := e.clock.Now().UTC()
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	reversed := false
+	candles, ok := e.candles[pair.String()]
+	if !ok {
+		candles, ok = e.candles[pair.Reversed().String()]
+		if !ok {
+			return nil, fmt.Errorf("candles not found for pair")
+		}
+		reversed = true
+	}
+	return candles.Averages(now, reversed, windows...)
+}
+
+// Pools lists the pairs that have traded in each pool.
+func (e *ExchangeData) Pools() map[string][]*token.Pair {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	pools := make(map[string][]*token.Pair, len(e.poolCandles))
+	for pool, pairs := range e.poolCandles {
+		for _, candles := range pairs {
+			pair := candles.Pair
+			pools[pool] = append(pools[pool], &pair)
+		}
+	}
+	return pools
+}
+
+func (e *ExchangeData) PoolCandles(pool string, pair *token.Pair) (*trading.Candles, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	candles, ok := e.poolCandles[pool][pair.String()]
+	if !ok {
+		return nil, fmt.Errorf("candles not found for pool pair")
+	}
+	return candles, nil
+}
+
+func (e *ExchangeData) PoolTickers(pool string) ([]*trading.Ticker, error) {
+	now := e.clock.Now().UTC()
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	poolTickers, ok := e.poolTickers[pool]
+	if !ok {
+		return nil, fmt.Errorf("pool not found")
+	}
+	tickers := []*trading.Ticker{}
+	for _, ticker := range poolTickers {
+		tickers = append(tickers, fresh(ticker, now))
+	}
+	return tickers, nil
 }
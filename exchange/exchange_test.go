@@ -0,0 +1,111 @@
+package exchange
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"indexer/config"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/rs/zerolog"
+)
+
+// TestMain keeps an hour of minute candles. Exchange data goroutines read the
+// candles config for as long as the tests run, so it is set once here.
+func TestMain(m *testing.M) {
+	config.Cfg.CandlesInterval = time.Minute
+	config.Cfg.CandlesPeriod = time.Hour
+	os.Exit(m.Run())
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestSetPairsLoadsPoolCandles(t *testing.T) {
+	stores, err := store.NewMemoryManager(&store.MemoryConfig{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := stores.Store("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := &token.Pair{Base: "ATOM", Quote: "USDC"}
+	for _, stored := range []struct {
+		pool   string
+		offset time.Duration
+		routed bool
+		base   string
+		quote  string
+	}{
+		{"1", 10 * time.Minute, false, "ATOM", "USDC"},
+		{"2", 20 * time.Minute, false, "USDC", "ATOM"},
+		{"1", 30 * time.Minute, false, "ATOM", "USDC"},
+		{"3", 40 * time.Minute, true, "ATOM", "USDC"},
+		// traded before the candles period
+		{"4", -10 * time.Minute, false, "ATOM", "USDC"},
+	} {
+		trade := &trading.Trade{
+			Base:   token.Token{Symbol: stored.base},
+			Quote:  token.Token{Symbol: stored.quote},
+			Time:   filterStart.Add(stored.offset + 30*time.Second),
+			Pool:   stored.pool,
+			Routed: stored.routed,
+		}
+		trade.Base.Amount.SetMantScale(1, 0)
+		trade.Quote.Amount.SetMantScale(1, 0)
+		err = db.SaveTrade(trade)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mock := NewMockExchange("test", db, zerolog.Nop())
+	manager, err := NewExchangeManager(map[string]Exchange{"test": mock}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetClock(fixedClock(filterStart.Add(59 * time.Minute)))
+	manager.Start()
+	mock.AddPairs(pair)
+	deadline := time.Now().Add(5 * time.Second)
+	for manager.ReadCandles("test", "", pair, func(*trading.Candles) {}) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("pair was not added")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	pools, err := manager.Pools("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 2 || len(pools["1"]) != 1 || len(pools["2"]) != 1 {
+		t.Fatalf("got pools %v, want 1 and 2", pools)
+	}
+	for pool, volume := range map[string]int64{"1": 2, "2": 1} {
+		tickers, err := manager.PoolTickers("test", pool)
+		if err != nil {
+			t.Fatalf("pool %s: %v", pool, err)
+		}
+		if len(tickers) != 1 {
+			t.Fatalf("pool %s: got %d tickers, want 1", pool, len(tickers))
+		}
+		ticker := tickers[0]
+		if ticker.BaseAsset != "ATOM" || ticker.Pool != pool {
+			t.Errorf("pool %s: got %s/%s ticker of pool %s", pool, ticker.BaseAsset, ticker.QuoteAsset, ticker.Pool)
+		}
+		got, _ := ticker.BaseVolume.Int64()
+		if got != volume {
+			t.Errorf("pool %s: got base volume %s, want %d", pool, ticker.BaseVolume.String(), volume)
+		}
+		err = manager.ReadCandles("test", pool, pair, func(*trading.Candles) {})
+		if err != nil {
+			t.Errorf("pool %s: %v", pool, err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package exchange
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"indexer/config"
+	"indexer/store"
+	"indexer/trading"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	FilterModeFlag    = "flag"
+	FilterModeExclude = "exclude"
+
+	FlaggedDeviation     = "deviation"
+	FlaggedSigma         = "sigma"
+	FlaggedPoolDeviation = "pool_deviation"
+
+	// filterMaxTrades bounds the prices kept per pair, however short the window.
+	filterMaxTrades = 1000
+	// madScale turns a median absolute deviation into a standard deviation for
+	// normally distributed prices.
+	madScale = 1.4826
+)
+
+var FilterModes = map[string]struct{}{
+	"":                {},
+	FilterModeFlag:    {},
+	FilterModeExclude: {},
+}
+
+type (
+	// TradeFilter checks the trades of an exchange against the recent prices of
+	// their pair before passing them on. Prices are compared as logarithms so that
+	// both orientations of a pair give the same result. References are medians,
+	// and every trade is added to them whether flagged or not, so a lasting move
+	// in price stops being flagged once it makes up most of the window.
+	TradeFilter struct {
+		cfg    config.FilterConfig
+		db     store.Store
+		prices map[string][]filterPrice
+		pools  map[string]map[string]filterPrice
+		ack    func(trade *trading.Trade)
+		logger zerolog.Logger
+	}
+
+	filterPrice struct {
+		time  time.Time
+		price float64
+	}
+)
+
+func NewTradeFilter(cfg config.FilterConfig, db store.Store, logger zerolog.Logger) *TradeFilter {
+	return &TradeFilter{
+		cfg:    cfg,
+		db:     db,
+		prices: map[string][]filterPrice{},
+		pools:  map[string]map[string]filterPrice{},
+		ack:    func(*trading.Trade) {},
+		logger: logger,
+	}
+}
+
+// Filter passes on the trades received from in, saving flagged trades to the
+// store and leaving them out in exclude mode. Routed trades are passed on
+// unchecked. The returned channel is closed once in is.
+func (f *TradeFilter) Filter(in chan *trading.Trade) chan *trading.Trade {
+	out := make(chan *trading.Trade)
+	go func() {
+		defer close(out)
+		for trade := range in {
+			if trade.Routed {
+				out <- trade
+				continue
+			}
+			flagged := f.Check(trade)
+			if flagged != nil {
+				flagged.Excluded = f.cfg.Mode == FilterModeExclude
+				err := f.db.SaveFlaggedTrade(flagged)
+				if err != nil {
+					f.logger.Error().Err(err).Str("tx_hash", trade.TxHash).Msg("failed to save flagged trade")
+				}
+				f.logger.Warn().
+					Str("pair", trade.Pair().String()).
+					Str("pool", trade.Pool).
+					Str("tx_hash", trade.TxHash).
+					Str("price", flagged.TradePrice.String()).
+					Str("reference_price", flagged.Reference.String()).
+					Str("reason", flagged.Reason).
+					Float64("deviation", flagged.Deviation).
+					Bool("excluded", flagged.Excluded).
+					Msg("flagged trade")
+				if flagged.Excluded {
+					f.ack(trade)
+					continue
+				}
+			}
+			out <- trade
+		}
+	}()
+	return out
+}
+
+// Check returns the trade flagged if its price deviates too far from its
+// references, or nil, and adds the price to the references.
+func (f *TradeFilter) Check(trade *trading.Trade) *trading.FlaggedTrade {
+	price := trade.Price()
+	value, _ := price.Float64()
+	if value <= 0 || math.IsInf(value, 0) {
+		return nil
+	}
+	// keep one orientation per pair, with the base sorting first
+	key := trade.Pair()
+	sign := 1.0
+	if key.Base > key.Quote {
+		key = key.Reversed()
+		sign = -1
+	}
+	logPrice := sign * math.Log(value)
+	f.expire(key.String(), trade.Time)
+	reason, reference, deviation := f.check(key.String(), trade.Pool, logPrice)
+	f.add(key.String(), trade.Pool, trade.Time, logPrice)
+	if reason == "" {
+		return nil
+	}
+	flagged := &trading.FlaggedTrade{
+		Trade:     *trade,
+		Reason:    reason,
+		Deviation: deviation,
+	}
+	flagged.TradePrice.Set(price)
+	// float64 carries about 15 significant digits, the rest is noise
+	flagged.Reference.SetString(strconv.FormatFloat(math.Exp(sign*reference), 'g', 15, 64))
+	return flagged
+}
+
+// check returns the reason the price is off, if any, with the reference it was
+// compared to and by how much it deviates: a fraction for deviation reasons and
+// a number of deviations for sigma.
+func (f *TradeFilter) check(key string, pool string, logPrice float64) (string, float64, float64) {
+	window := f.prices[key]
+	if len(window) >= f.cfg.MinTrades && len(window) > 0 {
+		values := make([]float64, len(window))
+		for i, p := range window {
+			values[i] = p.price
+		}
+		mid := median(values)
+		distance := math.Abs(logPrice - mid)
+		deviation := math.Exp(distance) - 1
+		if f.cfg.MaxDeviation > 0 && deviation > f.cfg.MaxDeviation {
+			return FlaggedDeviation, mid, deviation
+		}
+		for i := range values {
+			values[i] = math.Abs(values[i] - mid)
+		}
+		sigma := median(values) * madScale
+		if f.cfg.MaxSigma > 0 && sigma > 0 && distance/sigma > f.cfg.MaxSigma {
+			return FlaggedSigma, mid, distance / sigma
+		}
+	}
+	// with fewer than two other pools there is no telling which one is off
+	others := []float64{}
+	for otherPool, p := range f.pools[key] {
+		if otherPool != pool {
+			others = append(others, p.price)
+		}
+	}
+	if pool != "" && len(others) >= 2 && f.cfg.MaxDeviation > 0 {
+		mid := median(others)
+		deviation := math.Exp(math.Abs(logPrice-mid)) - 1
+		if deviation > f.cfg.MaxDeviation {
+			return FlaggedPoolDeviation, mid, deviation
+		}
+	}
+	return "", 0, 0
+}
+
+// expire drops the prices of the pair from before the window ending at t, both
+// from the rolling window and from the last prices of its pools.
+func (f *TradeFilter) expire(key string, t time.Time) {
+	cutoff := t.Add(-f.cfg.Window)
+	window := f.prices[key]
+	n := 0
+	for n < len(window) && window[n].time.Before(cutoff) {
+		n++
+	}
+	f.prices[key] = window[n:]
+	for pool, p := range f.pools[key] {
+		if p.time.Before(cutoff) {
+			delete(f.pools[key], pool)
+		}
+	}
+}
+
+func (f *TradeFilter) add(key string, pool string, t time.Time, logPrice float64) {
+	window := f.prices[key]
+	n := 0
+	if len(window) >= filterMaxTrades {
+		n = len(window) - filterMaxTrades + 1
+	}
+	window = append(window[n:], filterPrice{time: t, price: logPrice})
+	f.prices[key] = window
+	if pool == "" {
+		return
+	}
+	_, ok := f.pools[key]
+	if !ok {
+		f.pools[key] = map[string]filterPrice{}
+	}
+	f.pools[key][pool] = filterPrice{time: t, price: logPrice}
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,223 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"indexer/config"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/rs/zerolog"
+)
+
+var filterStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var testFilterConfig = config.FilterConfig{
+	Window:       time.Hour,
+	MinTrades:    10,
+	MaxDeviation: 0.25,
+	MaxSigma:     5,
+}
+
+// filterTrade trades one ATOM for price USDC in pool, offset from filterStart.
+// A negative price trades the other way round, for 1/-price ATOM per USDC.
+type filterTrade struct {
+	offset time.Duration
+	pool   string
+	price  float64
+}
+
+func (f filterTrade) trade() *trading.Trade {
+	base, quote, price := "ATOM", "USDC", f.price
+	if price < 0 {
+		base, quote, price = quote, base, -1/price
+	}
+	trade := &trading.Trade{
+		Base:  token.Token{Symbol: base},
+		Quote: token.Token{Symbol: quote},
+		Pool:  f.pool,
+		Time:  filterStart.Add(f.offset),
+	}
+	trade.Base.Amount.SetUint64(1)
+	trade.Quote.Amount.SetFloat64(price)
+	return trade
+}
+
+// steadyTrades trades n times at prices alternating around 10, a minute apart
+// and ending a minute before filterStart.
+func steadyTrades(n int, pool string) []filterTrade {
+	trades := []filterTrade{}
+	for i := 0; i < n; i++ {
+		price := 10.0
+		if i%2 == 1 {
+			price = 10.1
+		}
+		trades = append(trades, filterTrade{time.Duration(i-n) * time.Minute, pool, price})
+	}
+	return trades
+}
+
+func TestTradeFilterCheck(t *testing.T) {
+	poolsConfig := testFilterConfig
+	poolsConfig.MinTrades = 100
+	tests := []struct {
+		name    string
+		cfg     config.FilterConfig
+		history []filterTrade
+		trade   filterTrade
+		reason  string
+	}{
+		{
+			name:    "in line",
+			cfg:     testFilterConfig,
+			history: steadyTrades(10, ""),
+			trade:   filterTrade{0, "", 10.05},
+		},
+		{
+			name:    "deviation",
+			cfg:     testFilterConfig,
+			history: steadyTrades(10, ""),
+			trade:   filterTrade{0, "", 14},
+			reason:  FlaggedDeviation,
+		},
+		{
+			name:    "deviation below",
+			cfg:     testFilterConfig,
+			history: steadyTrades(10, ""),
+			trade:   filterTrade{0, "", 7.5},
+			reason:  FlaggedDeviation,
+		},
+		{
+			name:    "deviation in the other orientation",
+			cfg:     testFilterConfig,
+			history: steadyTrades(10, ""),
+			trade:   filterTrade{0, "", -14},
+			reason:  FlaggedDeviation,
+		},
+		{
+			name:    "sigma",
+			cfg:     testFilterConfig,
+			history: steadyTrades(10, ""),
+			trade:   filterTrade{0, "", 11},
+			reason:  FlaggedSigma,
+		},
+		{
+			name:    "fewer than min trades",
+			cfg:     testFilterConfig,
+			history: steadyTrades(9, ""),
+			trade:   filterTrade{0, "", 14},
+		},
+		{
+			name:    "outside the window",
+			cfg:     testFilterConfig,
+			history: steadyTrades(10, ""),
+			trade:   filterTrade{2 * time.Hour, "", 14},
+		},
+		{
+			name: "pool deviation",
+			cfg:  poolsConfig,
+			history: []filterTrade{
+				{-2 * time.Minute, "a", 10},
+				{-time.Minute, "b", 10.1},
+			},
+			trade:  filterTrade{0, "c", 14},
+			reason: FlaggedPoolDeviation,
+		},
+		{
+			name: "pool deviation against its own pool",
+			cfg:  poolsConfig,
+			history: []filterTrade{
+				{-2 * time.Minute, "a", 10},
+				{-time.Minute, "b", 10.1},
+			},
+			trade: filterTrade{0, "a", 14},
+		},
+		{
+			name: "pool deviation with one other pool",
+			cfg:  poolsConfig,
+			history: []filterTrade{
+				{-2 * time.Minute, "a", 10},
+				{-time.Minute, "a", 10.1},
+			},
+			trade: filterTrade{0, "c", 14},
+		},
+		{
+			name: "pool prices outside the window",
+			cfg:  poolsConfig,
+			history: []filterTrade{
+				{-2 * time.Minute, "a", 10},
+				{-time.Minute, "b", 10.1},
+			},
+			trade: filterTrade{time.Hour, "c", 14},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := NewTradeFilter(test.cfg, nil, zerolog.Nop())
+			for _, trade := range test.history {
+				flagged := filter.Check(trade.trade())
+				if flagged != nil {
+					t.Fatalf("history trade flagged: %s", flagged.Reason)
+				}
+			}
+			flagged := filter.Check(test.trade.trade())
+			reason := ""
+			if flagged != nil {
+				reason = flagged.Reason
+			}
+			if reason != test.reason {
+				t.Errorf("got reason %q, want %q", reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestTradeFilterModes(t *testing.T) {
+	for _, mode := range []string{FilterModeFlag, FilterModeExclude} {
+		t.Run(mode, func(t *testing.T) {
+			stores, err := store.NewMemoryManager(&store.MemoryConfig{}, zerolog.Nop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, err := stores.Store("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := testFilterConfig
+			cfg.Mode = mode
+			filter := NewTradeFilter(cfg, db, zerolog.Nop())
+			in := make(chan *trading.Trade)
+			out := filter.Filter(in)
+			trades := append(steadyTrades(10, ""), filterTrade{0, "", 14})
+			go func() {
+				for _, trade := range trades {
+					in <- trade.trade()
+				}
+				close(in)
+			}()
+			passed := 0
+			for range out {
+				passed++
+			}
+			want := len(trades)
+			if mode == FilterModeExclude {
+				want--
+			}
+			if passed != want {
+				t.Errorf("got %d trades passed on, want %d", passed, want)
+			}
+			flagged, err := db.FlaggedTrades(filterStart.Add(-time.Hour), filterStart.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(flagged) != 1 {
+				t.Fatalf("got %d flagged trades, want 1", len(flagged))
+			}
+			if flagged[0].Excluded != (mode == FilterModeExclude) {
+				t.Errorf("got excluded %t in %s mode", flagged[0].Excluded, mode)
+			}
+		})
+	}
+}
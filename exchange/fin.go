@@ -0,0 +1,302 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"indexer/chain"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/cometbft/cometbft/types"
+	"github.com/rs/zerolog"
+)
+
+func init() {
+	Register("fin", NewFinExchangeFromConfig)
+}
+
+type (
+	FinConfig struct {
+		CodeIds   []uint64 `toml:"code_ids"`
+		Contracts []string `toml:"contracts"`
+	}
+
+	FinExchange struct {
+		*ChainExchange
+		cfg       *FinConfig
+		marketsMu sync.RWMutex
+		markets   map[string]*FinMarket
+	}
+
+	FinMarket struct {
+		Contract string
+		Base     string
+		Quote    string
+	}
+
+	FinTrade struct {
+		Market      string
+		BaseAmount  string
+		QuoteAmount string
+		Type        string
+	}
+
+	FinDenom string
+
+	finConfigResponse struct {
+		Denoms []FinDenom `json:"denoms"`
+	}
+)
+
+func NewFinExchangeFromConfig(name string, cfg *FinConfig, store store.Store, logger zerolog.Logger) (Exchange, error) {
+	rpc, err := ChainPool(name, logger)
+	if err != nil {
+		return nil, err
+	}
+	return NewFinExchange(name, cfg, rpc, store, logger)
+}
+
+func NewFinExchange(name string, cfg *FinConfig, rpc *chain.CometPool, store store.Store, logger zerolog.Logger) (*FinExchange, error) {
+	if len(cfg.CodeIds) == 0 && len(cfg.Contracts) == 0 {
+		return nil, fmt.Errorf("no fin code ids or contracts configured")
+	}
+	f := &FinExchange{
+		cfg:     cfg,
+		markets: map[string]*FinMarket{},
+	}
+	assets := NewAssetRegistry(name, nil, logger)
+	f.ChainExchange = NewChainExchange(name, rpc, assets, store, "tm.event='Tx' AND wasm-trade.market EXISTS", f.GetTrades, logger)
+	f.Poll(f.RefreshMarkets)
+	return f, nil
+}
+
+func (f *FinExchange) DisplayName() string {
+	return "FIN"
+}
+
+func (f *FinExchange) Market(address string) (*FinMarket, bool) {
+	f.marketsMu.RLock()
+	defer f.marketsMu.RUnlock()
+	market, ok := f.markets[address]
+	return market, ok
+}
+
+func (f *FinExchange) GetTrades(event *coretypes.ResultEvent) []trading.Trade {
+	trades := []trading.Trade{}
+	finTrades, err := ParseFinTrades(event)
+	if err != nil {
+		f.logger.Error().Err(err).Msg("failed to parse trade event")
+		return trades
+	}
+	if len(finTrades) == 0 {
+		return trades
+	}
+	if f.assets.Len() == 0 {
+		f.logger.Warn().Msg("cannot process trades when asset list is empty")
+		return trades
+	}
+	height, err := chain.EventHeight(event)
+	if err != nil {
+		f.logger.Error().Err(err).Msg("failed to get trade event height")
+		return trades
+	}
+	txHash := chain.EventTxHash(event)
+	blockTime, err := f.rpc.BlockTime(height)
+	if err != nil {
+		f.logger.Warn().Err(err).Int64("height", height).Msg("falling back to receive time for trades")
+		blockTime = time.Now().UTC()
+	}
+	for _, finTrade := range finTrades {
+		market, ok := f.Market(finTrade.Market)
+		if !ok {
+			f.logger.Debug().Str("market", finTrade.Market).Msg("skipping unknown market trade")
+			continue
+		}
+		base, err := f.RebaseAmount(finTrade.BaseAmount, market.Base)
+		if err != nil {
+			f.logger.Debug().Err(err).Str("denom", market.Base).Msg("failed to rebase base amount")
+			continue
+		}
+		quote, err := f.RebaseAmount(finTrade.QuoteAmount, market.Quote)
+		if err != nil {
+			f.logger.Debug().Err(err).Str("denom", market.Quote).Msg("failed to rebase quote amount")
+			continue
+		}
+		if base.Amount.Sign() == 0 || quote.Amount.Sign() == 0 {
+			continue
+		}
+		trades = append(trades, trading.Trade{
+			Base:   *base,
+			Quote:  *quote,
+			Time:   blockTime,
+			Height: height,
+			TxHash: txHash,
+			Pool:   market.Contract,
+		})
+	}
+	return trades
+}
+
+func (f *FinExchange) RebaseAmount(amount string, denom string) (*token.Token, error) {
+	t, err := token.ParseToken(amount + denom)
+	if err != nil {
+		return nil, err
+	}
+	return f.assets.Rebase(t)
+}
+
+// RefreshMarkets updates the markets of the exchange and returns their pairs.
+func (f *FinExchange) RefreshMarkets() ([]*token.Pair, error) {
+	markets, err := f.DiscoverMarkets(f.cfg.CodeIds, f.cfg.Contracts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover markets: %v", err)
+	}
+	pairs := []*token.Pair{}
+	seen := map[string]struct{}{}
+	for _, market := range markets {
+		baseAsset, ok := f.assets.Asset(market.Base)
+		if !ok {
+			f.logger.Debug().Str("denom", market.Base).Str("market", market.Contract).Msg("skipping unlisted asset market")
+			continue
+		}
+		quoteAsset, ok := f.assets.Asset(market.Quote)
+		if !ok {
+			f.logger.Debug().Str("denom", market.Quote).Str("market", market.Contract).Msg("skipping unlisted asset market")
+			continue
+		}
+		pair := &token.Pair{
+			Base:  baseAsset.Symbol,
+			Quote: quoteAsset.Symbol,
+		}
+		_, ok = seen[pair.String()]
+		if ok {
+			continue
+		}
+		seen[pair.String()] = struct{}{}
+		pairs = append(pairs, pair)
+	}
+	f.marketsMu.Lock()
+	f.markets = markets
+	f.marketsMu.Unlock()
+	f.logger.Debug().Int("num_markets", len(markets)).Msg("refreshed markets")
+	return pairs, nil
+}
+
+// DiscoverMarkets lists the market contracts instantiated from the configured FIN
+// code ids plus any explicitly configured contracts, querying the config of
+// markets not seen before to learn their denoms.
+func (f *FinExchange) DiscoverMarkets(codeIds []uint64, contracts []string) (map[string]*FinMarket, error) {
+	addresses := append([]string{}, contracts...)
+	for _, codeId := range codeIds {
+		codeContracts, err := f.rpc.WasmContractsByCode(codeId)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, codeContracts...)
+	}
+	markets := make(map[string]*FinMarket, len(addresses))
+	for _, address := range addresses {
+		market, ok := f.Market(address)
+		if !ok {
+			res := finConfigResponse{}
+			err := f.rpc.WasmSmartQuery(address, map[string]any{"config": struct{}{}}, &res)
+			if err != nil {
+				f.logger.Warn().Err(err).Str("market", address).Msg("failed to query market config")
+				continue
+			}
+			if len(res.Denoms) != 2 {
+				f.logger.Warn().Str("market", address).Int("num_denoms", len(res.Denoms)).Msg("unexpected market denoms")
+				continue
+			}
+			market = &FinMarket{
+				Contract: address,
+				Base:     string(res.Denoms[0]),
+				Quote:    string(res.Denoms[1]),
+			}
+		}
+		markets[address] = market
+	}
+	return markets, nil
+}
+
+// UnmarshalJSON accepts both plain denom strings and the {"native": denom} form.
+func (d *FinDenom) UnmarshalJSON(b []byte) error {
+	var denom string
+	if err := json.Unmarshal(b, &denom); err == nil {
+		*d = FinDenom(denom)
+		return nil
+	}
+	var native struct {
+		Native string `json:"native"`
+	}
+	if err := json.Unmarshal(b, &native); err != nil {
+		return err
+	}
+	if native.Native == "" {
+		return fmt.Errorf("unsupported denom: %s", string(b))
+	}
+	*d = FinDenom(native.Native)
+	return nil
+}
+
+func ParseFinTrades(event *coretypes.ResultEvent) ([]FinTrade, error) {
+	data, ok := event.Data.(types.EventDataTx)
+	if !ok || len(data.Result.Events) == 0 {
+		return parseFinFlatTrades(event)
+	}
+	trades := []FinTrade{}
+	for _, attributes := range chain.EventAttributes(event, "wasm-trade") {
+		market, ok := attributes["market"]
+		if !ok {
+			market, ok = attributes["_contract_address"]
+			if !ok {
+				return nil, fmt.Errorf("trade event missing market")
+			}
+		}
+		baseAmount, ok := attributes["base_amount"]
+		if !ok {
+			return nil, fmt.Errorf("trade event missing base_amount")
+		}
+		quoteAmount, ok := attributes["quote_amount"]
+		if !ok {
+			return nil, fmt.Errorf("trade event missing quote_amount")
+		}
+		trades = append(trades, FinTrade{
+			Market:      market,
+			BaseAmount:  baseAmount,
+			QuoteAmount: quoteAmount,
+			Type:        attributes["type"],
+		})
+	}
+	return trades, nil
+}
+
+func parseFinFlatTrades(event *coretypes.ResultEvent) ([]FinTrade, error) {
+	markets, ok := event.Events["wasm-trade.market"]
+	if !ok {
+		return []FinTrade{}, nil
+	}
+	baseAmounts := event.Events["wasm-trade.base_amount"]
+	quoteAmounts := event.Events["wasm-trade.quote_amount"]
+	sides := event.Events["wasm-trade.type"]
+	if len(baseAmounts) != len(markets) || len(quoteAmounts) != len(markets) {
+		return nil, fmt.Errorf("trade event attributes length mismatch")
+	}
+	trades := make([]FinTrade, len(markets))
+	for i, market := range markets {
+		trades[i] = FinTrade{
+			Market:      market,
+			BaseAmount:  baseAmounts[i],
+			QuoteAmount: quoteAmounts[i],
+		}
+		if len(sides) == len(markets) {
+			trades[i].Type = sides[i]
+		}
+	}
+	return trades, nil
+}
@@ -0,0 +1,94 @@
+package exchange
+
+import (
+	"encoding/json"
+	"testing"
+
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+)
+
+const finMarket = "kujira14hj2tavq8fpesdwxxcu44rty3hh90vhujrvcmstl4zr3txmfvw9sl4e867"
+
+func TestParseFinTrades(t *testing.T) {
+	event := loadEvent(t, "fin_trade.json")
+	flat := *event
+	flat.Data = nil
+	want := []FinTrade{
+		{Market: finMarket, BaseAmount: "1250000", QuoteAmount: "900000", Type: "sell"},
+		{Market: finMarket, BaseAmount: "750000", QuoteAmount: "539250", Type: "sell"},
+	}
+	for name, event := range map[string]*coretypes.ResultEvent{"ordered": event, "flat": &flat} {
+		trades, err := ParseFinTrades(event)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(trades) != len(want) {
+			t.Fatalf("%s: got %d trades, want %d", name, len(trades), len(want))
+		}
+		for i, trade := range trades {
+			if trade != want[i] {
+				t.Errorf("%s: got trade %+v, want %+v", name, trade, want[i])
+			}
+		}
+	}
+}
+
+func TestParseFinFlatTradesLengthMismatch(t *testing.T) {
+	event := loadEvent(t, "fin_trade.json")
+	event.Data = nil
+	event.Events["wasm-trade.quote_amount"] = []string{"900000"}
+	_, err := ParseFinTrades(event)
+	if err == nil {
+		t.Fatal("got no error for trade attributes of different lengths")
+	}
+}
+
+func TestParseFinTradesWithoutTrades(t *testing.T) {
+	event := loadEvent(t, "osmosis_cosmwasm_swap.json")
+	trades, err := ParseFinTrades(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 0 {
+		t.Errorf("got %d trades from a transaction without FIN trades, want none", len(trades))
+	}
+}
+
+func TestFinDenom(t *testing.T) {
+	tests := []struct {
+		json  string
+		denom FinDenom
+		err   bool
+	}{
+		{json: `"ukuji"`, denom: "ukuji"},
+		{json: `{"native":"ibc/295548A78785A1007F232DE286149A6FF512F180AF5657780FC89C009E2C348F"}`, denom: "ibc/295548A78785A1007F232DE286149A6FF512F180AF5657780FC89C009E2C348F"},
+		{json: `{"cw20":"kujira1contract"}`, err: true},
+		{json: `42`, err: true},
+	}
+	for _, test := range tests {
+		var denom FinDenom
+		err := json.Unmarshal([]byte(test.json), &denom)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: got denom %s, want an error", test.json, denom)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.json, err)
+			continue
+		}
+		if denom != test.denom {
+			t.Errorf("%s: got denom %s, want %s", test.json, denom, test.denom)
+		}
+	}
+
+	res := finConfigResponse{}
+	err := json.Unmarshal([]byte(`{"denoms":[{"native":"ukuji"},"factory/kujira1/usk"],"price_precision":{"decimal_places":4}}`), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Denoms) != 2 || res.Denoms[0] != "ukuji" || res.Denoms[1] != "factory/kujira1/usk" {
+		t.Errorf("got market denoms %v, want [ukuji factory/kujira1/usk]", res.Denoms)
+	}
+}
@@ -0,0 +1,244 @@
+package exchange
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"indexer/chain"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/rs/zerolog"
+)
+
+func init() {
+	Register("generic", NewGenericExchangeFromConfig)
+}
+
+type (
+	// GenericConfig maps the swap events of a DEX to trades. Tokens are read either
+	// from a single attribute holding amount and denom (token_in/token_out) or from
+	// separate amount and denom attributes.
+	GenericConfig struct {
+		DisplayName string            `toml:"display_name"`
+		Query       string            `toml:"query"`
+		EventType   string            `toml:"event_type"`
+		TokenIn     string            `toml:"token_in"`
+		TokenOut    string            `toml:"token_out"`
+		AmountIn    string            `toml:"amount_in"`
+		DenomIn     string            `toml:"denom_in"`
+		AmountOut   string            `toml:"amount_out"`
+		DenomOut    string            `toml:"denom_out"`
+		PoolId      string            `toml:"pool_id"`
+		Pools       []string          `toml:"pools"`
+		Filter      map[string]string `toml:"filter"`
+		Pairs       []string          `toml:"pairs"`
+	}
+
+	GenericExchange struct {
+		*ChainExchange
+		cfg   *GenericConfig
+		pools map[string]struct{}
+	}
+
+	GenericSwap struct {
+		In   token.Token
+		Out  token.Token
+		Pool string
+	}
+)
+
+func NewGenericExchangeFromConfig(name string, cfg *GenericConfig, store store.Store, logger zerolog.Logger) (Exchange, error) {
+	rpc, err := ChainPool(name, logger)
+	if err != nil {
+		return nil, err
+	}
+	return NewGenericExchange(name, cfg, rpc, store, logger)
+}
+
+func NewGenericExchange(name string, cfg *GenericConfig, rpc *chain.CometPool, store store.Store, logger zerolog.Logger) (*GenericExchange, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid generic exchange config: %v", err)
+	}
+	pools := make(map[string]struct{}, len(cfg.Pools))
+	for _, pool := range cfg.Pools {
+		pools[pool] = struct{}{}
+	}
+	g := &GenericExchange{
+		cfg:   cfg,
+		pools: pools,
+	}
+	assets := NewAssetRegistry(name, nil, logger)
+	g.ChainExchange = NewChainExchange(name, rpc, assets, store, cfg.SubscriptionQuery(), g.GetTrades, logger)
+	g.Poll(g.ConfiguredPairs)
+	return g, nil
+}
+
+func (c *GenericConfig) Validate() error {
+	if c.EventType == "" {
+		return fmt.Errorf("missing event_type")
+	}
+	if c.TokenIn == "" && (c.AmountIn == "" || c.DenomIn == "") {
+		return fmt.Errorf("must set token_in or both amount_in and denom_in")
+	}
+	if c.TokenOut == "" && (c.AmountOut == "" || c.DenomOut == "") {
+		return fmt.Errorf("must set token_out or both amount_out and denom_out")
+	}
+	if len(c.Pools) > 0 && c.PoolId == "" {
+		return fmt.Errorf("pools filter requires pool_id")
+	}
+	for _, pair := range c.Pairs {
+		_, err := token.PairFromString(pair)
+		if err != nil {
+			return fmt.Errorf("invalid pair '%s': %v", pair, err)
+		}
+	}
+	return nil
+}
+
+func (c *GenericConfig) SubscriptionQuery() string {
+	if c.Query != "" {
+		return c.Query
+	}
+	attribute := c.TokenIn
+	if attribute == "" {
+		attribute = c.AmountIn
+	}
+	return fmt.Sprintf("tm.event='Tx' AND %s.%s EXISTS", c.EventType, attribute)
+}
+
+func (g *GenericExchange) DisplayName() string {
+	if g.cfg.DisplayName != "" {
+		return g.cfg.DisplayName
+	}
+	return g.name
+}
+
+func (g *GenericExchange) GetTrades(event *coretypes.ResultEvent) []trading.Trade {
+	trades := []trading.Trade{}
+	swaps, err := g.ParseSwaps(event)
+	if err != nil {
+		g.logger.Error().Err(err).Msg("failed to parse swap event")
+		return trades
+	}
+	if len(swaps) == 0 {
+		return trades
+	}
+	if g.assets.Len() == 0 {
+		g.logger.Warn().Msg("cannot process trades when asset list is empty")
+		return trades
+	}
+	height, err := chain.EventHeight(event)
+	if err != nil {
+		g.logger.Error().Err(err).Msg("failed to get swap event height")
+		return trades
+	}
+	txHash := chain.EventTxHash(event)
+	blockTime, err := g.rpc.BlockTime(height)
+	if err != nil {
+		g.logger.Warn().Err(err).Int64("height", height).Msg("falling back to receive time for trades")
+		blockTime = time.Now().UTC()
+	}
+	for _, swap := range swaps {
+		if len(g.pools) > 0 {
+			_, ok := g.pools[swap.Pool]
+			if !ok {
+				continue
+			}
+		}
+		base, err := g.assets.Rebase(&swap.In)
+		if err != nil {
+			g.logger.Debug().Err(err).Msg("skipping unlisted asset swap")
+			continue
+		}
+		quote, err := g.assets.Rebase(&swap.Out)
+		if err != nil {
+			g.logger.Debug().Err(err).Msg("skipping unlisted asset swap")
+			continue
+		}
+		if base.Amount.Sign() == 0 || quote.Amount.Sign() == 0 {
+			continue
+		}
+		trades = append(trades, trading.Trade{
+			Base:   *base,
+			Quote:  *quote,
+			Time:   blockTime,
+			Height: height,
+			TxHash: txHash,
+			Pool:   swap.Pool,
+		})
+	}
+	return trades
+}
+
+func (g *GenericExchange) ParseSwaps(event *coretypes.ResultEvent) ([]GenericSwap, error) {
+	swaps := []GenericSwap{}
+	for _, attributes := range chain.EventAttributes(event, g.cfg.EventType) {
+		if !g.matchesFilter(attributes) {
+			continue
+		}
+		in, err := g.parseToken(attributes, g.cfg.TokenIn, g.cfg.AmountIn, g.cfg.DenomIn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse input token: %v", err)
+		}
+		out, err := g.parseToken(attributes, g.cfg.TokenOut, g.cfg.AmountOut, g.cfg.DenomOut)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse output token: %v", err)
+		}
+		swaps = append(swaps, GenericSwap{
+			In:   *in,
+			Out:  *out,
+			Pool: attributes[g.cfg.PoolId],
+		})
+	}
+	return swaps, nil
+}
+
+func (g *GenericExchange) matchesFilter(attributes map[string]string) bool {
+	for key, value := range g.cfg.Filter {
+		if attributes[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *GenericExchange) parseToken(attributes map[string]string, tokenKey string, amountKey string, denomKey string) (*token.Token, error) {
+	if tokenKey != "" {
+		value, ok := attributes[tokenKey]
+		if !ok {
+			return nil, fmt.Errorf("event missing %s", tokenKey)
+		}
+		// coin lists are comma separated; swaps only ever move a single coin each way
+		return token.ParseToken(strings.Split(value, ",")[0])
+	}
+	amount, ok := attributes[amountKey]
+	if !ok {
+		return nil, fmt.Errorf("event missing %s", amountKey)
+	}
+	denom, ok := attributes[denomKey]
+	if !ok {
+		return nil, fmt.Errorf("event missing %s", denomKey)
+	}
+	return token.ParseToken(amount + denom)
+}
+
+// ConfiguredPairs returns the configured pairs whose assets are listed.
+func (g *GenericExchange) ConfiguredPairs() ([]*token.Pair, error) {
+	pairs := []*token.Pair{}
+	for _, pairString := range g.cfg.Pairs {
+		pair, _ := token.PairFromString(pairString)
+		_, hasBase := g.assets.AssetBySymbol(pair.Base)
+		_, hasQuote := g.assets.AssetBySymbol(pair.Quote)
+		if !hasBase || !hasQuote {
+			g.logger.Warn().Str("pair", pairString).Msg("skipping pair with unlisted asset")
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
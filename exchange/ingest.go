@@ -0,0 +1,178 @@
+package exchange
+
+import (
+	"time"
+
+	"indexer/chain"
+	"indexer/store"
+
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/rs/zerolog"
+)
+
+const IngestRetryInterval = 10 * time.Second
+
+type (
+	EventHandler func(event *coretypes.ResultEvent)
+
+	// Ingester hands the events of a subscription to a handler block by block,
+	// checkpointing each block once the next one starts and flush has returned,
+	// i.e. once the trades handed on for it have been saved. Blocks missed while
+	// the process was down, during a failover or while the subscriber fell
+	// behind are fetched from the checkpoint before live events carry on.
+	Ingester struct {
+		rpc     *chain.CometPool
+		store   store.Store
+		query   string
+		handler EventHandler
+		flush   func()
+		height  int64
+		current int64
+		handled map[string]struct{}
+		resync  bool
+		logger  zerolog.Logger
+	}
+)
+
+func NewIngester(rpc *chain.CometPool, store store.Store, query string, handler EventHandler, flush func(), logger zerolog.Logger) *Ingester {
+	return &Ingester{
+		rpc:     rpc,
+		store:   store,
+		query:   query,
+		handler: handler,
+		flush:   flush,
+		handled: map[string]struct{}{},
+		logger:  logger,
+	}
+}
+
+// Start subscribes to live events, then waits for ready before backfilling from
+// the stored checkpoint and handling the live events received in the meantime.
+func (i *Ingester) Start(ready <-chan struct{}) error {
+	channel, err := i.rpc.Subscribe(i.query)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ready
+		i.BackfillFromCheckpoint()
+		i.Run(channel)
+	}()
+	return nil
+}
+
+// BackfillFromCheckpoint fetches the blocks from the stored checkpoint up to
+// the chain height, retrying until it succeeds.
+func (i *Ingester) BackfillFromCheckpoint() {
+	var checkpoint int64
+	retry(i.logger, "failed to load checkpoint", func() (err error) {
+		checkpoint, err = i.store.Checkpoint()
+		return err
+	})
+	if checkpoint == 0 {
+		i.logger.Info().Msg("no checkpoint found, skipping backfill")
+		return
+	}
+	i.height = checkpoint
+	var height int64
+	retry(i.logger, "failed to get chain height", func() (err error) {
+		height, err = i.rpc.Height()
+		return err
+	})
+	i.Backfill(checkpoint+1, height)
+}
+
+// Backfill handles and commits the blocks from start to end, retrying each
+// block until it can be fetched. Transactions of a block already partly
+// handled from the subscription are not handled again. Handled events are
+// recorded as if received on the subscription.
+func (i *Ingester) Backfill(start int64, end int64) {
+	if start > end {
+		return
+	}
+	i.logger.Info().Int64("start", start).Int64("end", end).Msg("backfilling blocks")
+	for height := start; height <= end; height++ {
+		var events []coretypes.ResultEvent
+		retry(i.logger.With().Int64("height", height).Logger(), "failed to get block events", func() (err error) {
+			events, err = i.rpc.TxEvents(height)
+			return err
+		})
+		for j := range events {
+			event := &events[j]
+			if height == i.current {
+				_, ok := i.handled[chain.EventTxHash(event)]
+				if ok {
+					continue
+				}
+			}
+			event.Query = i.query
+			i.rpc.Record(event)
+			i.handler(event)
+		}
+		i.Commit(height)
+	}
+	i.current = 0
+	i.logger.Info().Int64("start", start).Int64("end", end).Msg("backfill complete")
+}
+
+func (i *Ingester) Run(channel <-chan chain.SubscriptionEvent) {
+	for received := range channel {
+		if received.Missed {
+			i.logger.Warn().Int64("checkpoint", i.height).Msg("missed events, backfilling from checkpoint")
+			i.resync = true
+			continue
+		}
+		event := received.Event
+		height, err := chain.EventHeight(&event)
+		if err != nil {
+			i.logger.Error().Err(err).Msg("failed to get event height")
+			continue
+		}
+		if height <= i.height {
+			continue
+		}
+		if i.height == 0 {
+			// without a checkpoint there is nothing to catch up on
+			i.height = height - 1
+			i.resync = false
+		}
+		if i.resync {
+			// the backfill includes this block, so the rest of its events are skipped
+			i.resync = false
+			i.Backfill(i.height+1, height)
+			continue
+		}
+		// events arrive in block order, so a new height means the previous block is done
+		if height != i.current {
+			if i.current > 0 {
+				i.Commit(i.current)
+			}
+			i.current = height
+			i.handled = map[string]struct{}{}
+		}
+		i.handled[chain.EventTxHash(&event)] = struct{}{}
+		i.handler(&event)
+	}
+}
+
+// Commit waits for the trades of the block to be saved before checkpointing it,
+// so that a restart never skips trades that were still on their way.
+func (i *Ingester) Commit(height int64) {
+	i.flush()
+	i.height = height
+	err := i.store.SaveCheckpoint(height)
+	if err != nil {
+		i.logger.Error().Err(err).Int64("height", height).Msg("failed to save checkpoint")
+	}
+}
+
+func retry(logger zerolog.Logger, msg string, fn func() error) {
+	for {
+		err := fn()
+		if err == nil {
+			return
+		}
+		logger.Error().Err(err).Dur("retry_in", IngestRetryInterval).Msg(msg)
+		time.Sleep(IngestRetryInterval)
+	}
+}
@@ -0,0 +1,113 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"indexer/config"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/rs/zerolog"
+)
+
+// slowStore takes a while to save trades, as a remote store would.
+type slowStore struct {
+	store.Store
+}
+
+func (s slowStore) SaveTrade(trade *trading.Trade) error {
+	time.Sleep(20 * time.Millisecond)
+	return s.Store.SaveTrade(trade)
+}
+
+// testChainExchange is a chain exchange without a chain, fed by calling its
+// event handler.
+type testChainExchange struct {
+	*ChainExchange
+}
+
+func (e testChainExchange) DisplayName() string {
+	return e.name
+}
+
+func (e testChainExchange) Start() error {
+	return nil
+}
+
+func TestIngesterCommitsAfterTradesAreSaved(t *testing.T) {
+	filter := config.Cfg.Filter
+	t.Cleanup(func() { config.Cfg.Filter = filter })
+	config.Cfg.Filter = config.FilterConfig{
+		Mode:         FilterModeExclude,
+		Window:       time.Hour,
+		MinTrades:    2,
+		MaxDeviation: 0.25,
+	}
+	stores, err := store.NewMemoryManager(&store.MemoryConfig{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory, err := stores.Store("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := slowStore{memory}
+	// the third trade is excluded by the filter, so it is never saved
+	trades := []trading.Trade{}
+	for i, price := range []int64{10, 10, 20, 10} {
+		trade := trading.Trade{
+			Base:  token.Token{Symbol: "ATOM"},
+			Quote: token.Token{Symbol: "USDC"},
+			Time:  filterStart.Add(time.Duration(i) * time.Second),
+		}
+		trade.Base.Amount.SetMantScale(1, 0)
+		trade.Quote.Amount.SetMantScale(price, 0)
+		trades = append(trades, trade)
+	}
+	c := &ChainExchange{
+		name:  "test",
+		store: db,
+		parse: func(*coretypes.ResultEvent) []trading.Trade {
+			return trades
+		},
+		logger: zerolog.Nop(),
+	}
+	manager, err := NewExchangeManager(map[string]Exchange{"test": testChainExchange{c}}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Start()
+	ingester := NewIngester(nil, db, "", c.HandleEvent, c.Flush, zerolog.Nop())
+	committed := make(chan struct{})
+	go func() {
+		defer close(committed)
+		ingester.handler(&coretypes.ResultEvent{})
+		ingester.Commit(5)
+	}()
+	select {
+	case <-committed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("commit did not return")
+	}
+	checkpoint, err := db.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint != 5 {
+		t.Errorf("got checkpoint %d, want 5", checkpoint)
+	}
+	saved, err := db.QueryTrades(&store.TradesQuery{
+		Pair:  &token.Pair{Base: "ATOM", Quote: "USDC"},
+		Start: filterStart,
+		End:   filterStart.Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 3 {
+		t.Errorf("got %d trades saved by the checkpoint, want 3", len(saved))
+	}
+}
@@ -0,0 +1,157 @@
+package exchange
+
+import (
+	"fmt"
+	"sync"
+
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/rs/zerolog"
+)
+
+func init() {
+	Register("mock", NewMockExchangeFromConfig)
+}
+
+type (
+	MockConfig struct {
+		DisplayName string   `toml:"display_name"`
+		Pairs       []string `toml:"pairs"`
+	}
+
+	// MockExchange emits whatever trades and pairs are pushed into it, for
+	// replaying captured data and driving the rest of the pipeline in tests.
+	MockExchange struct {
+		mu                 sync.RWMutex
+		name               string
+		displayName        string
+		pairs              []*token.Pair
+		store              store.Store
+		tradeSubscriptions []chan *trading.Trade
+		pairSubscriptions  []chan []*token.Pair
+		logger             zerolog.Logger
+	}
+)
+
+func NewMockExchangeFromConfig(name string, cfg *MockConfig, store store.Store, logger zerolog.Logger) (Exchange, error) {
+	m := NewMockExchange(name, store, logger)
+	if cfg.DisplayName != "" {
+		m.displayName = cfg.DisplayName
+	}
+	for _, pairString := range cfg.Pairs {
+		pair, err := token.PairFromString(pairString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pair '%s': %v", pairString, err)
+		}
+		m.pairs = append(m.pairs, pair)
+	}
+	return m, nil
+}
+
+func NewMockExchange(name string, store store.Store, logger zerolog.Logger) *MockExchange {
+	return &MockExchange{
+		name:        name,
+		displayName: name,
+		pairs:       []*token.Pair{},
+		store:       store,
+		logger:      logger,
+	}
+}
+
+func (m *MockExchange) Name() string {
+	return m.name
+}
+
+func (m *MockExchange) DisplayName() string {
+	return m.displayName
+}
+
+func (m *MockExchange) Start() error {
+	m.mu.RLock()
+	pairs := m.pairs
+	m.mu.RUnlock()
+	if len(pairs) > 0 {
+		m.publishPairs(pairs)
+	}
+	return nil
+}
+
+func (m *MockExchange) Pairs() ([]*token.Pair, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pairs, nil
+}
+
+func (m *MockExchange) Assets() []*token.Asset {
+	return []*token.Asset{}
+}
+
+func (m *MockExchange) Store() store.Store {
+	return m.store
+}
+
+func (m *MockExchange) SubscribeTrades() chan *trading.Trade {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	channel := make(chan *trading.Trade)
+	m.tradeSubscriptions = append(m.tradeSubscriptions, channel)
+	return channel
+}
+
+func (m *MockExchange) SubscribePairs() chan []*token.Pair {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	channel := make(chan []*token.Pair)
+	m.pairSubscriptions = append(m.pairSubscriptions, channel)
+	return channel
+}
+
+// AddPairs publishes any of the given pairs not already known, in either
+// orientation. It blocks until every subscriber has received the update.
+func (m *MockExchange) AddPairs(pairs ...*token.Pair) {
+	m.mu.Lock()
+	known := make(map[string]struct{}, len(m.pairs))
+	for _, pair := range m.pairs {
+		known[pair.String()] = struct{}{}
+		known[pair.Reversed().String()] = struct{}{}
+	}
+	added := false
+	for _, pair := range pairs {
+		_, ok := known[pair.String()]
+		if ok {
+			continue
+		}
+		known[pair.String()] = struct{}{}
+		known[pair.Reversed().String()] = struct{}{}
+		m.pairs = append(m.pairs, pair)
+		added = true
+	}
+	updated := m.pairs
+	m.mu.Unlock()
+	if added {
+		m.publishPairs(updated)
+	}
+}
+
+// PushTrade sends a trade to every subscriber, blocking until each has
+// received it.
+func (m *MockExchange) PushTrade(trade *trading.Trade) {
+	m.mu.RLock()
+	subscriptions := m.tradeSubscriptions
+	m.mu.RUnlock()
+	m.logger.Debug().Str("base", trade.Base.String()).Str("quote", trade.Quote.String()).Msg("trade")
+	for _, subscription := range subscriptions {
+		subscription <- trade
+	}
+}
+
+func (m *MockExchange) publishPairs(pairs []*token.Pair) {
+	m.mu.RLock()
+	subscriptions := m.pairSubscriptions
+	m.mu.RUnlock()
+	for _, subscription := range subscriptions {
+		subscription <- pairs
+	}
+}
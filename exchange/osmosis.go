@@ -1,216 +1,290 @@
 package exchange
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"indexer/chain"
-	"indexer/config"
 	"indexer/store"
 	"indexer/token"
 	"indexer/trading"
 
 	coretypes "github.com/cometbft/cometbft/rpc/core/types"
-	"github.com/osmosis-labs/assetlist"
+	"github.com/cometbft/cometbft/types"
 	"github.com/rs/zerolog"
 )
 
+const (
+	OsmosisPoolTypeGamm         = "gamm"
+	OsmosisPoolTypeConcentrated = "concentratedliquidity"
+	OsmosisPoolTypeCosmwasm     = "cosmwasmpool"
+)
+
+var OsmosisPoolTypes = map[string]struct{}{
+	OsmosisPoolTypeGamm:         {},
+	OsmosisPoolTypeConcentrated: {},
+	OsmosisPoolTypeCosmwasm:     {},
+}
+
+// OsmosisSymbolOverrides renames bridged assets whose symbols collide with the
+// native versions.
+var OsmosisSymbolOverrides = map[string]string{
+	"ibc/D189335C6E4A68B513C10AB227BF1C1D38C746766278BA3EEB4FB14124F1D858": "USDC.axl",
+	"ibc/8242AD24008032E457D2E12D46588FD39FB54FB29680C6C7663D296B383C37C4": "USDT.axl",
+}
+
+func init() {
+	Register("osmosis", NewOsmosisExchangeFromConfig)
+}
+
 type (
+	OsmosisConfig struct {
+		EmitRoutedTrades bool `toml:"emit_routed_trades"`
+		// KeywordPools discovers pools from "SYMBOL:pool_id" asset list
+		// keywords instead of querying the chain.
+		KeywordPools bool               `toml:"keyword_pools"`
+		MinLiquidity map[string]float64 `toml:"min_liquidity"`
+		QuoteSymbols []string           `toml:"quote_symbols"`
+	}
+
 	OsmosisExchange struct {
-		rpc                *chain.CometRpc
-		assets             map[string]*assetlist.Asset
-		assetsSymbol       map[string]*assetlist.Asset
-		pairs              []*token.Pair
-		store              store.Store
-		tradeSubscriptions []chan *trading.Trade
-		pairSubscriptions  []chan []*token.Pair
-		logger             zerolog.Logger
+		*ChainExchange
+		cfg *OsmosisConfig
+		// pools is replaced as a whole on every refresh, so lookups never
+		// block and never see a partial map.
+		pools atomic.Pointer[map[string]*OsmosisPool]
 	}
 
 	OsmosisTokenSwap struct {
-		In   token.Token
-		Out  token.Token
-		Pool string
+		In       token.Token
+		Out      token.Token
+		Pool     string
+		PoolType string
+	}
+
+	OsmosisSwapRoute struct {
+		Message int
+		Swaps   []OsmosisTokenSwap
 	}
 )
 
-func NewOsmosisExchange(url string, store store.Store, logger zerolog.Logger) (*OsmosisExchange, error) {
-	rpc, err := chain.NewCometRpc(url, logger)
+func NewOsmosisExchangeFromConfig(name string, cfg *OsmosisConfig, store store.Store, logger zerolog.Logger) (Exchange, error) {
+	rpc, err := ChainPool(name, logger)
 	if err != nil {
 		return nil, err
 	}
-	o := &OsmosisExchange{
-		rpc:    rpc,
-		store:  store,
-		logger: logger,
-	}
-	o.logger.Info().Msg("exchange connected")
-	err = o.PollAssetList()
-	return o, err
+	return NewOsmosisExchange(name, cfg, rpc, store, logger)
 }
 
-func (o *OsmosisExchange) Name() string {
-	return "osmosis"
+func NewOsmosisExchange(name string, cfg *OsmosisConfig, rpc *chain.CometPool, store store.Store, logger zerolog.Logger) (*OsmosisExchange, error) {
+	o := &OsmosisExchange{
+		cfg: cfg,
+	}
+	o.pools.Store(&map[string]*OsmosisPool{})
+	assets := NewAssetRegistry(name, OsmosisSymbolOverrides, logger)
+	o.ChainExchange = NewChainExchange(name, rpc, assets, store, "tm.event='Tx' AND token_swapped.pool_id EXISTS", o.GetTrades, logger)
+	o.Poll(o.RefreshPools)
+	return o, nil
 }
 
 func (o *OsmosisExchange) DisplayName() string {
 	return "Osmosis"
 }
 
-func (o *OsmosisExchange) Start() error {
-	channel, err := o.rpc.Subscribe("tm.event='Tx' AND token_swapped.module='gamm'")
-	if err != nil {
-		return err
-	}
-	o.logger.Info().Msg("subscribed to swap events")
-	go func() {
-		for {
-			event := <-channel
-			trades := o.GetTrades(&event)
-			for _, trade := range trades {
-				o.logger.Debug().Str("base", trade.Base.String()).Str("quote", trade.Quote.String()).Msg("trade")
-				for _, subscription := range o.tradeSubscriptions {
-					subscription <- &trade
-				}
-			}
-		}
-	}()
-	return nil
-}
-
-func (o *OsmosisExchange) SubscribeTrades() chan *trading.Trade {
-	channel := make(chan *trading.Trade)
-	o.tradeSubscriptions = append(o.tradeSubscriptions, channel)
-	return channel
-}
-
-func (o *OsmosisExchange) SubscribePairs() chan []*token.Pair {
-	channel := make(chan []*token.Pair)
-	o.pairSubscriptions = append(o.pairSubscriptions, channel)
-	return channel
-}
-
-func (o *OsmosisExchange) Pairs() ([]*token.Pair, error) {
-	return o.pairs, nil
-}
-
-func (o *OsmosisExchange) Store() store.Store {
-	return o.store
-}
-
 func (o *OsmosisExchange) GetTrades(event *coretypes.ResultEvent) []trading.Trade {
 	trades := []trading.Trade{}
-	swaps, err := ParseOsmosisTokenSwaps(event)
+	routes, err := ParseOsmosisSwapRoutes(event)
 	if err != nil {
 		o.logger.Error().Err(err).Msg("failed to parse swap event")
 		return trades
 	}
-	if len(o.assets) == 0 {
+	if len(routes) == 0 {
+		return trades
+	}
+	if o.assets.Len() == 0 {
 		o.logger.Warn().Msg("cannot process trades when asset list is empty")
 		return trades
 	}
-	now := time.Now().UTC()
-	for _, swap := range swaps {
-		inAsset, ok := o.assets[swap.In.Symbol]
-		if !ok {
-			o.logger.Debug().Str("symbol", swap.In.Symbol).Msg("skipping unlisted asset swap")
+	height, err := chain.EventHeight(event)
+	if err != nil {
+		o.logger.Error().Err(err).Msg("failed to get swap event height")
+		return trades
+	}
+	txHash := chain.EventTxHash(event)
+	blockTime, err := o.rpc.BlockTime(height)
+	if err != nil {
+		o.logger.Warn().Err(err).Int64("height", height).Msg("falling back to receive time for trades")
+		blockTime = time.Now().UTC()
+	}
+	for _, route := range routes {
+		for _, swap := range route.Swaps {
+			base, quote, ok := o.RebaseSwap(&swap.In, &swap.Out)
+			if !ok {
+				continue
+			}
+			_, ok = o.Pools()[swap.Pool]
+			if !ok {
+				continue
+			}
+			trades = append(trades, trading.Trade{
+				Base:     *base,
+				Quote:    *quote,
+				Time:     blockTime,
+				Height:   height,
+				TxHash:   txHash,
+				Pool:     swap.Pool,
+				PoolType: swap.PoolType,
+			})
+		}
+		if !o.cfg.EmitRoutedTrades || len(route.Swaps) < 2 {
 			continue
 		}
-		outAsset, ok := o.assets[swap.Out.Symbol]
-		if !ok {
-			o.logger.Debug().Str("symbol", swap.Out.Symbol).Msg("skipping unlisted asset swap")
+		first := route.Swaps[0]
+		last := route.Swaps[len(route.Swaps)-1]
+		if first.In.Symbol == last.Out.Symbol {
+			// cyclic arbitrage routes have no meaningful end-to-end price
 			continue
 		}
-		base, err := RebaseOsmosisAsset(&swap.In, inAsset)
-		if err != nil {
-			o.logger.Debug().Err(err).Str("symbol", swap.In.Symbol).Msg("failed to rebase in token")
+		base, quote, ok := o.RebaseSwap(&first.In, &last.Out)
+		if !ok || !o.HasPair(base.Symbol, quote.Symbol) {
 			continue
 		}
-		quote, err := RebaseOsmosisAsset(&swap.Out, outAsset)
+		trades = append(trades, trading.Trade{
+			Base:   *base,
+			Quote:  *quote,
+			Time:   blockTime,
+			Height: height,
+			TxHash: txHash,
+			Routed: true,
+		})
+	}
+	return trades
+}
+
+// Pools returns the pools trades are taken from, keyed by id. The map must not
+// be modified.
+func (o *OsmosisExchange) Pools() map[string]*OsmosisPool {
+	return *o.pools.Load()
+}
+
+func (o *OsmosisExchange) RebaseSwap(in *token.Token, out *token.Token) (*token.Token, *token.Token, bool) {
+	base, err := o.assets.Rebase(in)
+	if err != nil {
+		o.logger.Debug().Err(err).Msg("skipping unlisted asset swap")
+		return nil, nil, false
+	}
+	quote, err := o.assets.Rebase(out)
+	if err != nil {
+		o.logger.Debug().Err(err).Msg("skipping unlisted asset swap")
+		return nil, nil, false
+	}
+	return base, quote, true
+}
+
+func (o *OsmosisExchange) HasPair(base string, quote string) bool {
+	pairs, _ := o.Pairs()
+	for _, pair := range pairs {
+		if (pair.Base == base && pair.Quote == quote) || (pair.Base == quote && pair.Quote == base) {
+			return true
+		}
+	}
+	return false
+}
+
+// RefreshPools updates the pools of the exchange and returns their pairs.
+func (o *OsmosisExchange) RefreshPools() ([]*token.Pair, error) {
+	var pairs []*token.Pair
+	var pools map[string]*OsmosisPool
+	if o.cfg.KeywordPools {
+		pairs, pools = o.KeywordPairs()
+	} else {
+		var err error
+		pairs, pools, err = o.DiscoverPairs()
 		if err != nil {
-			o.logger.Debug().Err(err).Str("symbol", swap.Out.Symbol).Msg("failed to rebase out token")
-			continue
+			return nil, fmt.Errorf("failed to discover pools: %v", err)
 		}
-		supportedPools := o.GetSupportedPools(inAsset, outAsset)
-		_, ok = supportedPools[swap.Pool]
+	}
+	o.pools.Store(&pools)
+	o.logger.Debug().Int("num_pools", len(pools)).Msg("refreshed pools")
+	return pairs, nil
+}
+
+// DiscoverPairs queries the chain for pools and returns the pairs of every pool
+// that passes the liquidity filter, with the pools keyed by id.
+func (o *OsmosisExchange) DiscoverPairs() ([]*token.Pair, map[string]*OsmosisPool, error) {
+	listed := func(denom string) bool {
+		_, ok := o.assets.Asset(denom)
+		return ok
+	}
+	all, err := QueryOsmosisPools(o.rpc, listed, o.logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	pairs := []*token.Pair{}
+	pools := map[string]*OsmosisPool{}
+	seen := map[string]struct{}{}
+	for _, pool := range all {
+		poolPairs, ok := o.PoolPairs(pool)
 		if !ok {
 			continue
 		}
-		trades = append(trades, trading.Trade{Base: *base, Quote: *quote, Time: now})
+		pools[pool.Id] = pool
+		for _, pair := range poolPairs {
+			_, ok := seen[pair.String()]
+			if ok {
+				continue
+			}
+			seen[pair.String()] = struct{}{}
+			seen[pair.Reversed().String()] = struct{}{}
+			pairs = append(pairs, pair)
+		}
 	}
-	return trades
+	return pairs, pools, nil
 }
 
-func (o *OsmosisExchange) PollAssetList() error {
-	go func() {
-		for {
-			cfg := config.Cfg.ExchangeConfig["osmosis"]
-			assetList, err := LoadOsmosisAssetList(cfg.AssetsUrl)
-			if err != nil {
-				o.logger.Error().Err(err).Str("url", cfg.AssetsUrl).Msg("failed to load asset list")
-				time.Sleep(cfg.AssetsRetryInterval)
+// KeywordPairs builds pairs from the pools annotated in the asset list keywords.
+func (o *OsmosisExchange) KeywordPairs() ([]*token.Pair, map[string]*OsmosisPool) {
+	pairs := []*token.Pair{}
+	pools := map[string]*OsmosisPool{}
+	for _, asset := range o.assets.Assets() {
+		if asset.Symbol == "OSMO" {
+			continue
+		}
+		supportedPools := o.GetSupportedPools(asset)
+		for id, quoteSymbol := range supportedPools {
+			_, ok := pools[id]
+			if ok {
+				o.logger.Debug().Str("base", asset.Symbol).Str("quote", quoteSymbol).Str("id", id).Msg("skipping already present pool")
 				continue
 			}
-			assets := make(map[string]*assetlist.Asset, len(assetList.Assets))
-			assetsSymbol := make(map[string]*assetlist.Asset, len(assetList.Assets))
-			pairs := []*token.Pair{}
-			pools := map[string]struct{}{}
-			for i, asset := range assetList.Assets {
-				assets[asset.Base] = &assetList.Assets[i]
-				if asset.Base == "ibc/D189335C6E4A68B513C10AB227BF1C1D38C746766278BA3EEB4FB14124F1D858" {
-					assetList.Assets[i].Symbol = "USDC.axl"
-					assetsSymbol["USDC.axl"] = &assetList.Assets[i]
-				} else if asset.Base == "ibc/8242AD24008032E457D2E12D46588FD39FB54FB29680C6C7663D296B383C37C4" {
-					assetList.Assets[i].Symbol = "USDT.axl"
-					assetsSymbol["USDT.axl"] = &assetList.Assets[i]
-				} else {
-					assetsSymbol[asset.Symbol] = &assetList.Assets[i]
-				}
+			quoteAsset, ok := o.assets.AssetBySymbol(quoteSymbol)
+			if !ok {
+				o.logger.Debug().Str("symbol", quoteSymbol).Msg("skipping unlisted asset pair")
+				continue
 			}
-			o.assets = assets
-			o.assetsSymbol = assetsSymbol
-			for _, asset := range o.assets {
-				if asset.Symbol == "OSMO" {
-					continue
-				}
-				supportedPools := o.GetSupportedPools(asset)
-				for id, quoteSymbol := range supportedPools {
-					_, ok := pools[id]
-					if ok {
-						o.logger.Debug().Str("base", asset.Symbol).Str("quote", quoteSymbol).Str("id", id).Msg("skipping already present pool")
-						continue
-					}
-					quoteAsset, ok := o.assetsSymbol[quoteSymbol]
-					if !ok {
-						o.logger.Debug().Str("symbol", quoteSymbol).Msg("skipping unlisted asset pair")
-						continue
-					}
-					pair := &token.Pair{
-						Base:  asset.Symbol,
-						Quote: quoteAsset.Symbol,
-					}
-					pairs = append(pairs, pair)
-					pools[id] = struct{}{}
-				}
+			pair := &token.Pair{
+				Base:  asset.Symbol,
+				Quote: quoteAsset.Symbol,
 			}
-			for _, subscription := range o.pairSubscriptions {
-				subscription <- pairs
+			pairs = append(pairs, pair)
+			pools[id] = &OsmosisPool{
+				Id:     id,
+				Denoms: []string{asset.Denom, quoteAsset.Denom},
 			}
-			o.pairs = pairs
-			o.logger.Debug().Int("num_assets", len(o.assets)).Msg("refreshed asset list")
-			time.Sleep(cfg.AssetsRefreshInterval)
 		}
-	}()
-	return nil
+	}
+	return pairs, pools
 }
 
-func (o *OsmosisExchange) GetSupportedPools(assets ...*assetlist.Asset) map[string]string {
+func (o *OsmosisExchange) GetSupportedPools(assets ...*token.Asset) map[string]string {
 	supportedPools := map[string]string{}
 	for _, asset := range assets {
+		if asset == nil {
+			continue
+		}
 		for _, keyword := range asset.Keywords {
 			fields := strings.Split(keyword, ":")
 			if len(fields) != 2 {
@@ -226,72 +300,161 @@ func (o *OsmosisExchange) GetSupportedPools(assets ...*assetlist.Asset) map[stri
 	return supportedPools
 }
 
-func RebaseOsmosisAsset(t *token.Token, asset *assetlist.Asset) (*token.Token, error) {
-	exponents := make(map[string]int64, len(asset.DenomUnits))
-	for _, denomUnit := range asset.DenomUnits {
-		exponents[denomUnit.Denom] = denomUnit.Exponent
+func ParseOsmosisTokenSwaps(event *coretypes.ResultEvent) ([]OsmosisTokenSwap, error) {
+	routes, err := ParseOsmosisSwapRoutes(event)
+	if err != nil {
+		return nil, err
 	}
-	displayExponent, ok := exponents[asset.Display]
-	if !ok {
-		return &token.Token{}, fmt.Errorf("could not determine display units for %s", t.Symbol)
+	swaps := []OsmosisTokenSwap{}
+	for _, route := range routes {
+		swaps = append(swaps, route.Swaps...)
 	}
-	exponent := t.Amount.Scale() + int(displayExponent)
-	return t.Rebase(exponent, asset.Symbol), nil
+	return swaps, nil
 }
 
-func ParseOsmosisTokenSwaps(event *coretypes.ResultEvent) ([]OsmosisTokenSwap, error) {
-	tokenSwapModule, ok := event.Events["token_swapped.module"]
+// ParseOsmosisSwapRoutes groups the token_swapped events of a tx by message, then
+// splits each message's swaps into routes of consecutive hops where every hop
+// spends the previous hop's output.
+func ParseOsmosisSwapRoutes(event *coretypes.ResultEvent) ([]OsmosisSwapRoute, error) {
+	messages, err := osmosisSwapEventsByMessage(event)
+	if err != nil {
+		return nil, err
+	}
+	routes := []OsmosisSwapRoute{}
+	for _, message := range messages {
+		var route *OsmosisSwapRoute
+		for _, attributes := range message.events {
+			swap, ok, err := ParseOsmosisTokenSwap(attributes)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			if route != nil {
+				previous := route.Swaps[len(route.Swaps)-1]
+				if previous.Out.Symbol == swap.In.Symbol {
+					route.Swaps = append(route.Swaps, *swap)
+					continue
+				}
+				routes = append(routes, *route)
+			}
+			route = &OsmosisSwapRoute{
+				Message: message.index,
+				Swaps:   []OsmosisTokenSwap{*swap},
+			}
+		}
+		if route != nil {
+			routes = append(routes, *route)
+		}
+	}
+	return routes, nil
+}
+
+func ParseOsmosisTokenSwap(attributes map[string]string) (*OsmosisTokenSwap, bool, error) {
+	module := attributes["module"]
+	_, ok := OsmosisPoolTypes[module]
 	if !ok {
-		return []OsmosisTokenSwap{}, nil
+		return nil, false, nil
 	}
-	tokenSwapPool, ok := event.Events["token_swapped.pool_id"]
+	pool, ok := attributes["pool_id"]
 	if !ok {
-		return nil, fmt.Errorf("swap event missing pool_id")
+		return nil, false, fmt.Errorf("swap event missing pool_id")
 	}
-	tokensIn, ok := event.Events["token_swapped.tokens_in"]
+	tokensIn, ok := attributes["tokens_in"]
 	if !ok {
-		return nil, fmt.Errorf("swap event missing tokens_in")
+		return nil, false, fmt.Errorf("swap event missing tokens_in")
 	}
-	tokensOut, ok := event.Events["token_swapped.tokens_out"]
+	tokensOut, ok := attributes["tokens_out"]
 	if !ok {
-		return nil, fmt.Errorf("swap event missing tokens_out")
+		return nil, false, fmt.Errorf("swap event missing tokens_out")
 	}
-	numSwaps := len(tokenSwapModule)
-	if len(tokenSwapPool) != numSwaps || len(tokensIn) != numSwaps || len(tokensOut) != numSwaps {
-		return nil, fmt.Errorf("swap event attributes length mismatch")
+	in, err := token.ParseToken(tokensIn)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse input token '%s': %v", tokensIn, err)
 	}
-	swaps := make([]OsmosisTokenSwap, len(tokensIn))
-	for i, module := range tokenSwapModule {
-		if module != "gamm" {
-			continue
+	out, err := token.ParseToken(tokensOut)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse output token '%s': %v", tokensOut, err)
+	}
+	swap := &OsmosisTokenSwap{
+		In:       *in,
+		Out:      *out,
+		Pool:     pool,
+		PoolType: module,
+	}
+	return swap, true, nil
+}
+
+type osmosisSwapMessage struct {
+	index  int
+	events []map[string]string
+}
+
+// osmosisSwapEventsByMessage uses the ordered tx result events when available,
+// where messages are delimited by msg_index attributes or by the message event
+// carrying the action. Flattened event maps cannot be split, so every swap is
+// treated as belonging to a single message.
+func osmosisSwapEventsByMessage(event *coretypes.ResultEvent) ([]osmosisSwapMessage, error) {
+	data, ok := event.Data.(types.EventDataTx)
+	if !ok || len(data.Result.Events) == 0 {
+		return osmosisFlatSwapEvents(event)
+	}
+	messages := []osmosisSwapMessage{}
+	index := -1
+	for _, e := range data.Result.Events {
+		attributes := make(map[string]string, len(e.Attributes))
+		for _, attribute := range e.Attributes {
+			attributes[attribute.Key] = attribute.Value
 		}
-		in, err := token.ParseToken(tokensIn[i])
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse input token '%s': %v", tokensIn[i], err)
+		msgIndex, hasMsgIndex := attributes["msg_index"]
+		if hasMsgIndex {
+			i, err := strconv.Atoi(msgIndex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid msg_index '%s': %v", msgIndex, err)
+			}
+			index = i
+		} else if _, hasAction := attributes["action"]; e.Type == "message" && hasAction {
+			index++
 		}
-		out, err := token.ParseToken(tokensOut[i])
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse output token '%s': %v", tokensOut[i], err)
+		if e.Type != "token_swapped" {
+			continue
 		}
-		swaps[i] = OsmosisTokenSwap{
-			In:   *in,
-			Out:  *out,
-			Pool: tokenSwapPool[i],
+		if index < 0 {
+			index = 0
 		}
+		if len(messages) == 0 || messages[len(messages)-1].index != index {
+			messages = append(messages, osmosisSwapMessage{index: index})
+		}
+		message := &messages[len(messages)-1]
+		message.events = append(message.events, attributes)
 	}
-	return swaps, nil
+	return messages, nil
 }
 
-func LoadOsmosisAssetList(url string) (*assetlist.Root, error) {
-	res, err := http.Get(url)
-	if err != nil {
-		return nil, err
+func osmosisFlatSwapEvents(event *coretypes.ResultEvent) ([]osmosisSwapMessage, error) {
+	tokenSwapModule, ok := event.Events["token_swapped.module"]
+	if !ok {
+		return []osmosisSwapMessage{}, nil
+	}
+	keys := []string{"pool_id", "tokens_in", "tokens_out"}
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		values[i], ok = event.Events["token_swapped."+key]
+		if !ok {
+			return nil, fmt.Errorf("swap event missing %s", key)
+		}
+		if len(values[i]) != len(tokenSwapModule) {
+			return nil, fmt.Errorf("swap event attributes length mismatch")
+		}
 	}
-	defer res.Body.Close()
-	root := &assetlist.Root{}
-	err = json.NewDecoder(res.Body).Decode(root)
-	if err == nil && len(root.Assets) == 0 {
-		err = fmt.Errorf("asset list is empty")
+	message := osmosisSwapMessage{events: make([]map[string]string, len(tokenSwapModule))}
+	for i, module := range tokenSwapModule {
+		attributes := map[string]string{"module": module}
+		for j, key := range keys {
+			attributes[key] = values[j][i]
+		}
+		message.events[i] = attributes
 	}
-	return root, err
+	return []osmosisSwapMessage{message}, nil
 }
@@ -0,0 +1,332 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"indexer/chain"
+	"indexer/token"
+
+	"github.com/ericlagergren/decimal"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	OsmosisAllPoolsPath           = "/osmosis.poolmanager.v1beta1.Query/AllPools"
+	OsmosisTotalPoolLiquidityPath = "/osmosis.poolmanager.v1beta1.Query/TotalPoolLiquidity"
+
+	osmosisBalancerPoolType     = "/osmosis.gamm.v1beta1.Pool"
+	osmosisStableswapPoolType   = "/osmosis.gamm.poolmodels.stableswap.v1beta1.Pool"
+	osmosisConcentratedPoolType = "/osmosis.concentratedliquidity.v1beta1.Pool"
+	osmosisCosmwasmPoolType     = "/osmosis.cosmwasmpool.v1beta1.CosmWasmPool"
+
+	// osmosisLiquidityQueries limits the liquidity queries run at once.
+	osmosisLiquidityQueries = 8
+)
+
+// OsmosisDefaultQuoteSymbols orders the symbols preferred as the quote of a
+// discovered pair, most preferred first.
+var OsmosisDefaultQuoteSymbols = []string{"USDC", "USDT", "USDC.axl", "USDT.axl", "OSMO"}
+
+type (
+	OsmosisPool struct {
+		Id        string
+		Type      string
+		Denoms    []string
+		Liquidity []token.Token
+	}
+
+	// osmosisCosmwasmInstantiateMsg holds the denoms of the cosmwasm pool
+	// contracts in use: transmuter v1, transmuter v3 and orderbook.
+	osmosisCosmwasmInstantiateMsg struct {
+		PoolAssetDenoms  []string `json:"pool_asset_denoms"`
+		PoolAssetConfigs []struct {
+			Denom string `json:"denom"`
+		} `json:"pool_asset_configs"`
+		BaseDenom  string `json:"base_denom"`
+		QuoteDenom string `json:"quote_denom"`
+	}
+)
+
+// QueryOsmosisPools lists every pool known to the poolmanager. Liquidity is read
+// from the pool itself where the pool type holds it, and queried separately for
+// the others, which is skipped if listed returns false for any of its denoms.
+// Pools whose denoms are unknown or whose liquidity query fails are left out.
+func QueryOsmosisPools(rpc *chain.CometPool, listed func(denom string) bool, logger zerolog.Logger) ([]*OsmosisPool, error) {
+	res, err := rpc.AbciQuery(OsmosisAllPoolsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := chain.ParseProtoFields(res)
+	if err != nil {
+		return nil, err
+	}
+	pools := []*OsmosisPool{}
+	queried := []*OsmosisPool{}
+	for _, field := range fields.All(1) {
+		packed, err := chain.ParseProtoFields(field.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := decodeOsmosisPool(string(packed.Bytes(1)), packed.Bytes(2))
+		if err != nil {
+			return nil, err
+		}
+		if pool == nil {
+			continue
+		}
+		if pool.Liquidity == nil {
+			if len(pool.Denoms) == 0 {
+				logger.Debug().Str("pool", pool.Id).Str("type", pool.Type).Msg("skipping pool with unknown denoms")
+				continue
+			}
+			skip := false
+			for _, denom := range pool.Denoms {
+				if !listed(denom) {
+					skip = true
+				}
+			}
+			if skip {
+				continue
+			}
+			queried = append(queried, pool)
+		}
+		pools = append(pools, pool)
+	}
+	queryOsmosisPoolsLiquidity(rpc, queried, logger)
+	discovered := []*OsmosisPool{}
+	for _, pool := range pools {
+		if pool.Liquidity == nil {
+			continue
+		}
+		pool.Denoms = pool.Denoms[:0]
+		for _, coin := range pool.Liquidity {
+			pool.Denoms = append(pool.Denoms, coin.Symbol)
+		}
+		discovered = append(discovered, pool)
+	}
+	return discovered, nil
+}
+
+// queryOsmosisPoolsLiquidity queries the liquidity of the pools concurrently.
+// Pools whose query fails are logged and left without liquidity.
+func queryOsmosisPoolsLiquidity(rpc *chain.CometPool, pools []*OsmosisPool, logger zerolog.Logger) {
+	var wg sync.WaitGroup
+	running := make(chan struct{}, osmosisLiquidityQueries)
+	for _, pool := range pools {
+		wg.Add(1)
+		running <- struct{}{}
+		go func(pool *OsmosisPool) {
+			defer wg.Done()
+			defer func() { <-running }()
+			liquidity, err := queryOsmosisPoolLiquidity(rpc, pool.Id)
+			if err != nil {
+				logger.Warn().Err(err).Str("pool", pool.Id).Msg("failed to query pool liquidity")
+				return
+			}
+			pool.Liquidity = liquidity
+		}(pool)
+	}
+	wg.Wait()
+}
+
+// decodeOsmosisPool reads the id, denoms and, for gamm pools, the liquidity of a
+// pool. Cosmwasm pool denoms come from the contract's instantiate message and
+// are left empty if it is not one of the known contracts. Unknown pool types
+// return nil.
+func decodeOsmosisPool(typeUrl string, b []byte) (*OsmosisPool, error) {
+	fields, err := chain.ParseProtoFields(b)
+	if err != nil {
+		return nil, err
+	}
+	pool := &OsmosisPool{}
+	switch typeUrl {
+	case osmosisBalancerPoolType:
+		pool.Id = strconv.FormatUint(fields.Varint(2), 10)
+		pool.Type = OsmosisPoolTypeGamm
+		pool.Liquidity = []token.Token{}
+		for _, field := range fields.All(6) {
+			poolAsset, err := chain.ParseProtoFields(field.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			coin, err := decodeCoin(poolAsset.Bytes(1))
+			if err != nil {
+				return nil, err
+			}
+			pool.Liquidity = append(pool.Liquidity, *coin)
+		}
+	case osmosisStableswapPoolType:
+		pool.Id = strconv.FormatUint(fields.Varint(2), 10)
+		pool.Type = OsmosisPoolTypeGamm
+		pool.Liquidity = []token.Token{}
+		for _, field := range fields.All(6) {
+			coin, err := decodeCoin(field.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			pool.Liquidity = append(pool.Liquidity, *coin)
+		}
+	case osmosisConcentratedPoolType:
+		pool.Id = strconv.FormatUint(fields.Varint(4), 10)
+		pool.Type = OsmosisPoolTypeConcentrated
+		pool.Denoms = []string{string(fields.Bytes(6)), string(fields.Bytes(7))}
+	case osmosisCosmwasmPoolType:
+		pool.Id = strconv.FormatUint(fields.Varint(2), 10)
+		pool.Type = OsmosisPoolTypeCosmwasm
+		pool.Denoms = decodeOsmosisCosmwasmDenoms(fields.Bytes(4))
+	default:
+		return nil, nil
+	}
+	return pool, nil
+}
+
+func decodeOsmosisCosmwasmDenoms(instantiateMsg []byte) []string {
+	msg := osmosisCosmwasmInstantiateMsg{}
+	err := json.Unmarshal(instantiateMsg, &msg)
+	if err != nil {
+		return nil
+	}
+	denoms := msg.PoolAssetDenoms
+	for _, config := range msg.PoolAssetConfigs {
+		denoms = append(denoms, config.Denom)
+	}
+	if msg.BaseDenom != "" && msg.QuoteDenom != "" {
+		denoms = append(denoms, msg.BaseDenom, msg.QuoteDenom)
+	}
+	return denoms
+}
+
+func queryOsmosisPoolLiquidity(rpc *chain.CometPool, id string) ([]token.Token, error) {
+	poolId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	req := protowire.AppendTag(nil, 1, protowire.VarintType)
+	req = protowire.AppendVarint(req, poolId)
+	res, err := rpc.AbciQuery(OsmosisTotalPoolLiquidityPath, req)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := chain.ParseProtoFields(res)
+	if err != nil {
+		return nil, err
+	}
+	liquidity := []token.Token{}
+	for _, field := range fields.All(1) {
+		coin, err := decodeCoin(field.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		liquidity = append(liquidity, *coin)
+	}
+	return liquidity, nil
+}
+
+func decodeCoin(b []byte) (*token.Token, error) {
+	fields, err := chain.ParseProtoFields(b)
+	if err != nil {
+		return nil, err
+	}
+	coin := &token.Token{Symbol: string(fields.Bytes(1))}
+	amount := string(fields.Bytes(2))
+	_, ok := coin.Amount.SetString(amount)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount '%s' for %s", amount, coin.Symbol)
+	}
+	return coin, nil
+}
+
+// PoolPairs returns the pairs tradable in a pool if it passes the configured
+// liquidity minimums. With minimums configured, at least one of the pool's
+// assets must have a minimum and meet it, so pools holding only assets of
+// unknown value are skipped. Empty pools are always skipped.
+func (o *OsmosisExchange) PoolPairs(pool *OsmosisPool) ([]*token.Pair, bool) {
+	if len(pool.Liquidity) < 2 {
+		return nil, false
+	}
+	symbols := []string{}
+	liquid := len(o.cfg.MinLiquidity) == 0
+	for _, coin := range pool.Liquidity {
+		if coin.Amount.Sign() == 0 {
+			return nil, false
+		}
+		asset, ok := o.assets.Asset(coin.Symbol)
+		if !ok {
+			continue
+		}
+		symbols = append(symbols, asset.Symbol)
+		min, ok := o.cfg.MinLiquidity[asset.Symbol]
+		if !ok {
+			continue
+		}
+		amount := asset.Rebase(&coin).Amount
+		if amount.Cmp(new(decimal.Big).SetFloat64(min)) >= 0 {
+			liquid = true
+		}
+	}
+	if !liquid || len(symbols) < 2 {
+		return nil, false
+	}
+	pairs := []*token.Pair{}
+	for i := 0; i < len(symbols); i++ {
+		for j := i + 1; j < len(symbols); j++ {
+			pairs = append(pairs, o.orientPair(symbols[i], symbols[j]))
+		}
+	}
+	return pairs, true
+}
+
+// orientPair quotes a pair in whichever symbol comes first in the quote symbol
+// list, keeping the pool order if neither is listed.
+func (o *OsmosisExchange) orientPair(a string, b string) *token.Pair {
+	quotes := o.cfg.QuoteSymbols
+	if len(quotes) == 0 {
+		quotes = OsmosisDefaultQuoteSymbols
+	}
+	for _, quote := range quotes {
+		if quote == a {
+			return &token.Pair{Base: b, Quote: a}
+		}
+		if quote == b {
+			return &token.Pair{Base: a, Quote: b}
+		}
+	}
+	return &token.Pair{Base: a, Quote: b}
+}
+
+// Liquidity sums the quote asset reserves of the discovered pools holding both
+// assets of the pair. Pools from asset list keywords have no liquidity data.
+func (o *OsmosisExchange) Liquidity(pair *token.Pair) (*decimal.Big, bool) {
+	base, ok := o.assets.AssetBySymbol(pair.Base)
+	if !ok {
+		return nil, false
+	}
+	quote, ok := o.assets.AssetBySymbol(pair.Quote)
+	if !ok {
+		return nil, false
+	}
+	total := &decimal.Big{}
+	found := false
+	for _, pool := range o.Pools() {
+		var reserve *token.Token
+		hasBase := false
+		for i := range pool.Liquidity {
+			switch pool.Liquidity[i].Symbol {
+			case base.Denom:
+				hasBase = true
+			case quote.Denom:
+				reserve = &pool.Liquidity[i]
+			}
+		}
+		if !hasBase || reserve == nil {
+			continue
+		}
+		total.Add(total, &quote.Rebase(reserve).Amount)
+		found = true
+	}
+	return total, found
+}
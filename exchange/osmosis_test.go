@@ -0,0 +1,137 @@
+package exchange
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	cmtjson "github.com/cometbft/cometbft/libs/json"
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	osmoDenom    = "uosmo"
+	usdcDenom    = "ibc/498A0751C798A0D9A389AA3691123DADA57DAA4FE165D5C75894505B876BA6E4"
+	usdcAxlDenom = "ibc/D189335C6E4A68B513C10AB227BF1C1D38C746766278BA3EEB4FB14124F1D858"
+)
+
+// loadEvent reads a tx event as received from a CometBFT subscription.
+func loadEvent(t *testing.T, name string) *coretypes.ResultEvent {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &coretypes.ResultEvent{}
+	err = cmtjson.Unmarshal(data, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestParseOsmosisTokenSwaps(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		poolType string
+		pool     string
+		in       string
+		out      string
+	}{
+		{
+			fixture:  "osmosis_concentrated_swap.json",
+			poolType: OsmosisPoolTypeConcentrated,
+			pool:     "1464",
+			in:       "25000000" + osmoDenom,
+			out:      "13781240" + usdcDenom,
+		},
+		{
+			fixture:  "osmosis_cosmwasm_swap.json",
+			poolType: OsmosisPoolTypeCosmwasm,
+			pool:     "1212",
+			in:       "500000000" + usdcAxlDenom,
+			out:      "499750000" + usdcDenom,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.poolType, func(t *testing.T) {
+			event := loadEvent(t, test.fixture)
+			flat := *event
+			flat.Data = nil
+			for name, event := range map[string]*coretypes.ResultEvent{"ordered": event, "flat": &flat} {
+				swaps, err := ParseOsmosisTokenSwaps(event)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if len(swaps) != 1 {
+					t.Fatalf("%s: got %d swaps, want 1", name, len(swaps))
+				}
+				swap := swaps[0]
+				if swap.PoolType != test.poolType {
+					t.Errorf("%s: got pool type %s, want %s", name, swap.PoolType, test.poolType)
+				}
+				if swap.Pool != test.pool {
+					t.Errorf("%s: got pool %s, want %s", name, swap.Pool, test.pool)
+				}
+				if swap.In.String() != test.in {
+					t.Errorf("%s: got tokens in %s, want %s", name, swap.In.String(), test.in)
+				}
+				if swap.Out.String() != test.out {
+					t.Errorf("%s: got tokens out %s, want %s", name, swap.Out.String(), test.out)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeOsmosisCosmwasmPool(t *testing.T) {
+	tests := []struct {
+		name           string
+		instantiateMsg string
+		denoms         []string
+	}{
+		{
+			name:           "transmuter v1",
+			instantiateMsg: `{"pool_asset_denoms":["` + usdcAxlDenom + `","` + usdcDenom + `"]}`,
+			denoms:         []string{usdcAxlDenom, usdcDenom},
+		},
+		{
+			name:           "transmuter v3",
+			instantiateMsg: `{"pool_asset_configs":[{"denom":"` + usdcAxlDenom + `","normalization_factor":"1"},{"denom":"` + usdcDenom + `","normalization_factor":"1"}]}`,
+			denoms:         []string{usdcAxlDenom, usdcDenom},
+		},
+		{
+			name:           "orderbook",
+			instantiateMsg: `{"base_denom":"` + osmoDenom + `","quote_denom":"` + usdcDenom + `"}`,
+			denoms:         []string{osmoDenom, usdcDenom},
+		},
+		{
+			name:           "unknown contract",
+			instantiateMsg: `{"owner":"osmo1"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := protowire.AppendTag(nil, 2, protowire.VarintType)
+			b = protowire.AppendVarint(b, 1212)
+			b = protowire.AppendTag(b, 4, protowire.BytesType)
+			b = protowire.AppendBytes(b, []byte(test.instantiateMsg))
+			pool, err := decodeOsmosisPool(osmosisCosmwasmPoolType, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pool.Id != "1212" || pool.Type != OsmosisPoolTypeCosmwasm {
+				t.Errorf("got pool %s of type %s, want 1212 of type %s", pool.Id, pool.Type, OsmosisPoolTypeCosmwasm)
+			}
+			if len(pool.Denoms) != len(test.denoms) {
+				t.Fatalf("got denoms %v, want %v", pool.Denoms, test.denoms)
+			}
+			for i, denom := range test.denoms {
+				if pool.Denoms[i] != denom {
+					t.Errorf("got denoms %v, want %v", pool.Denoms, test.denoms)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package exchange
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"indexer/chain"
+	"indexer/config"
+	"indexer/store"
+
+	"github.com/rs/zerolog"
+)
+
+type (
+	// Factory creates an exchange named name from its [exchange.<name>] config
+	// section decoded into C.
+	Factory[C any] func(name string, cfg *C, store store.Store, logger zerolog.Logger) (Exchange, error)
+
+	factory func(name string, store store.Store, logger zerolog.Logger) (Exchange, error)
+)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]factory{}
+)
+
+// Register makes an exchange type available to NewExchange. Exchanges are matched
+// by the type key of their config section, defaulting to the exchange name, so
+// one type can back several named exchanges.
+func Register[C any](exchangeType string, f Factory[C]) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	_, ok := factories[exchangeType]
+	if ok {
+		panic(fmt.Sprintf("exchange type registered twice: %s", exchangeType))
+	}
+	factories[exchangeType] = func(name string, store store.Store, logger zerolog.Logger) (Exchange, error) {
+		cfg := new(C)
+		err := config.Cfg.DecodeExchangeConfig(name, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config for exchange %s: %v", name, err)
+		}
+		return f(name, cfg, store, logger)
+	}
+}
+
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	types := make([]string, 0, len(factories))
+	for exchangeType := range factories {
+		types = append(types, exchangeType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func NewExchange(name string, store store.Store, logger zerolog.Logger) (Exchange, error) {
+	exchangeLogger := logger.With().Str("exchange", name).Logger()
+	exchangeType := config.Cfg.ExchangeConfig[name].Type
+	if exchangeType == "" {
+		exchangeType = name
+	}
+	factoriesMu.RLock()
+	f, ok := factories[exchangeType]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported exchange: %s", exchangeType)
+	}
+	return f(name, store, exchangeLogger)
+}
+
+// ChainPool returns the rpc pool of the chain an exchange is configured for,
+// which defaults to a chain with the same name as the exchange.
+func ChainPool(name string, logger zerolog.Logger) (*chain.CometPool, error) {
+	chainName := config.Cfg.ExchangeConfig[name].Chain
+	if chainName == "" {
+		chainName = name
+	}
+	return chain.PoolFromConfig(chainName, logger)
+}
@@ -0,0 +1,62 @@
+package exchange
+
+import (
+	"fmt"
+
+	"indexer/chain"
+	"indexer/store"
+	"indexer/trading"
+
+	"github.com/rs/zerolog"
+)
+
+// Reprocess runs recorded events through the parsing of an exchange and saves the
+// resulting trades. When query is set, only events received on that subscription
+// are handled. It returns the number of trades saved.
+func Reprocess(e EventExchange, pool *chain.CometPool, s store.Store, files []string, query string, logger zerolog.Logger) (int, error) {
+	trades := e.SubscribeTrades()
+	ack := func(*trading.Trade) {}
+	acker, ok := e.(AckExchange)
+	if ok {
+		ack = acker.Ack
+	}
+	saved := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for trade := range trades {
+			err := s.SaveTrade(trade)
+			ack(trade)
+			if err != nil {
+				logger.Error().Err(err).Str("tx_hash", trade.TxHash).Msg("failed to save trade")
+				continue
+			}
+			saved++
+		}
+	}()
+	<-e.Ready()
+	var err error
+	for _, file := range files {
+		events := 0
+		err = chain.ReadRecordedEvents(file, func(recorded *chain.RecordedEvent) error {
+			if query != "" && recorded.Event.Query != query {
+				return nil
+			}
+			if !recorded.BlockTime.IsZero() {
+				pool.SetBlockTime(recorded.Height, recorded.BlockTime)
+			}
+			e.HandleEvent(&recorded.Event)
+			events++
+			return nil
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to reprocess %s: %v", file, err)
+			break
+		}
+		logger.Info().Str("file", file).Int("events", events).Msg("reprocessed event archive")
+	}
+	// no more trades are sent once HandleEvent has returned
+	close(trades)
+	<-done
+	return saved, err
+}
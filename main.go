@@ -3,11 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
-	"indexer/config"
 	"os"
 	"time"
 
-	"github.com/BurntSushi/toml"
+	"indexer/api"
+	"indexer/chain"
+	"indexer/config"
+	"indexer/exchange"
+	"indexer/pricing"
+	"indexer/replay"
+	"indexer/store"
+
 	"github.com/rs/zerolog"
 )
 
@@ -18,32 +24,233 @@ func main() {
 		configFile string
 	)
 
-	logger := zerolog.
-		New(zerolog.ConsoleWriter{
-			Out:        os.Stderr,
-			TimeFormat: time.StampMilli,
-		}).
-		Level(zerolog.DebugLevel).
-		With().
-		Timestamp().
-		Logger()
-
-	flag.StringVar(&logLevel, "log-level", "INFO", "logging level")
+	flag.StringVar(&logLevel, "log-level", "", "logging level; defaults to the configured level")
 	flag.StringVar(&logFormat, "log-format", "text", "logging format; must be either json or text")
 	flag.StringVar(&configFile, "config-file", "", "config file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [serve|replay|reprocess] [command flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 
 	flag.Parse()
 
-	var cfg = config.Cfg
+	cfg := config.Cfg
 
+	logger := newLogger(logFormat, cfg.LogLevel)
 	if configFile != "" {
-		_, err := toml.DecodeFile(configFile, cfg)
+		err := cfg.LoadFile(configFile)
 		if err != nil {
-			logger.Fatal().Err(err)
+			logger.Fatal().Err(err).Msg("failed to load config file")
 		}
+	} else {
+		err := cfg.Check()
+		if err != nil {
+			logger.Fatal().Err(err).Msg("invalid config")
+		}
+	}
+	level := cfg.LogLevel
+	if logLevel != "" {
+		parsed, err := zerolog.ParseLevel(logLevel)
+		if err != nil {
+			logger.Fatal().Err(err).Str("log_level", logLevel).Msg("invalid log level")
+		}
+		level = parsed
 	}
+	logger = logger.Level(level)
 
-	fmt.Println(cfg)
+	command := flag.Arg(0)
+	args := flag.Args()
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	switch command {
+	case "", "serve":
+		serve(logger)
+	case "replay":
+		replayTrades(args, logger)
+	case "reprocess":
+		reprocessEvents(args, logger)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
 
-	logger.Info().Msg("Yay")
+func newLogger(format string, level zerolog.Level) zerolog.Logger {
+	var logger zerolog.Logger
+	switch format {
+	case "json":
+		logger = zerolog.New(os.Stderr)
+	case "text":
+		logger = zerolog.New(zerolog.ConsoleWriter{
+			Out:        os.Stderr,
+			TimeFormat: time.StampMilli,
+		})
+	default:
+		fmt.Fprintf(os.Stderr, "invalid log format: %s\n", format)
+		os.Exit(2)
+	}
+	return logger.Level(level).With().Timestamp().Logger()
+}
+
+func serve(logger zerolog.Logger) {
+	logger.Trace().
+		Any("exchanges", config.Cfg.Exchanges).
+		Str("log_level", config.Cfg.LogLevel.String()).
+		Str("store_backend", config.Cfg.StoreBackend).
+		Dur("trades_max_age", config.Cfg.TradesMaxAge).
+		Dur("candles_interval", config.Cfg.CandlesInterval).
+		Dur("candles_period", config.Cfg.CandlesPeriod).
+		Msg("config")
+	storeManager, err := store.NewStoreManager(config.Cfg.StoreBackend, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to initialize database")
+	}
+	defer storeManager.Close()
+	err = storeManager.Health()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("database health check failed")
+	}
+	exchanges := make(map[string]exchange.Exchange, len(config.Cfg.Exchanges))
+	for _, exchangeName := range config.Cfg.Exchanges {
+		store, err := storeManager.Store(exchangeName)
+		if err != nil {
+			logger.Error().Err(err).Str("exchange", exchangeName).Msg("failed to initialize exchange store")
+			continue
+		}
+		exchange, err := exchange.NewExchange(exchangeName, store, logger)
+		if err != nil {
+			logger.Error().Err(err).Str("exchange", exchangeName).Msg("failed to initialize exchange")
+			continue
+		}
+		exchanges[exchangeName] = exchange
+	}
+	exchangeManager, err := exchange.NewExchangeManager(exchanges, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to initialize exchange manager")
+	}
+	exchangeManager.Start()
+	prices, err := pricing.NewIndex(exchangeManager, config.Cfg.Prices)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to initialize price index")
+	}
+	valuer := pricing.NewValuer(prices, config.Cfg.Valuation, logger)
+	valuer.Start()
+	api := api.NewApi(exchanges, exchangeManager, prices, valuer, storeManager, logger)
+	api.Start()
+}
+
+// replayTrades feeds recorded trades through a mock exchange, optionally serving
+// the API over the result.
+func replayTrades(args []string, logger zerolog.Logger) {
+	var (
+		file         string
+		exchangeName string
+		storeBackend string
+		speed        float64
+		serveApi     bool
+	)
+
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.StringVar(&file, "file", "", "JSON lines file of trades to replay")
+	flags.StringVar(&exchangeName, "exchange", "replay", "name of the replayed exchange")
+	flags.StringVar(&storeBackend, "store", "memory", "store backend for replayed trades")
+	flags.Float64Var(&speed, "speed", 0, "replay speed relative to recorded time; 0 replays as fast as possible")
+	flags.BoolVar(&serveApi, "serve", true, "serve the API while and after replaying")
+	flags.Parse(args)
+
+	if file == "" {
+		logger.Fatal().Msg("missing replay file")
+	}
+	trades, err := replay.ReadTradesFile(file)
+	if err != nil {
+		logger.Fatal().Err(err).Str("file", file).Msg("failed to read trades")
+	}
+	storeManager, err := store.NewStoreManager(storeBackend, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to initialize database")
+	}
+	defer storeManager.Close()
+	store, err := storeManager.Store(exchangeName)
+	if err != nil {
+		logger.Fatal().Err(err).Str("exchange", exchangeName).Msg("failed to initialize exchange store")
+	}
+	mock := exchange.NewMockExchange(exchangeName, store, logger.With().Str("exchange", exchangeName).Logger())
+	exchanges := map[string]exchange.Exchange{exchangeName: mock}
+	exchangeManager, err := exchange.NewExchangeManager(exchanges, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to initialize exchange manager")
+	}
+	replayer := replay.NewReplayer(mock, trades, speed, logger)
+	exchangeManager.SetClock(replayer.Clock())
+	exchangeManager.Start()
+	if !serveApi {
+		replayer.Run()
+		return
+	}
+	go replayer.Run()
+	prices, err := pricing.NewIndex(exchangeManager, config.Cfg.Prices)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to initialize price index")
+	}
+	valuer := pricing.NewValuer(prices, config.Cfg.Valuation, logger)
+	valuer.Start()
+	api := api.NewApi(exchanges, exchangeManager, prices, valuer, storeManager, logger)
+	api.Start()
+}
+
+// reprocessEvents re-runs the parsing of an exchange over recorded chain events
+// and saves the resulting trades.
+func reprocessEvents(args []string, logger zerolog.Logger) {
+	var (
+		exchangeName string
+		storeBackend string
+		query        string
+	)
+
+	flags := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	flags.StringVar(&exchangeName, "exchange", "", "configured exchange to parse events with")
+	flags.StringVar(&storeBackend, "store", config.Cfg.StoreBackend, "store backend for reprocessed trades")
+	flags.StringVar(&query, "query", "", "only reprocess events received on this subscription query")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s reprocess -exchange NAME [flags] FILE|DIR...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if exchangeName == "" || flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	files, err := chain.RecordFiles(flags.Args())
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to list event archives")
+	}
+	storeManager, err := store.NewStoreManager(storeBackend, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to initialize database")
+	}
+	defer storeManager.Close()
+	store, err := storeManager.Store(exchangeName)
+	if err != nil {
+		logger.Fatal().Err(err).Str("exchange", exchangeName).Msg("failed to initialize exchange store")
+	}
+	e, err := exchange.NewExchange(exchangeName, store, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Str("exchange", exchangeName).Msg("failed to initialize exchange")
+	}
+	eventExchange, ok := e.(exchange.EventExchange)
+	if !ok {
+		logger.Fatal().Str("exchange", exchangeName).Msg("exchange does not parse chain events")
+	}
+	pool, err := exchange.ChainPool(exchangeName, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Str("exchange", exchangeName).Msg("failed to initialize chain")
+	}
+	saved, err := exchange.Reprocess(eventExchange, pool, store, files, query, logger)
+	if err != nil {
+		logger.Error().Err(err).Int("trades", saved).Msg("reprocessing failed")
+		return
+	}
+	logger.Info().Int("files", len(files)).Int("trades", saved).Msg("reprocessing complete")
 }
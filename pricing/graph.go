@@ -0,0 +1,203 @@
+package pricing
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"indexer/math"
+	"indexer/trading"
+
+	"github.com/ericlagergren/decimal"
+)
+
+const DefaultMaxHops = 3
+
+type (
+	// Graph links assets through the tickers of every exchange, so prices can be
+	// derived for pairs no exchange lists directly.
+	Graph struct {
+		edges map[string][]*Edge
+	}
+
+	// Edge converts From into To at Price, with Volume the 24h volume in To units.
+	Edge struct {
+		Exchange string
+		From     string
+		To       string
+		Price    decimal.Big
+		Volume   decimal.Big
+	}
+
+	Hop struct {
+		Exchange   string      `json:"exchange"`
+		BaseAsset  string      `json:"base_asset"`
+		QuoteAsset string      `json:"quote_asset"`
+		Price      decimal.Big `json:"price"`
+		Volume     decimal.Big `json:"quote_volume"`
+	}
+
+	// Route is a derived price. Volume is the smallest hop volume valued in the
+	// route's quote, i.e. how much the route as a whole has carried.
+	Route struct {
+		BaseAsset  string      `json:"base_asset"`
+		QuoteAsset string      `json:"quote_asset"`
+		Price      decimal.Big `json:"price"`
+		Volume     decimal.Big `json:"quote_volume"`
+		Path       []*Hop      `json:"path"`
+	}
+)
+
+func NewGraph() *Graph {
+	return &Graph{
+		edges: map[string][]*Edge{},
+	}
+}
+
+// AddTicker adds both directions of a ticker. Tickers without a price are
+// ignored.
+func (g *Graph) AddTicker(exchange string, ticker *trading.Ticker) {
+	if ticker.Price.Sign() <= 0 {
+		return
+	}
+	forward := &Edge{
+		Exchange: exchange,
+		From:     ticker.BaseAsset,
+		To:       ticker.QuoteAsset,
+	}
+	forward.Price.Set(&ticker.Price)
+	forward.Volume.Set(&ticker.QuoteVolume)
+	backward := &Edge{
+		Exchange: exchange,
+		From:     ticker.QuoteAsset,
+		To:       ticker.BaseAsset,
+	}
+	backward.Price.Quo(math.One, &ticker.Price)
+	backward.Volume.Set(&ticker.BaseVolume)
+	g.edges[forward.From] = append(g.edges[forward.From], forward)
+	g.edges[backward.From] = append(g.edges[backward.From], backward)
+}
+
+// Route finds the path from base to any of the quotes with the largest
+// bottleneck volume, visiting at most maxHops pairs and no asset twice.
+func (g *Graph) Route(base string, quotes []string, maxHops int) (*Route, error) {
+	targets := make(map[string]struct{}, len(quotes))
+	for _, quote := range quotes {
+		targets[quote] = struct{}{}
+	}
+	var best *Route
+	path := []*Edge{}
+	visited := map[string]struct{}{base: {}}
+	var walk func(asset string)
+	walk = func(asset string) {
+		_, ok := targets[asset]
+		if ok && len(path) > 0 {
+			route := newRoute(path)
+			cmp := 1
+			if best != nil {
+				cmp = route.Volume.Cmp(&best.Volume)
+			}
+			if cmp > 0 || (cmp == 0 && len(route.Path) < len(best.Path)) {
+				best = route
+			}
+			return
+		}
+		if len(path) >= maxHops {
+			return
+		}
+		for _, edge := range g.edges[asset] {
+			_, ok := visited[edge.To]
+			if ok {
+				continue
+			}
+			visited[edge.To] = struct{}{}
+			path = append(path, edge)
+			walk(edge.To)
+			path = path[:len(path)-1]
+			delete(visited, edge.To)
+		}
+	}
+	walk(base)
+	if best == nil {
+		return nil, fmt.Errorf("no route from %s to %v", base, quotes)
+	}
+	return best, nil
+}
+
+func newRoute(path []*Edge) *Route {
+	route := &Route{
+		BaseAsset:  path[0].From,
+		QuoteAsset: path[len(path)-1].To,
+		Path:       make([]*Hop, len(path)),
+	}
+	route.Price.Set(math.One)
+	// value each hop's volume in the final quote by walking back from the end
+	rate := new(decimal.Big).Set(math.One)
+	for i := len(path) - 1; i >= 0; i-- {
+		edge := path[i]
+		hop := &Hop{
+			Exchange:   edge.Exchange,
+			BaseAsset:  edge.From,
+			QuoteAsset: edge.To,
+		}
+		hop.Price.Set(&edge.Price)
+		hop.Volume.Set(&edge.Volume)
+		route.Path[i] = hop
+		value := new(decimal.Big).Mul(&edge.Volume, rate)
+		if i == len(path)-1 || value.Cmp(&route.Volume) < 0 {
+			route.Volume.Set(value)
+		}
+		rate.Mul(rate, &edge.Price)
+		route.Price.Mul(&route.Price, &edge.Price)
+	}
+	return route
+}
+
+// Graph builds a graph from the fresh tickers of the given exchanges, or of all
+// exchanges if none are given.
+func (i *Index) Graph(names []string) *Graph {
+	return i.graph(names, i.cfg.MaxAge)
+}
+
+// graph builds the graph from the tickers traded within maxAge, or from every
+// ticker with a trade if maxAge is zero.
+func (i *Index) graph(names []string, maxAge time.Duration) *Graph {
+	if len(names) == 0 {
+		for name := range i.exchanges.Exchanges {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	now := i.exchanges.Clock().Now()
+	graph := NewGraph()
+	for _, name := range names {
+		tickers, err := i.exchanges.Tickers(name)
+		if err != nil {
+			continue
+		}
+		for _, ticker := range tickers {
+			if ticker.LastTrade.IsZero() {
+				continue
+			}
+			if maxAge > 0 && now.Sub(ticker.LastTrade) > maxAge {
+				continue
+			}
+			graph.AddTicker(name, ticker)
+		}
+	}
+	return graph
+}
+
+// Route derives the price of base in quote, which may be an alias such as USD
+// standing for several assets.
+func (i *Index) Route(base string, quote string, names []string) (*Route, error) {
+	quotes, ok := i.cfg.QuoteAliases[quote]
+	if !ok {
+		quotes = []string{quote}
+	}
+	maxHops := i.cfg.MaxHops
+	if maxHops <= 0 {
+		maxHops = DefaultMaxHops
+	}
+	return i.Graph(names).Route(base, quotes, maxHops)
+}
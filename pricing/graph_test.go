@@ -0,0 +1,121 @@
+package pricing
+
+import (
+	"testing"
+
+	"indexer/trading"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// newTestTicker prices base at price quote, with baseVolume traded.
+func newTestTicker(base string, quote string, price int64, baseVolume int64) *trading.Ticker {
+	ticker := &trading.Ticker{
+		BaseAsset:  base,
+		QuoteAsset: quote,
+	}
+	ticker.Price.SetMantScale(price, 0)
+	ticker.BaseVolume.SetMantScale(baseVolume, 0)
+	ticker.QuoteVolume.SetMantScale(price*baseVolume, 0)
+	return ticker
+}
+
+func checkRoute(t *testing.T, route *Route, path []string, price *decimal.Big, volume int64) {
+	t.Helper()
+	assets := []string{route.BaseAsset}
+	for _, hop := range route.Path {
+		assets = append(assets, hop.QuoteAsset)
+	}
+	if len(assets) != len(path) {
+		t.Fatalf("got path %v, want %v", assets, path)
+	}
+	for i := range path {
+		if assets[i] != path[i] {
+			t.Fatalf("got path %v, want %v", assets, path)
+		}
+	}
+	if route.Price.Cmp(price) != 0 {
+		t.Errorf("got price %s, want %s", route.Price.String(), price.String())
+	}
+	if route.Volume.Cmp(decimal.New(volume, 0)) != 0 {
+		t.Errorf("got volume %s, want %d", route.Volume.String(), volume)
+	}
+}
+
+func TestRouteTwoHops(t *testing.T) {
+	graph := NewGraph()
+	graph.AddTicker("a", newTestTicker("ATOM", "OSMO", 5, 100))
+	graph.AddTicker("b", newTestTicker("OSMO", "USDC", 2, 300))
+	route, err := graph.Route("ATOM", []string{"USDC"}, DefaultMaxHops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 500 OSMO traded against ATOM is worth 1000 USDC, more than the 600 USDC
+	// traded against OSMO
+	checkRoute(t, route, []string{"ATOM", "OSMO", "USDC"}, decimal.New(10, 0), 600)
+	if route.Path[0].Exchange != "a" || route.Path[1].Exchange != "b" {
+		t.Errorf("got exchanges %s and %s, want a and b", route.Path[0].Exchange, route.Path[1].Exchange)
+	}
+}
+
+func TestRouteTakesLargestBottleneck(t *testing.T) {
+	graph := NewGraph()
+	graph.AddTicker("a", newTestTicker("ATOM", "USDC", 10, 1))
+	graph.AddTicker("a", newTestTicker("ATOM", "OSMO", 5, 100))
+	graph.AddTicker("a", newTestTicker("OSMO", "USDC", 2, 300))
+	route, err := graph.Route("ATOM", []string{"USDC"}, DefaultMaxHops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRoute(t, route, []string{"ATOM", "OSMO", "USDC"}, decimal.New(10, 0), 600)
+}
+
+func TestRouteReversesTickers(t *testing.T) {
+	graph := NewGraph()
+	graph.AddTicker("a", newTestTicker("USDC", "ATOM", 2, 50))
+	route, err := graph.Route("ATOM", []string{"USDC"}, DefaultMaxHops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRoute(t, route, []string{"ATOM", "USDC"}, decimal.New(5, 1), 50)
+}
+
+func TestRouteRejectsCycles(t *testing.T) {
+	graph := NewGraph()
+	graph.AddTicker("a", newTestTicker("ATOM", "OSMO", 5, 100))
+	graph.AddTicker("a", newTestTicker("OSMO", "STARS", 2, 100))
+	graph.AddTicker("b", newTestTicker("STARS", "ATOM", 1, 100))
+	_, err := graph.Route("ATOM", []string{"ATOM"}, DefaultMaxHops)
+	if err == nil {
+		t.Error("got a route back to the base asset")
+	}
+	graph.AddTicker("b", newTestTicker("STARS", "USDC", 1, 1000))
+	route, err := graph.Route("ATOM", []string{"USDC"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]struct{}{route.BaseAsset: {}}
+	for _, hop := range route.Path {
+		_, ok := seen[hop.QuoteAsset]
+		if ok {
+			t.Fatalf("route visits %s twice", hop.QuoteAsset)
+		}
+		seen[hop.QuoteAsset] = struct{}{}
+	}
+}
+
+func TestRouteMaxHops(t *testing.T) {
+	graph := NewGraph()
+	graph.AddTicker("a", newTestTicker("ATOM", "OSMO", 5, 100))
+	graph.AddTicker("a", newTestTicker("OSMO", "STARS", 2, 1000))
+	graph.AddTicker("a", newTestTicker("STARS", "USDC", 3, 10000))
+	route, err := graph.Route("ATOM", []string{"USDC"}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRoute(t, route, []string{"ATOM", "OSMO", "STARS", "USDC"}, decimal.New(30, 0), 3000)
+	_, err = graph.Route("ATOM", []string{"USDC"}, 2)
+	if err == nil {
+		t.Error("got a route longer than two hops")
+	}
+}
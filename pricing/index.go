@@ -0,0 +1,212 @@
+package pricing
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"indexer/config"
+	"indexer/exchange"
+	"indexer/math"
+	"indexer/token"
+
+	"github.com/ericlagergren/decimal"
+)
+
+const (
+	ModeVwap      = "vwap"
+	ModeMedian    = "median"
+	ModeLiquidity = "liquidity"
+
+	RejectedStale   = "stale"
+	RejectedOutlier = "outlier"
+	RejectedNoTrade = "no_trades"
+)
+
+var Modes = map[string]struct{}{
+	ModeVwap:      {},
+	ModeMedian:    {},
+	ModeLiquidity: {},
+}
+
+type (
+	// Index aggregates the tickers of a pair across exchanges into one price.
+	Index struct {
+		exchanges *exchange.ExchangeManager
+		cfg       config.PricesConfig
+	}
+
+	Price struct {
+		BaseAsset  string      `json:"base_asset"`
+		QuoteAsset string      `json:"quote_asset"`
+		Price      decimal.Big `json:"price"`
+		Mode       string      `json:"mode"`
+		Time       time.Time   `json:"time"`
+		Sources    []*Source   `json:"sources"`
+	}
+
+	Source struct {
+		Exchange   string       `json:"exchange"`
+		Price      decimal.Big  `json:"price"`
+		BaseVolume decimal.Big  `json:"base_volume"`
+		Liquidity  *decimal.Big `json:"liquidity,omitempty"`
+		LastTrade  time.Time    `json:"last_trade"`
+		Rejected   string       `json:"rejected,omitempty"`
+	}
+)
+
+func NewIndex(exchanges *exchange.ExchangeManager, cfg config.PricesConfig) (*Index, error) {
+	_, ok := Modes[cfg.Mode]
+	if !ok {
+		return nil, fmt.Errorf("invalid price mode '%s'", cfg.Mode)
+	}
+	return &Index{
+		exchanges: exchanges,
+		cfg:       cfg,
+	}, nil
+}
+
+func (i *Index) Mode() string {
+	return i.cfg.Mode
+}
+
+// Price aggregates the pair's price over every exchange listing it. The
+// liquidity mode falls back to vwap when no source reports liquidity, and vwap
+// falls back to the median when there was no volume; the mode used is returned.
+func (i *Index) Price(pair *token.Pair, mode string) (*Price, error) {
+	_, ok := Modes[mode]
+	if !ok {
+		return nil, fmt.Errorf("invalid price mode '%s'", mode)
+	}
+	now := i.exchanges.Clock().Now().UTC()
+	sources := i.sources(pair, now)
+	accepted := i.reject(sources)
+	if len(accepted) < i.cfg.MinSources || len(accepted) == 0 {
+		return nil, fmt.Errorf("not enough price sources for %s: %d of %d", pair, len(accepted), i.cfg.MinSources)
+	}
+	price := &Price{
+		BaseAsset:  pair.Base,
+		QuoteAsset: pair.Quote,
+		Mode:       mode,
+		Time:       now,
+		Sources:    sources,
+	}
+	if price.Mode == ModeLiquidity {
+		ok = weighted(&price.Price, accepted, func(s *Source) *decimal.Big { return s.Liquidity })
+		if !ok {
+			price.Mode = ModeVwap
+		}
+	}
+	if price.Mode == ModeVwap {
+		ok = weighted(&price.Price, accepted, func(s *Source) *decimal.Big { return &s.BaseVolume })
+		if !ok {
+			price.Mode = ModeMedian
+		}
+	}
+	if price.Mode == ModeMedian {
+		price.Price.Set(median(accepted))
+	}
+	return price, nil
+}
+
+func (i *Index) sources(pair *token.Pair, now time.Time) []*Source {
+	// copied, since sorting in place would reorder the shared config
+	names := append([]string{}, i.cfg.Exchanges...)
+	if len(names) == 0 {
+		for name := range i.exchanges.Exchanges {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	sources := []*Source{}
+	for _, name := range names {
+		ticker, err := i.exchanges.Ticker(name, pair)
+		if err != nil {
+			continue
+		}
+		source := &Source{
+			Exchange:  name,
+			LastTrade: ticker.LastTrade,
+		}
+		source.Price.Set(&ticker.Price)
+		source.BaseVolume.Set(&ticker.BaseVolume)
+		if e, ok := i.exchanges.Exchanges[name].(exchange.LiquidityExchange); ok {
+			liquidity, ok := e.Liquidity(pair)
+			if ok {
+				source.Liquidity = liquidity
+			}
+		}
+		if source.Price.Cmp(math.Zero) == 0 || source.LastTrade.IsZero() {
+			source.Rejected = RejectedNoTrade
+		} else if i.cfg.MaxAge > 0 && now.Sub(source.LastTrade) > i.cfg.MaxAge {
+			source.Rejected = RejectedStale
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// reject marks sources too far from the median as outliers and returns the rest.
+// Outliers are only detected with three or more sources, since with two there is
+// no telling which one is off.
+func (i *Index) reject(sources []*Source) []*Source {
+	accepted := []*Source{}
+	for _, source := range sources {
+		if source.Rejected == "" {
+			accepted = append(accepted, source)
+		}
+	}
+	if len(accepted) < 3 || i.cfg.MaxDeviation <= 0 {
+		return accepted
+	}
+	mid := median(accepted)
+	maxDeviation := new(decimal.Big).SetFloat64(i.cfg.MaxDeviation)
+	remaining := []*Source{}
+	for _, source := range accepted {
+		deviation := new(decimal.Big).Sub(&source.Price, mid)
+		deviation.Abs(deviation)
+		deviation.Quo(deviation, mid)
+		if deviation.Cmp(maxDeviation) > 0 {
+			source.Rejected = RejectedOutlier
+			continue
+		}
+		remaining = append(remaining, source)
+	}
+	return remaining
+}
+
+// weighted sets result to the average of the source prices weighted by weight,
+// skipping sources without one. It returns false if the weights sum to zero.
+func weighted(result *decimal.Big, sources []*Source, weight func(s *Source) *decimal.Big) bool {
+	sum := &decimal.Big{}
+	total := &decimal.Big{}
+	for _, source := range sources {
+		w := weight(source)
+		if w == nil || w.Sign() <= 0 {
+			continue
+		}
+		sum.Add(sum, new(decimal.Big).Mul(&source.Price, w))
+		total.Add(total, w)
+	}
+	if total.Sign() == 0 {
+		return false
+	}
+	result.Quo(sum, total)
+	return true
+}
+
+func median(sources []*Source) *decimal.Big {
+	prices := make([]*decimal.Big, len(sources))
+	for i, source := range sources {
+		prices[i] = &source.Price
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].Cmp(prices[j]) < 0
+	})
+	n := len(prices)
+	if n%2 == 1 {
+		return new(decimal.Big).Set(prices[n/2])
+	}
+	mid := new(decimal.Big).Add(prices[n/2-1], prices[n/2])
+	return mid.Quo(mid, decimal.New(2, 0))
+}
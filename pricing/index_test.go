@@ -0,0 +1,285 @@
+package pricing
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"indexer/config"
+	"indexer/exchange"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/ericlagergren/decimal"
+	"github.com/rs/zerolog"
+)
+
+var (
+	testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// testNow is half way through the last hour of two days of hourly candles
+	testNow = testStart.Add(48*time.Hour + 30*time.Minute)
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// TestMain keeps two days of hourly candles, so that tickers cover the last 24
+// hours of trades.
+func TestMain(m *testing.M) {
+	config.Cfg.CandlesInterval = time.Hour
+	config.Cfg.CandlesPeriod = 48 * time.Hour
+	config.Cfg.AverageWindows = nil
+	config.Cfg.Staleness = config.StalenessConfig{MaxAge: 48 * time.Hour}
+	config.Cfg.Filter = config.FilterConfig{}
+	os.Exit(m.Run())
+}
+
+// testTrade trades volume base at price quote on exchange, age before testNow.
+type testTrade struct {
+	exchange string
+	base     string
+	quote    string
+	price    int64
+	volume   int64
+	age      time.Duration
+}
+
+// liquidityExchange reports the same liquidity for every pair.
+type liquidityExchange struct {
+	*exchange.MockExchange
+	liquidity *decimal.Big
+}
+
+func (e *liquidityExchange) Liquidity(pair *token.Pair) (*decimal.Big, bool) {
+	return e.liquidity, true
+}
+
+// newTestManager serves the given trades from the stores of their exchanges,
+// with the clock at testNow. Exchanges with a liquidity report it.
+func newTestManager(t *testing.T, trades []testTrade, liquidity map[string]int64) *exchange.ExchangeManager {
+	t.Helper()
+	logger := zerolog.Nop()
+	stores, err := store.NewMemoryManager(&store.MemoryConfig{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mocks := map[string]*exchange.MockExchange{}
+	exchanges := map[string]exchange.Exchange{}
+	pairs := map[string][]*token.Pair{}
+	for _, trade := range trades {
+		_, ok := mocks[trade.exchange]
+		if !ok {
+			s, err := stores.Store(trade.exchange)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mocks[trade.exchange] = exchange.NewMockExchange(trade.exchange, s, logger)
+			exchanges[trade.exchange] = mocks[trade.exchange]
+			amount, ok := liquidity[trade.exchange]
+			if ok {
+				exchanges[trade.exchange] = &liquidityExchange{
+					MockExchange: mocks[trade.exchange],
+					liquidity:    decimal.New(amount, 0),
+				}
+			}
+		}
+		stored := &trading.Trade{
+			Base:  token.Token{Symbol: trade.base},
+			Quote: token.Token{Symbol: trade.quote},
+			Time:  testNow.Add(-trade.age),
+		}
+		stored.Base.Amount.SetMantScale(trade.volume, 0)
+		stored.Quote.Amount.SetMantScale(trade.price*trade.volume, 0)
+		err := mocks[trade.exchange].Store().SaveTrade(stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs[trade.exchange] = append(pairs[trade.exchange], stored.Pair())
+	}
+	manager, err := exchange.NewExchangeManager(exchanges, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetClock(fixedClock(testNow))
+	manager.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for name, mock := range mocks {
+		mock.AddPairs(pairs[name]...)
+		for _, pair := range pairs[name] {
+			for {
+				_, err := manager.Ticker(name, pair)
+				if err == nil {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("pair %s was not added to %s", pair, name)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	return manager
+}
+
+func TestIndexPrice(t *testing.T) {
+	cfg := config.PricesConfig{
+		MaxDeviation: 0.25,
+		MaxAge:       time.Hour,
+		MinSources:   1,
+	}
+	tests := []struct {
+		name      string
+		cfg       *config.PricesConfig
+		trades    []testTrade
+		liquidity map[string]int64
+		mode      string
+		wantMode  string
+		price     string
+		rejected  map[string]string
+		err       bool
+	}{
+		{
+			name: "vwap",
+			trades: []testTrade{
+				{"a", "ATOM", "USDC", 10, 1, 0},
+				{"b", "ATOM", "USDC", 12, 3, 0},
+			},
+			mode:  ModeVwap,
+			price: "11.5",
+		},
+		{
+			name: "vwap in the other orientation",
+			trades: []testTrade{
+				{"a", "USDC", "ATOM", 4, 1, 0},
+				{"b", "USDC", "ATOM", 2, 2, 0},
+			},
+			mode:  ModeVwap,
+			price: "0.375",
+		},
+		{
+			name: "median",
+			trades: []testTrade{
+				{"a", "ATOM", "USDC", 10, 1, 0},
+				{"b", "ATOM", "USDC", 12, 100, 0},
+				{"c", "ATOM", "USDC", 13, 1, 0},
+			},
+			mode:  ModeMedian,
+			price: "12",
+		},
+		{
+			name: "liquidity",
+			trades: []testTrade{
+				{"a", "ATOM", "USDC", 10, 1, 0},
+				{"b", "ATOM", "USDC", 12, 1, 0},
+			},
+			liquidity: map[string]int64{"a": 3, "b": 1},
+			mode:      ModeLiquidity,
+			price:     "10.5",
+		},
+		{
+			name: "liquidity falls back to vwap",
+			trades: []testTrade{
+				{"a", "ATOM", "USDC", 10, 1, 0},
+				{"b", "ATOM", "USDC", 12, 3, 0},
+			},
+			mode:     ModeLiquidity,
+			wantMode: ModeVwap,
+			price:    "11.5",
+		},
+		{
+			name: "outlier",
+			trades: []testTrade{
+				{"a", "ATOM", "USDC", 10, 1, 0},
+				{"b", "ATOM", "USDC", 11, 1, 0},
+				{"c", "ATOM", "USDC", 20, 1, 0},
+			},
+			mode:     ModeVwap,
+			price:    "10.5",
+			rejected: map[string]string{"c": RejectedOutlier},
+		},
+		{
+			name: "no outliers with two sources",
+			trades: []testTrade{
+				{"a", "ATOM", "USDC", 10, 1, 0},
+				{"b", "ATOM", "USDC", 20, 1, 0},
+			},
+			mode:  ModeVwap,
+			price: "15",
+		},
+		{
+			name: "stale",
+			trades: []testTrade{
+				{"a", "ATOM", "USDC", 10, 1, 0},
+				{"b", "ATOM", "USDC", 20, 1, 2 * time.Hour},
+			},
+			mode:     ModeVwap,
+			price:    "10",
+			rejected: map[string]string{"b": RejectedStale},
+		},
+		{
+			name: "too few sources",
+			cfg:  &config.PricesConfig{MaxAge: time.Hour, MinSources: 2},
+			trades: []testTrade{
+				{"a", "ATOM", "USDC", 10, 1, 0},
+				{"b", "ATOM", "USDC", 20, 1, 2 * time.Hour},
+			},
+			mode: ModeVwap,
+			err:  true,
+		},
+		{
+			name: "only stale sources",
+			trades: []testTrade{
+				{"a", "ATOM", "USDC", 10, 1, 2 * time.Hour},
+			},
+			mode: ModeMedian,
+			err:  true,
+		},
+	}
+	pair := &token.Pair{Base: "ATOM", Quote: "USDC"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			indexConfig := cfg
+			if test.cfg != nil {
+				indexConfig = *test.cfg
+			}
+			indexConfig.Mode = test.mode
+			index, err := NewIndex(newTestManager(t, test.trades, test.liquidity), indexConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			price, err := index.Price(pair, test.mode)
+			if test.err {
+				if err == nil {
+					t.Fatalf("got price %s, want an error", price.Price.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := new(decimal.Big).SetString(test.price)
+			if price.Price.Cmp(want) != 0 {
+				t.Errorf("got price %s, want %s", price.Price.String(), test.price)
+			}
+			wantMode := test.wantMode
+			if wantMode == "" {
+				wantMode = test.mode
+			}
+			if price.Mode != wantMode {
+				t.Errorf("got mode %s, want %s", price.Mode, wantMode)
+			}
+			if len(price.Sources) != len(test.trades) {
+				t.Fatalf("got %d sources, want %d", len(price.Sources), len(test.trades))
+			}
+			for _, source := range price.Sources {
+				if source.Rejected != test.rejected[source.Exchange] {
+					t.Errorf("got %s rejected as %q, want %q", source.Exchange, source.Rejected, test.rejected[source.Exchange])
+				}
+			}
+		})
+	}
+}
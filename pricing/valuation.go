@@ -0,0 +1,179 @@
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"indexer/config"
+	"indexer/math"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/ericlagergren/decimal"
+	"github.com/rs/zerolog"
+)
+
+type (
+	// Valuer values volumes in the reference currency. Stablecoins are worth one
+	// unit, other assets are routed to a stablecoin through the tickers of the
+	// same exchange and, failing that, priced by the reference feed.
+	Valuer struct {
+		index   *Index
+		cfg     config.ValuationConfig
+		fetcher *token.Fetcher
+		mu      sync.RWMutex
+		feed    map[string]*decimal.Big
+		logger  zerolog.Logger
+	}
+
+	// Valuation is a snapshot of the prices on one exchange. Prices are looked up
+	// lazily and kept for the lifetime of the snapshot.
+	Valuation struct {
+		valuer *Valuer
+		graph  *Graph
+		prices map[string]*decimal.Big
+		now    time.Time
+	}
+)
+
+func NewValuer(index *Index, cfg config.ValuationConfig, logger zerolog.Logger) *Valuer {
+	return &Valuer{
+		index:   index,
+		cfg:     cfg,
+		fetcher: token.NewFetcher(token.DefaultFetchTimeout),
+		feed:    map[string]*decimal.Big{},
+		logger:  logger,
+	}
+}
+
+func (v *Valuer) Currency() string {
+	return v.cfg.Currency
+}
+
+// Start polls the reference feed, if one is configured. The feed is a JSON
+// object of symbols to prices in the reference currency.
+func (v *Valuer) Start() {
+	if v.cfg.FeedUrl == "" {
+		return
+	}
+	go func() {
+		for {
+			err := v.RefreshFeed()
+			if err != nil {
+				v.logger.Error().Err(err).Str("url", v.cfg.FeedUrl).Msg("failed to refresh reference feed")
+			}
+			time.Sleep(v.cfg.FeedInterval)
+		}
+	}()
+}
+
+func (v *Valuer) RefreshFeed() error {
+	body, modified, err := v.fetcher.Fetch(v.cfg.FeedUrl)
+	if err != nil {
+		return err
+	}
+	if !modified {
+		return nil
+	}
+	raw := map[string]json.Number{}
+	err = json.Unmarshal(body, &raw)
+	if err != nil {
+		return err
+	}
+	feed := make(map[string]*decimal.Big, len(raw))
+	for symbol, number := range raw {
+		price, ok := new(decimal.Big).SetString(number.String())
+		if !ok {
+			return fmt.Errorf("invalid price '%s' for %s", number, symbol)
+		}
+		feed[symbol] = price
+	}
+	v.mu.Lock()
+	v.feed = feed
+	v.mu.Unlock()
+	v.logger.Debug().Int("num_prices", len(feed)).Msg("refreshed reference feed")
+	return nil
+}
+
+func (v *Valuer) feedPrice(symbol string) (*decimal.Big, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	price, ok := v.feed[symbol]
+	return price, ok
+}
+
+// Exchange takes a snapshot of the prices on the named exchange. Prices are
+// routed through pairs traded within the valuation max age, which is longer
+// than that of the price index so that thinly traded assets can still be
+// valued.
+func (v *Valuer) Exchange(name string) *Valuation {
+	return &Valuation{
+		valuer: v,
+		graph:  v.index.graph([]string{name}, v.cfg.MaxAge),
+		prices: map[string]*decimal.Big{},
+		now:    v.index.exchanges.Clock().Now(),
+	}
+}
+
+// Price returns the value of one unit of the asset, nil if it cannot be valued.
+func (v *Valuation) Price(symbol string) *decimal.Big {
+	price, ok := v.prices[symbol]
+	if ok {
+		return price
+	}
+	for _, stable := range v.valuer.cfg.Stablecoins {
+		if stable == symbol {
+			price = math.One
+		}
+	}
+	if price == nil {
+		maxHops := v.valuer.index.cfg.MaxHops
+		if maxHops <= 0 {
+			maxHops = DefaultMaxHops
+		}
+		route, err := v.graph.Route(symbol, v.valuer.cfg.Stablecoins, maxHops)
+		if err == nil {
+			price = &route.Price
+		}
+	}
+	if price == nil {
+		price, _ = v.valuer.feedPrice(symbol)
+	}
+	v.prices[symbol] = price
+	return price
+}
+
+// Volume values a trade volume by its quote side where possible, since quotes
+// are usually the better priced asset.
+func (v *Valuation) Volume(base string, baseVolume *decimal.Big, quote string, quoteVolume *decimal.Big) *decimal.Big {
+	price := v.Price(quote)
+	if price != nil {
+		return new(decimal.Big).Mul(quoteVolume, price)
+	}
+	price = v.Price(base)
+	if price != nil {
+		return new(decimal.Big).Mul(baseVolume, price)
+	}
+	return nil
+}
+
+// Ticker returns a copy of the ticker with its volume valued.
+func (v *Valuation) Ticker(ticker *trading.Ticker) *trading.Ticker {
+	valued := *ticker
+	valued.UsdVolume = v.Volume(ticker.BaseAsset, &ticker.BaseVolume, ticker.QuoteAsset, &ticker.QuoteVolume)
+	return &valued
+}
+
+// Candle returns a copy of the candle with its volume valued at current prices.
+// Closed candles are left without a value, since the prices they traded at
+// are not known.
+func (v *Valuation) Candle(candle *trading.Candle) *trading.Candle {
+	valued := *candle
+	valued.UsdVolume = nil
+	if candle.End.After(v.now) {
+		valued.UsdVolume = v.Volume(candle.BaseAsset, &candle.BaseVolume, candle.QuoteAsset, &candle.QuoteVolume)
+	}
+	return &valued
+}
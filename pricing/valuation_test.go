@@ -0,0 +1,187 @@
+package pricing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"indexer/config"
+	"indexer/trading"
+
+	"github.com/ericlagergren/decimal"
+	"github.com/rs/zerolog"
+)
+
+// newTestValuation values the trades on exchange a, with a reference feed
+// pricing ATOM at 7 and STARS at 3.
+func newTestValuation(t *testing.T, cfg config.ValuationConfig, trades []testTrade) *Valuation {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ATOM": 7, "STARS": 3}`))
+	}))
+	t.Cleanup(server.Close)
+	index, err := NewIndex(newTestManager(t, trades, nil), config.PricesConfig{Mode: ModeVwap, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.FeedUrl = server.URL
+	valuer := NewValuer(index, cfg, zerolog.Nop())
+	err = valuer.RefreshFeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return valuer.Exchange("a")
+}
+
+func TestValuationPrice(t *testing.T) {
+	cfg := config.ValuationConfig{
+		Currency:    "USD",
+		Stablecoins: []string{"USDC", "USDT"},
+		MaxAge:      24 * time.Hour,
+	}
+	shortAge := cfg
+	shortAge.MaxAge = 12 * time.Hour
+	tests := []struct {
+		name   string
+		cfg    config.ValuationConfig
+		trades []testTrade
+		symbol string
+		price  string
+	}{
+		{
+			name:   "stablecoin",
+			cfg:    cfg,
+			trades: []testTrade{{"a", "USDT", "USDC", 2, 1, 0}},
+			symbol: "USDT",
+			price:  "1",
+		},
+		{
+			name: "route before the feed",
+			cfg:  cfg,
+			trades: []testTrade{
+				{"a", "ATOM", "OSMO", 5, 1, 0},
+				{"a", "OSMO", "USDC", 2, 1, 0},
+			},
+			symbol: "ATOM",
+			price:  "10",
+		},
+		{
+			name:   "route in the other orientation",
+			cfg:    cfg,
+			trades: []testTrade{{"a", "USDT", "OSMO", 4, 1, 0}},
+			symbol: "OSMO",
+			price:  "0.25",
+		},
+		{
+			name:   "route traded within the max age",
+			cfg:    cfg,
+			trades: []testTrade{{"a", "OSMO", "USDC", 2, 1, 23 * time.Hour}},
+			symbol: "OSMO",
+			price:  "2",
+		},
+		{
+			name:   "route traded before the max age",
+			cfg:    shortAge,
+			trades: []testTrade{{"a", "OSMO", "USDC", 2, 1, 23 * time.Hour}},
+			symbol: "OSMO",
+		},
+		{
+			name: "route on another exchange",
+			cfg:  cfg,
+			trades: []testTrade{
+				{"a", "ATOM", "OSMO", 5, 1, 0},
+				{"b", "OSMO", "USDC", 2, 1, 0},
+			},
+			symbol: "ATOM",
+			price:  "7",
+		},
+		{
+			name:   "feed",
+			cfg:    cfg,
+			trades: []testTrade{{"a", "STARS", "OSMO", 5, 1, 0}},
+			symbol: "STARS",
+			price:  "3",
+		},
+		{
+			name:   "unvalued",
+			cfg:    cfg,
+			trades: []testTrade{{"a", "JUNO", "OSMO", 5, 1, 0}},
+			symbol: "JUNO",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			valuation := newTestValuation(t, test.cfg, test.trades)
+			price := valuation.Price(test.symbol)
+			if test.price == "" {
+				if price != nil {
+					t.Errorf("got price %s, want none", price.String())
+				}
+				return
+			}
+			want, _ := new(decimal.Big).SetString(test.price)
+			if price == nil || price.Cmp(want) != 0 {
+				t.Errorf("got price %v, want %s", price, test.price)
+			}
+		})
+	}
+}
+
+func TestValuationVolume(t *testing.T) {
+	cfg := config.ValuationConfig{
+		Stablecoins: []string{"USDC"},
+		MaxAge:      24 * time.Hour,
+	}
+	valuation := newTestValuation(t, cfg, []testTrade{{"a", "OSMO", "USDC", 2, 1, 0}})
+	tests := []struct {
+		base   string
+		quote  string
+		volume string
+	}{
+		// the quote side is valued where possible
+		{"OSMO", "USDC", "30"},
+		{"JUNO", "OSMO", "60"},
+		{"OSMO", "JUNO", "20"},
+		{"JUNO", "EVMOS", ""},
+	}
+	for _, test := range tests {
+		volume := valuation.Volume(test.base, decimal.New(10, 0), test.quote, decimal.New(30, 0))
+		if test.volume == "" {
+			if volume != nil {
+				t.Errorf("%s/%s: got volume %s, want none", test.base, test.quote, volume.String())
+			}
+			continue
+		}
+		want, _ := new(decimal.Big).SetString(test.volume)
+		if volume == nil || volume.Cmp(want) != 0 {
+			t.Errorf("%s/%s: got volume %v, want %s", test.base, test.quote, volume, test.volume)
+		}
+	}
+}
+
+func TestValuationCandle(t *testing.T) {
+	cfg := config.ValuationConfig{
+		Stablecoins: []string{"USDC"},
+		MaxAge:      24 * time.Hour,
+	}
+	valuation := newTestValuation(t, cfg, []testTrade{{"a", "OSMO", "USDC", 2, 1, 0}})
+	candle := &trading.Candle{
+		BaseAsset:  "OSMO",
+		QuoteAsset: "USDC",
+		Start:      testNow.Truncate(time.Hour),
+		End:        testNow.Truncate(time.Hour).Add(time.Hour),
+	}
+	candle.BaseVolume.SetMantScale(10, 0)
+	candle.QuoteVolume.SetMantScale(20, 0)
+	valued := valuation.Candle(candle)
+	if valued.UsdVolume == nil || valued.UsdVolume.Cmp(decimal.New(20, 0)) != 0 {
+		t.Errorf("got current candle volume %v, want 20", valued.UsdVolume)
+	}
+	candle.Start = candle.Start.Add(-time.Hour)
+	candle.End = candle.End.Add(-time.Hour)
+	valued = valuation.Candle(candle)
+	if valued.UsdVolume != nil {
+		t.Errorf("got closed candle volume %s, want none", valued.UsdVolume.String())
+	}
+}
@@ -0,0 +1,121 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"indexer/exchange"
+	"indexer/trading"
+
+	"github.com/rs/zerolog"
+)
+
+type (
+	// Clock follows the time of the last replayed trade so candles are aligned to
+	// the recording rather than to the wall clock.
+	Clock struct {
+		mu  sync.RWMutex
+		now time.Time
+	}
+
+	Replayer struct {
+		exchange *exchange.MockExchange
+		trades   []*trading.Trade
+		clock    *Clock
+		speed    float64
+		logger   zerolog.Logger
+	}
+)
+
+func (c *Clock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.now
+}
+
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.After(c.now) {
+		c.now = now
+	}
+}
+
+// NewReplayer prepares trades for replay through a mock exchange. A speed of 1
+// replays in real time, 10 ten times faster, and 0 or less as fast as possible.
+func NewReplayer(e *exchange.MockExchange, trades []*trading.Trade, speed float64, logger zerolog.Logger) *Replayer {
+	sorted := append([]*trading.Trade{}, trades...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+	clock := &Clock{}
+	if len(sorted) > 0 {
+		clock.Set(sorted[0].Time)
+	}
+	return &Replayer{
+		exchange: e,
+		trades:   sorted,
+		clock:    clock,
+		speed:    speed,
+		logger:   logger.With().Str("replay", e.Name()).Logger(),
+	}
+}
+
+func (r *Replayer) Clock() *Clock {
+	return r.clock
+}
+
+func (r *Replayer) Run() {
+	for _, trade := range r.trades {
+		r.exchange.AddPairs(trade.Pair())
+	}
+	r.logger.Info().Int("num_trades", len(r.trades)).Float64("speed", r.speed).Msg("replay started")
+	var last time.Time
+	for i, trade := range r.trades {
+		if r.speed > 0 && i > 0 {
+			time.Sleep(time.Duration(float64(trade.Time.Sub(last)) / r.speed))
+		}
+		last = trade.Time
+		r.clock.Set(trade.Time)
+		r.exchange.PushTrade(trade)
+	}
+	r.logger.Info().Int("num_trades", len(r.trades)).Msg("replay finished")
+}
+
+// ReadTrades decodes one JSON trading.Trade per line, skipping blank lines.
+func ReadTrades(reader io.Reader) ([]*trading.Trade, error) {
+	trades := []*trading.Trade{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		trade := &trading.Trade{}
+		err := json.Unmarshal(b, trade)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trade on line %d: %v", line, err)
+		}
+		trades = append(trades, trade)
+	}
+	return trades, scanner.Err()
+}
+
+func ReadTradesFile(path string) ([]*trading.Trade, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTrades(f)
+}
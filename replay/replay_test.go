@@ -0,0 +1,173 @@
+package replay
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"indexer/config"
+	"indexer/exchange"
+	"indexer/store"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/ericlagergren/decimal"
+	"github.com/rs/zerolog"
+)
+
+var testPair = &token.Pair{Base: "ATOM", Quote: "USDC"}
+
+type expectedCandle struct {
+	start       string
+	open        string
+	high        string
+	low         string
+	close       string
+	baseVolume  string
+	quoteVolume string
+}
+
+// TestMain sets the config once, since the candles of earlier tests keep being
+// filled in the background.
+func TestMain(m *testing.M) {
+	config.Cfg.CandlesInterval = time.Minute
+	config.Cfg.CandlesPeriod = time.Hour
+	config.Cfg.AverageWindows = nil
+	config.Cfg.Staleness = config.StalenessConfig{MaxAge: time.Hour}
+	config.Cfg.Filter = config.FilterConfig{}
+	os.Exit(m.Run())
+}
+
+// replayFile replays a trades file through an exchange manager as fast as
+// possible and waits until the last trade has been added to the candles.
+func replayFile(t *testing.T, name string) *exchange.ExchangeManager {
+	t.Helper()
+	trades, err := ReadTradesFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zerolog.Nop()
+	mock := exchange.NewMockExchange("replay", store.NewMemoryStore("replay", logger), logger)
+	manager, err := exchange.NewExchangeManager(map[string]exchange.Exchange{"replay": mock}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer := NewReplayer(mock, trades, 0, logger)
+	manager.SetClock(replayer.Clock())
+	manager.Start()
+	replayer.Run()
+	last := trades[len(trades)-1].Time
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ticker, err := manager.Ticker("replay", testPair)
+		if err == nil && ticker.LastTrade.Equal(last) {
+			return manager
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("last trade at %s was not added to the candles", last)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func checkDecimal(t *testing.T, name string, got *decimal.Big, want string) {
+	t.Helper()
+	expected, ok := new(decimal.Big).SetString(want)
+	if !ok {
+		t.Fatalf("invalid expected %s %s", name, want)
+	}
+	if got.Cmp(expected) != 0 {
+		t.Errorf("got %s %s, want %s", name, got.String(), want)
+	}
+}
+
+func checkCandles(t *testing.T, got []*trading.Candle, want []expectedCandle) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d candles, want %d", len(got), len(want))
+	}
+	for i, candle := range got {
+		expected := want[i]
+		start, _ := time.Parse(time.RFC3339, expected.start)
+		if !candle.Start.Equal(start) {
+			t.Errorf("candle %d: got start %s, want %s", i, candle.Start.Format(time.RFC3339), expected.start)
+			continue
+		}
+		checkDecimal(t, expected.start+" open", &candle.Open, expected.open)
+		checkDecimal(t, expected.start+" high", &candle.High, expected.high)
+		checkDecimal(t, expected.start+" low", &candle.Low, expected.low)
+		checkDecimal(t, expected.start+" close", &candle.Close, expected.close)
+		checkDecimal(t, expected.start+" base volume", &candle.BaseVolume, expected.baseVolume)
+		checkDecimal(t, expected.start+" quote volume", &candle.QuoteVolume, expected.quoteVolume)
+	}
+}
+
+func readCandles(t *testing.T, manager *exchange.ExchangeManager, start string, end string) []*trading.Candle {
+	t.Helper()
+	startTime, _ := time.Parse(time.RFC3339, start)
+	endTime, _ := time.Parse(time.RFC3339, end)
+	var candles []*trading.Candle
+	err := manager.ReadCandles("replay", "", testPair, func(c *trading.Candles) {
+		candles = c.Range(startTime, endTime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return candles
+}
+
+func TestReplayCandlesAndTickers(t *testing.T) {
+	manager := replayFile(t, "trades.jsonl")
+	candles := readCandles(t, manager, "2024-01-01T00:00:00Z", "2024-01-01T00:05:00Z")
+	checkCandles(t, candles, []expectedCandle{
+		{"2024-01-01T00:00:00Z", "10", "10.1", "9.5", "9.5", "4", "39.1"},
+		// reversed trades are added in the orientation of the pair
+		{"2024-01-01T00:01:00Z", "11", "11", "10.5", "10.5", "2", "21.5"},
+		{"2024-01-01T00:02:00Z", "0", "0", "0", "0", "0", "0"},
+		{"2024-01-01T00:03:00Z", "0", "0", "0", "0", "0", "0"},
+		{"2024-01-01T00:04:00Z", "12", "12", "12", "12", "1", "12"},
+	})
+
+	ticker, err := manager.Ticker("replay", testPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDecimal(t, "ticker price", &ticker.Price, "12")
+	checkDecimal(t, "ticker base volume", &ticker.BaseVolume, "7")
+	checkDecimal(t, "ticker quote volume", &ticker.QuoteVolume, "72.6")
+	if ticker.Stale {
+		t.Errorf("ticker is stale as of the last replayed trade")
+	}
+	reversed, err := manager.Ticker("replay", testPair.Reversed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDecimal(t, "reversed ticker base volume", &reversed.BaseVolume, "72.6")
+	if reversed.BaseAsset != "USDC" || reversed.QuoteAsset != "ATOM" {
+		t.Errorf("got reversed ticker %s/%s, want USDC/ATOM", reversed.BaseAsset, reversed.QuoteAsset)
+	}
+}
+
+func TestReplayGapLongerThanPeriod(t *testing.T) {
+	manager := replayFile(t, "trades_gap.jsonl")
+	candles := readCandles(t, manager, "2024-01-01T00:00:00Z", "2024-01-01T04:00:00Z")
+	if len(candles) != 61 {
+		t.Fatalf("got %d candles, want the 61 of the last hour", len(candles))
+	}
+	// candles older than the period are reset rather than shifted into view
+	for _, candle := range candles[:60] {
+		if candle.BaseVolume.Sign() != 0 {
+			t.Errorf("candle at %s has volume %s, want none", candle.Start.Format(time.RFC3339), candle.BaseVolume.String())
+		}
+	}
+	checkCandles(t, candles[60:], []expectedCandle{
+		{"2024-01-01T03:00:00Z", "20", "20", "20", "20", "1", "20"},
+	})
+	ticker, err := manager.Ticker("replay", testPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDecimal(t, "ticker price", &ticker.Price, "20")
+	checkDecimal(t, "ticker base volume", &ticker.BaseVolume, "1")
+}
@@ -3,7 +3,6 @@ package store
 import (
 	"context"
 	"fmt"
-	"os"
 	"time"
 
 	"indexer/config"
@@ -16,10 +15,21 @@ import (
 	"github.com/rs/zerolog"
 )
 
+func init() {
+	Register("influxdb2", NewInfluxdb2Manager)
+}
+
 type (
+	Influxdb2Config struct {
+		Url          string `toml:"url"`
+		Token        string `toml:"token"`
+		Organization string `toml:"org"`
+	}
+
 	Influxdb2Manager struct {
 		client influxdb2.Client
 		url    string
+		org    string
 		stores map[string]*Influxdb2Store
 		logger zerolog.Logger
 	}
@@ -32,16 +42,15 @@ type (
 	}
 )
 
-func NewInfluxdb2Manager(url string, logger zerolog.Logger) (*Influxdb2Manager, error) {
+func NewInfluxdb2Manager(cfg *Influxdb2Config, logger zerolog.Logger) (StoreManager, error) {
 	influxLogger := logger.With().Str("backend", "influxdb2").Logger()
-	influxdbToken := os.Getenv(config.EnvInfluxdbToken)
-	if influxdbToken == "" {
+	if cfg.Token == "" {
 		influxLogger.Error().Str("env", config.EnvInfluxdbToken).Msg("missing required config variable")
 		return nil, fmt.Errorf("missing influxdb2 auth token")
 	}
 	client := influxdb2.NewClientWithOptions(
-		url,
-		influxdbToken,
+		cfg.Url,
+		cfg.Token,
 		influxdb2.DefaultOptions().
 			SetBatchSize(5).
 			SetFlushInterval(250).
@@ -50,7 +59,8 @@ func NewInfluxdb2Manager(url string, logger zerolog.Logger) (*Influxdb2Manager,
 	)
 	i := &Influxdb2Manager{
 		client: client,
-		url:    url,
+		url:    cfg.Url,
+		org:    cfg.Organization,
 		stores: map[string]*Influxdb2Store{},
 		logger: influxLogger,
 	}
@@ -61,7 +71,7 @@ func (i *Influxdb2Manager) Store(name string) (Store, error) {
 	store, ok := i.stores[name]
 	var err error
 	if !ok {
-		store, err = NewInfluxdb2Store(name, i.client, i.logger)
+		store, err = NewInfluxdb2Store(name, i.org, i.client, i.logger)
 		if err != nil {
 			return nil, err
 		}
@@ -109,11 +119,10 @@ func (i *Influxdb2Manager) Close() {
 	i.client.Close()
 }
 
-func NewInfluxdb2Store(name string, client influxdb2.Client, logger zerolog.Logger) (*Influxdb2Store, error) {
+func NewInfluxdb2Store(name string, org string, client influxdb2.Client, logger zerolog.Logger) (*Influxdb2Store, error) {
 	storeLogger := logger.With().Str("store", name).Logger()
-	cfg := config.Cfg.StoreConfig["influxdb2"]
-	writer := client.WriteAPI(cfg.Organization, name)
-	reader := client.QueryAPI(cfg.Organization)
+	writer := client.WriteAPI(org, name)
+	reader := client.QueryAPI(org)
 	errorsChannel := writer.Errors()
 	go func() {
 		for err := range errorsChannel {
@@ -135,7 +144,7 @@ func (i *Influxdb2Store) Name() string {
 }
 
 func (s *Influxdb2Store) SaveTrade(trade *trading.Trade) error {
-	id, err := uuid.NewRandom()
+	id, err := tradeId(trade)
 	if err != nil {
 		return err
 	}
@@ -144,11 +153,16 @@ func (s *Influxdb2Store) SaveTrade(trade *trading.Trade) error {
 		map[string]string{
 			"base_asset":  trade.Base.Symbol,
 			"quote_asset": trade.Quote.Symbol,
+			"pool":        trade.Pool,
 			"id":          id.String(), // ensures trades have unique tags
 		},
 		map[string]interface{}{
 			"base_volume":  trade.Base.Amount.String(),
 			"quote_volume": trade.Quote.Amount.String(),
+			"height":       trade.Height,
+			"tx_hash":      trade.TxHash,
+			"pool_type":    trade.PoolType,
+			"routed":       trade.Routed,
 		},
 		trade.Time,
 	)
@@ -180,6 +194,75 @@ func (s *Influxdb2Store) Trades(pair *token.Pair, start time.Time, end time.Time
 		s.logger.Error().Err(err).Msg("database query error")
 		return nil, err
 	}
+	return s.readTrades(res, pair), nil
+}
+
+// QueryTrades filters, orders and limits the trades in the database, so that
+// only the requested page is read.
+func (s *Influxdb2Store) QueryTrades(query *TradesQuery) ([]*trading.Trade, error) {
+	pair := query.Pair
+	poolFilter := ""
+	if query.Pool != "" {
+		// filter on the tag before pivoting so the storage engine does it
+		poolFilter = fmt.Sprintf(` and r.pool == "%s"`, query.Pool)
+	}
+	fluxQuery := fmt.Sprintf(
+		`from(bucket: "%s")
+			|> range(start: %s, stop: %s)
+			|> filter(fn: (r) => r._measurement == "trade" and ((r.base_asset == "%s" and r.quote_asset == "%s") or (r.base_asset == "%s" and r.quote_asset == "%s"))%s)
+			|> pivot(rowKey:["_time"], columnKey: ["_field"], valueColumn: "_value")
+			|> group()
+		`,
+		s.name,
+		query.Start.Format(time.RFC3339Nano),
+		query.End.Format(time.RFC3339Nano),
+		pair.Base,
+		pair.Quote,
+		pair.Quote,
+		pair.Base,
+		poolFilter,
+	)
+	if query.MinSize != nil {
+		fluxQuery += fmt.Sprintf(
+			`	|> filter(fn: (r) => (if r.base_asset == "%s" then float(v: r.base_volume) else float(v: r.quote_volume)) >= float(v: "%s"))
+		`,
+			pair.Base,
+			query.MinSize.String(),
+		)
+	}
+	// the id tag orders trades at the same time
+	fluxQuery += fmt.Sprintf(
+		`	|> sort(columns: ["_time", "id"], desc: %t)
+		`,
+		!query.Ascending,
+	)
+	if query.Limit > 0 {
+		fluxQuery += fmt.Sprintf(
+			`	|> limit(n: %d, offset: %d)
+		`,
+			query.Limit,
+			query.Offset,
+		)
+	}
+	fluxQuery += `	|> yield(name: "trade")
+		`
+	res, err := s.reader.Query(context.Background(), fluxQuery)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("database query error")
+		return nil, err
+	}
+	trades := s.readTrades(res, pair)
+	if query.Limit == 0 && query.Offset > 0 {
+		if query.Offset >= len(trades) {
+			return []*trading.Trade{}, nil
+		}
+		trades = trades[query.Offset:]
+	}
+	return trades, nil
+}
+
+// readTrades reads the trades of a query result in the orientation of pair.
+func (s *Influxdb2Store) readTrades(res *influxdb2api.QueryTableResult, pair *token.Pair) []*trading.Trade {
 	trades := []*trading.Trade{}
 	for res.Next() {
 		tradeBaseSymbol := fmt.Sprintf("%v", res.Record().ValueByKey("base_asset"))
@@ -230,7 +313,187 @@ func (s *Influxdb2Store) Trades(pair *token.Pair, start time.Time, end time.Time
 			Quote: *quote,
 			Time:  res.Record().Time().UTC(),
 		}
+		if height, ok := res.Record().ValueByKey("height").(int64); ok {
+			trade.Height = height
+		}
+		if txHash, ok := res.Record().ValueByKey("tx_hash").(string); ok {
+			trade.TxHash = txHash
+		}
+		if pool, ok := res.Record().ValueByKey("pool").(string); ok {
+			trade.Pool = pool
+		}
+		if poolType, ok := res.Record().ValueByKey("pool_type").(string); ok {
+			trade.PoolType = poolType
+		}
+		if routed, ok := res.Record().ValueByKey("routed").(bool); ok {
+			trade.Routed = routed
+		}
+		trades = append(trades, trade)
+	}
+	return trades
+}
+
+// SaveFlaggedTrade writes the trade to its own measurement, so that flagged
+// trades never show up among the trades candles are built from.
+func (s *Influxdb2Store) SaveFlaggedTrade(trade *trading.FlaggedTrade) error {
+	id, err := tradeId(&trade.Trade)
+	if err != nil {
+		return err
+	}
+	p := influxdb2.NewPoint(
+		"flagged_trade",
+		map[string]string{
+			"base_asset":  trade.Base.Symbol,
+			"quote_asset": trade.Quote.Symbol,
+			"pool":        trade.Pool,
+			"reason":      trade.Reason,
+			"id":          id.String(),
+		},
+		map[string]interface{}{
+			"base_volume":     trade.Base.Amount.String(),
+			"quote_volume":    trade.Quote.Amount.String(),
+			"height":          trade.Height,
+			"tx_hash":         trade.TxHash,
+			"pool_type":       trade.PoolType,
+			"routed":          trade.Routed,
+			"reference_price": trade.Reference.String(),
+			"deviation":       trade.Deviation,
+			"excluded":        trade.Excluded,
+		},
+		trade.Time,
+	)
+	s.writer.WritePoint(p)
+	s.logger.Trace().Str("base", trade.Base.Symbol).Str("quote", trade.Quote.Symbol).Msg("saving flagged trade")
+	return nil
+}
+
+func (s *Influxdb2Store) FlaggedTrades(start time.Time, end time.Time) ([]*trading.FlaggedTrade, error) {
+	fluxQuery := fmt.Sprintf(
+		`from(bucket: "%s")
+			|> range(start: %s, stop: %s)
+			|> filter(fn: (r) => r._measurement == "flagged_trade")
+			|> pivot(rowKey:["_time"], columnKey: ["_field"], valueColumn: "_value")
+			|> group()
+			|> sort(columns: ["_time"], desc: true)
+			|> yield(name: "flagged_trade")
+		`,
+		s.name,
+		start.Format(time.RFC3339),
+		end.Format(time.RFC3339),
+	)
+	res, err := s.reader.Query(context.Background(), fluxQuery)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("database query error")
+		return nil, err
+	}
+	trades := []*trading.FlaggedTrade{}
+	for res.Next() {
+		record := res.Record()
+		baseSymbol := fmt.Sprintf("%v", record.ValueByKey("base_asset"))
+		quoteSymbol := fmt.Sprintf("%v", record.ValueByKey("quote_asset"))
+		base, err := token.ParseToken(fmt.Sprintf("%v%s", record.ValueByKey("base_volume"), baseSymbol))
+		if err != nil {
+			s.logger.Error().Err(err).Str("symbol", baseSymbol).Msg("failed to parse flagged trade base token")
+			continue
+		}
+		quote, err := token.ParseToken(fmt.Sprintf("%v%s", record.ValueByKey("quote_volume"), quoteSymbol))
+		if err != nil {
+			s.logger.Error().Err(err).Str("symbol", quoteSymbol).Msg("failed to parse flagged trade quote token")
+			continue
+		}
+		trade := &trading.FlaggedTrade{
+			Trade: trading.Trade{
+				Base:  *base,
+				Quote: *quote,
+				Time:  record.Time().UTC(),
+			},
+		}
+		trade.TradePrice.Set(trade.Trade.Price())
+		if height, ok := record.ValueByKey("height").(int64); ok {
+			trade.Height = height
+		}
+		if txHash, ok := record.ValueByKey("tx_hash").(string); ok {
+			trade.TxHash = txHash
+		}
+		if pool, ok := record.ValueByKey("pool").(string); ok {
+			trade.Pool = pool
+		}
+		if poolType, ok := record.ValueByKey("pool_type").(string); ok {
+			trade.PoolType = poolType
+		}
+		if routed, ok := record.ValueByKey("routed").(bool); ok {
+			trade.Routed = routed
+		}
+		if reason, ok := record.ValueByKey("reason").(string); ok {
+			trade.Reason = reason
+		}
+		if reference, ok := record.ValueByKey("reference_price").(string); ok {
+			trade.Reference.SetString(reference)
+		}
+		if deviation, ok := record.ValueByKey("deviation").(float64); ok {
+			trade.Deviation = deviation
+		}
+		if excluded, ok := record.ValueByKey("excluded").(bool); ok {
+			trade.Excluded = excluded
+		}
 		trades = append(trades, trade)
 	}
+	if res.Err() != nil {
+		s.logger.Error().Err(res.Err()).Msg("database query error")
+		return nil, res.Err()
+	}
 	return trades, nil
 }
+
+func (s *Influxdb2Store) Checkpoint() (int64, error) {
+	fluxQuery := fmt.Sprintf(
+		`from(bucket: "%s")
+			|> range(start: 0)
+			|> filter(fn: (r) => r._measurement == "checkpoint" and r._field == "height")
+			|> last()
+		`,
+		s.name,
+	)
+	res, err := s.reader.Query(context.Background(), fluxQuery)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("database query error")
+		return 0, err
+	}
+	var height int64
+	for res.Next() {
+		value, ok := res.Record().Value().(int64)
+		if !ok {
+			return 0, fmt.Errorf("unexpected checkpoint value: %v", res.Record().Value())
+		}
+		height = value
+	}
+	if res.Err() != nil {
+		s.logger.Error().Err(res.Err()).Msg("database query error")
+		return 0, res.Err()
+	}
+	return height, nil
+}
+
+func (s *Influxdb2Store) SaveCheckpoint(height int64) error {
+	p := influxdb2.NewPoint(
+		"checkpoint",
+		map[string]string{},
+		map[string]interface{}{
+			"height": height,
+		},
+		time.Now().UTC(),
+	)
+	s.writer.WritePoint(p)
+	s.logger.Trace().Int64("height", height).Msg("saving checkpoint")
+	return nil
+}
+
+// tradeId derives the trade's unique tag from its transaction when known so that
+// trades replayed during a backfill overwrite the original points.
+func tradeId(trade *trading.Trade) (uuid.UUID, error) {
+	if trade.TxHash == "" {
+		return uuid.NewRandom()
+	}
+	name := fmt.Sprintf("%s/%s/%s", trade.TxHash, trade.Base.String(), trade.Quote.String())
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)), nil
+}
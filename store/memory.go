@@ -0,0 +1,196 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/rs/zerolog"
+)
+
+func init() {
+	Register("memory", NewMemoryManager)
+}
+
+type (
+	MemoryConfig struct{}
+
+	// MemoryManager keeps trades in process memory. Nothing is persisted, which
+	// makes it suitable for replays and tests.
+	MemoryManager struct {
+		mu     sync.Mutex
+		stores map[string]*MemoryStore
+		logger zerolog.Logger
+	}
+
+	MemoryStore struct {
+		mu         sync.RWMutex
+		name       string
+		trades     []*trading.Trade
+		flagged    []*trading.FlaggedTrade
+		checkpoint int64
+		logger     zerolog.Logger
+	}
+)
+
+func NewMemoryManager(cfg *MemoryConfig, logger zerolog.Logger) (StoreManager, error) {
+	m := &MemoryManager{
+		stores: map[string]*MemoryStore{},
+		logger: logger.With().Str("backend", "memory").Logger(),
+	}
+	return m, nil
+}
+
+func (m *MemoryManager) Store(name string) (Store, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	store, ok := m.stores[name]
+	if !ok {
+		store = NewMemoryStore(name, m.logger)
+		m.stores[name] = store
+	}
+	return store, nil
+}
+
+func (m *MemoryManager) Health() error {
+	return nil
+}
+
+func (m *MemoryManager) Close() {}
+
+func NewMemoryStore(name string, logger zerolog.Logger) *MemoryStore {
+	return &MemoryStore{
+		name:    name,
+		trades:  []*trading.Trade{},
+		flagged: []*trading.FlaggedTrade{},
+		logger:  logger.With().Str("store", name).Logger(),
+	}
+}
+
+func (s *MemoryStore) Name() string {
+	return s.name
+}
+
+func (s *MemoryStore) SaveTrade(trade *trading.Trade) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.trades), func(i int) bool {
+		return s.trades[i].Time.After(trade.Time)
+	})
+	s.trades = append(s.trades, nil)
+	copy(s.trades[i+1:], s.trades[i:])
+	s.trades[i] = trade
+	s.logger.Trace().Str("base", trade.Base.Symbol).Str("quote", trade.Quote.Symbol).Msg("saving trade")
+	return nil
+}
+
+func (s *MemoryStore) Trades(pair *token.Pair, start time.Time, end time.Time) ([]*trading.Trade, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reversed := pair.Reversed()
+	trades := []*trading.Trade{}
+	for i := len(s.trades) - 1; i >= 0; i-- {
+		trade := s.trades[i]
+		if !trade.Time.Before(end) {
+			continue
+		}
+		if trade.Time.Before(start) {
+			break
+		}
+		tradePair := trade.Pair()
+		if *tradePair == *pair {
+			trades = append(trades, trade)
+		} else if *tradePair == *reversed {
+			trades = append(trades, trade.Reversed())
+		}
+	}
+	return trades, nil
+}
+
+func (s *MemoryStore) QueryTrades(query *TradesQuery) ([]*trading.Trade, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	first := sort.Search(len(s.trades), func(i int) bool {
+		return !s.trades[i].Time.Before(query.Start)
+	})
+	last := sort.Search(len(s.trades), func(i int) bool {
+		return !s.trades[i].Time.Before(query.End)
+	})
+	reversed := query.Pair.Reversed()
+	trades := []*trading.Trade{}
+	skipped := 0
+	for n := 0; n < last-first; n++ {
+		i := last - 1 - n
+		if query.Ascending {
+			i = first + n
+		}
+		trade := s.trades[i]
+		tradePair := trade.Pair()
+		if *tradePair == *reversed {
+			trade = trade.Reversed()
+		} else if *tradePair != *query.Pair {
+			continue
+		}
+		if query.Pool != "" && trade.Pool != query.Pool {
+			continue
+		}
+		if query.MinSize != nil && trade.Base.Amount.Cmp(query.MinSize) < 0 {
+			continue
+		}
+		if skipped < query.Offset {
+			skipped++
+			continue
+		}
+		trades = append(trades, trade)
+		if query.Limit > 0 && len(trades) >= query.Limit {
+			break
+		}
+	}
+	return trades, nil
+}
+
+func (s *MemoryStore) SaveFlaggedTrade(trade *trading.FlaggedTrade) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.flagged), func(i int) bool {
+		return s.flagged[i].Time.After(trade.Time)
+	})
+	s.flagged = append(s.flagged, nil)
+	copy(s.flagged[i+1:], s.flagged[i:])
+	s.flagged[i] = trade
+	s.logger.Trace().Str("base", trade.Base.Symbol).Str("quote", trade.Quote.Symbol).Msg("saving flagged trade")
+	return nil
+}
+
+func (s *MemoryStore) FlaggedTrades(start time.Time, end time.Time) ([]*trading.FlaggedTrade, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	trades := []*trading.FlaggedTrade{}
+	for i := len(s.flagged) - 1; i >= 0; i-- {
+		trade := s.flagged[i]
+		if !trade.Time.Before(end) {
+			continue
+		}
+		if trade.Time.Before(start) {
+			break
+		}
+		trades = append(trades, trade)
+	}
+	return trades, nil
+}
+
+func (s *MemoryStore) Checkpoint() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkpoint, nil
+}
+
+func (s *MemoryStore) SaveCheckpoint(height int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoint = height
+	return nil
+}
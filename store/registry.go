@@ -0,0 +1,63 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"indexer/config"
+
+	"github.com/rs/zerolog"
+)
+
+type (
+	// Factory creates a store manager from its [store.<backend>] config section
+	// decoded into C.
+	Factory[C any] func(cfg *C, logger zerolog.Logger) (StoreManager, error)
+
+	factory func(logger zerolog.Logger) (StoreManager, error)
+)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]factory{}
+)
+
+func Register[C any](backend string, f Factory[C]) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	_, ok := factories[backend]
+	if ok {
+		panic(fmt.Sprintf("store backend registered twice: %s", backend))
+	}
+	config.RegisterStoreBackend(backend)
+	factories[backend] = func(logger zerolog.Logger) (StoreManager, error) {
+		cfg := new(C)
+		err := config.Cfg.DecodeStoreConfig(backend, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config for store backend %s: %v", backend, err)
+		}
+		return f(cfg, logger)
+	}
+}
+
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	backends := make([]string, 0, len(factories))
+	for backend := range factories {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	return backends
+}
+
+func NewStoreManager(backend string, logger zerolog.Logger) (StoreManager, error) {
+	factoriesMu.RLock()
+	f, ok := factories[backend]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported store backend: %s", backend)
+	}
+	return f(logger)
+}
@@ -1,13 +1,12 @@
 package store
 
 import (
-	"fmt"
 	"time"
 
 	"indexer/token"
 	"indexer/trading"
 
-	"github.com/rs/zerolog"
+	"github.com/ericlagergren/decimal"
 )
 
 type (
@@ -21,21 +20,88 @@ type (
 		Name() string
 		SaveTrade(*trading.Trade) error
 		Trades(pair *token.Pair, start time.Time, end time.Time) ([]*trading.Trade, error)
+		QueryTrades(query *TradesQuery) ([]*trading.Trade, error)
+		SaveFlaggedTrade(*trading.FlaggedTrade) error
+		FlaggedTrades(start time.Time, end time.Time) ([]*trading.FlaggedTrade, error)
+		Checkpoint() (int64, error)
+		SaveCheckpoint(height int64) error
+	}
+
+	// TradesQuery selects the trades of a pair in either orientation within
+	// [Start, End), returned in the orientation of Pair, optionally only those of
+	// a single pool. Trades at the same time
+	// are ordered consistently, so that Offset can skip those already seen at
+	// the first time of the range when paging.
+	TradesQuery struct {
+		Pair      *token.Pair
+		Start     time.Time
+		End       time.Time
+		Pool      string       // any pool if empty
+		MinSize   *decimal.Big // minimum base amount, ignored if nil
+		Ascending bool
+		Limit     int // no limit if zero
+		Offset    int
 	}
 )
 
-func NewStoreManager(backend string, url string, logger zerolog.Logger) (StoreManager, error) {
-	switch backend {
-	case "influxdb2":
-		return NewInfluxdb2Manager(url, logger)
-	default:
-		return nil, fmt.Errorf("unsupported store backend: %s", backend)
+// CandlesFromStore builds candles from the stored trades of a pair, leaving out
+// routed trades. The candles hold one interval more than the period, so the
+// trades of the oldest candle are loaded as well.
+func CandlesFromStore(s Store, pair *token.Pair, end time.Time, period time.Duration, interval time.Duration, windows ...time.Duration) (*trading.Candles, error) {
+	start := end.Add(-period - interval)
+	trades, err := s.Trades(pair, start, end)
+	if err != nil {
+		return nil, err
+	}
+	direct := []*trading.Trade{}
+	for _, trade := range trades {
+		if !trade.Routed {
+			direct = append(direct, trade)
+		}
 	}
+	return trading.NewCandles(pair, direct, interval, period, end, windows...)
 }
 
-func CandlesFromStore(s Store, pair *token.Pair, end time.Time, period time.Duration, interval time.Duration) (*trading.Candles, error) {
-	start := end.Add(-period)
-	trades, err := s.Trades(pair, start, end)
+// PairCandlesFromStore builds the candles of a pair as CandlesFromStore does,
+// along with those of every pool that traded it, from the same trades.
+func PairCandlesFromStore(s Store, pair *token.Pair, end time.Time, period time.Duration, interval time.Duration, windows ...time.Duration) (*trading.Candles, map[string]*trading.Candles, error) {
+	trades, err := s.Trades(pair, end.Add(-period-interval), end)
+	if err != nil {
+		return nil, nil, err
+	}
+	direct := []*trading.Trade{}
+	pooled := map[string][]*trading.Trade{}
+	for _, trade := range trades {
+		if trade.Routed {
+			continue
+		}
+		direct = append(direct, trade)
+		if trade.Pool != "" {
+			pooled[trade.Pool] = append(pooled[trade.Pool], trade)
+		}
+	}
+	candles, err := trading.NewCandles(pair, direct, interval, period, end, windows...)
+	if err != nil {
+		return nil, nil, err
+	}
+	pools := make(map[string]*trading.Candles, len(pooled))
+	for pool, poolTrades := range pooled {
+		pools[pool], err = trading.NewCandles(pair, poolTrades, interval, period, end)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return candles, pools, nil
+}
+
+// PoolCandlesFromStore builds candles from the stored trades of a single pool.
+func PoolCandlesFromStore(s Store, pool string, pair *token.Pair, end time.Time, period time.Duration, interval time.Duration) (*trading.Candles, error) {
+	trades, err := s.QueryTrades(&TradesQuery{
+		Pair:  pair,
+		Start: end.Add(-period - interval),
+		End:   end,
+		Pool:  pool,
+	})
 	if err != nil {
 		return nil, err
 	}
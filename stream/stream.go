@@ -0,0 +1,311 @@
+package stream
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"indexer/config"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	ChannelTrades  = "trades"
+	ChannelTickers = "tickers"
+	ChannelCandles = "candles"
+	ChannelStale   = "stale"
+
+	ClosedSlow = "slow consumer"
+)
+
+var Channels = map[string]struct{}{
+	ChannelTrades:  {},
+	ChannelTickers: {},
+	ChannelCandles: {},
+	ChannelStale:   {},
+}
+
+type (
+	// Broadcaster fans the events of every exchange out to the subscriptions of
+	// streaming clients. Publishing never blocks: a subscription whose buffer is
+	// full is closed instead.
+	Broadcaster struct {
+		mu            sync.RWMutex
+		cfg           config.StreamConfig
+		subscriptions map[*Subscription]struct{}
+		logger        zerolog.Logger
+	}
+
+	// Event is a trade, a ticker update, a closed candle or a change of
+	// staleness of an exchange pair.
+	Event struct {
+		Channel  string          `json:"channel"`
+		Exchange string          `json:"exchange"`
+		Pair     string          `json:"pair"`
+		Trade    *trading.Trade  `json:"trade,omitempty"`
+		Ticker   *trading.Ticker `json:"ticker,omitempty"`
+		Candle   *trading.Candle `json:"candle,omitempty"`
+		pair     token.Pair
+	}
+
+	// Topic selects the events of a channel on an exchange, for one pair in
+	// either orientation or for every pair if Pair is nil.
+	Topic struct {
+		Channel  string
+		Exchange string
+		Pair     *token.Pair
+	}
+
+	// Subscription receives the events matching its topics until it is closed,
+	// by the client or by the broadcaster when the client falls behind.
+	Subscription struct {
+		mu     sync.Mutex
+		events chan *Event
+		done   chan struct{}
+		topics map[string]*Topic
+		max    int
+		reason string
+	}
+)
+
+func NewBroadcaster(cfg config.StreamConfig, logger zerolog.Logger) *Broadcaster {
+	return &Broadcaster{
+		cfg:           cfg,
+		subscriptions: map[*Subscription]struct{}{},
+		logger:        logger.With().Str("component", "stream").Logger(),
+	}
+}
+
+func (b *Broadcaster) Heartbeat() time.Duration {
+	return b.cfg.Heartbeat
+}
+
+// Subscribe opens a subscription without topics, failing once the connection
+// limit is reached.
+func (b *Broadcaster) Subscribe() (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cfg.MaxConnections > 0 && len(b.subscriptions) >= b.cfg.MaxConnections {
+		return nil, fmt.Errorf("too many connections")
+	}
+	s := &Subscription{
+		events: make(chan *Event, b.cfg.Buffer),
+		done:   make(chan struct{}),
+		topics: map[string]*Topic{},
+		max:    b.cfg.MaxSubscriptions,
+	}
+	b.subscriptions[s] = struct{}{}
+	return s, nil
+}
+
+// Unsubscribe closes the subscription and stops delivering to it.
+func (b *Broadcaster) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	delete(b.subscriptions, s)
+	b.mu.Unlock()
+	s.close("")
+}
+
+func (b *Broadcaster) Publish(event *Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subscriptions {
+		if !s.deliver(event) {
+			b.logger.Warn().Int("buffer", b.cfg.Buffer).Msg("closing stream of slow consumer")
+		}
+	}
+}
+
+func NewTradeEvent(exchange string, trade *trading.Trade) *Event {
+	return newEvent(ChannelTrades, exchange, trade.Pair(), &Event{Trade: trade})
+}
+
+func NewTickerEvent(exchange string, ticker *trading.Ticker) *Event {
+	pair := &token.Pair{Base: ticker.BaseAsset, Quote: ticker.QuoteAsset}
+	return newEvent(ChannelTickers, exchange, pair, &Event{Ticker: ticker})
+}
+
+// NewStaleEvent reports that the pair of the ticker went stale or recovered,
+// as told by the ticker's Stale field.
+func NewStaleEvent(exchange string, ticker *trading.Ticker) *Event {
+	pair := &token.Pair{Base: ticker.BaseAsset, Quote: ticker.QuoteAsset}
+	return newEvent(ChannelStale, exchange, pair, &Event{Ticker: ticker})
+}
+
+func NewCandleEvent(exchange string, candle *trading.Candle) *Event {
+	pair := &token.Pair{Base: candle.BaseAsset, Quote: candle.QuoteAsset}
+	return newEvent(ChannelCandles, exchange, pair, &Event{Candle: candle})
+}
+
+func newEvent(channel string, exchange string, pair *token.Pair, event *Event) *Event {
+	event.Channel = channel
+	event.Exchange = exchange
+	event.Pair = pair.String()
+	event.pair = *pair
+	return event
+}
+
+func (e *Event) Reversed() *Event {
+	r := &Event{}
+	if e.Trade != nil {
+		r.Trade = e.Trade.Reversed()
+	}
+	if e.Ticker != nil {
+		r.Ticker = e.Ticker.Reversed()
+	}
+	if e.Candle != nil {
+		r.Candle = e.Candle.Reversed()
+	}
+	return newEvent(e.Channel, e.Exchange, e.pair.Reversed(), r)
+}
+
+// ParseTopic reads a topic of the form channel:exchange or
+// channel:exchange:BASE/QUOTE.
+func ParseTopic(s string) (*Topic, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid topic '%s'", s)
+	}
+	_, ok := Channels[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("invalid channel '%s'", parts[0])
+	}
+	topic := &Topic{
+		Channel:  parts[0],
+		Exchange: parts[1],
+	}
+	if len(parts) == 3 {
+		pair, err := token.PairFromString(parts[2])
+		if err != nil || pair.Base == "" || pair.Quote == "" {
+			return nil, fmt.Errorf("invalid pair '%s'", parts[2])
+		}
+		topic.Pair = pair
+	}
+	return topic, nil
+}
+
+func (t *Topic) String() string {
+	if t.Pair == nil {
+		return t.Channel + ":" + t.Exchange
+	}
+	return t.Channel + ":" + t.Exchange + ":" + t.Pair.String()
+}
+
+// match returns whether the event belongs to the topic, and whether it must be
+// reversed to follow the topic's orientation.
+func (t *Topic) match(event *Event) (bool, bool) {
+	if t.Channel != event.Channel || t.Exchange != event.Exchange {
+		return false, false
+	}
+	if t.Pair == nil || *t.Pair == event.pair {
+		return true, false
+	}
+	if *t.Pair == *event.pair.Reversed() {
+		return true, true
+	}
+	return false, false
+}
+
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+// Done is closed when the subscription is, with Reason telling why if it was
+// closed by the broadcaster.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscription) Reason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
+
+func (s *Subscription) Add(topic *Topic) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.topics[topic.String()]
+	if ok {
+		return nil
+	}
+	if s.max > 0 && len(s.topics) >= s.max {
+		return fmt.Errorf("too many subscriptions")
+	}
+	s.topics[topic.String()] = topic
+	return nil
+}
+
+func (s *Subscription) Remove(topic *Topic) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.topics, topic.String())
+}
+
+func (s *Subscription) Topics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	topics := make([]string, 0, len(s.topics))
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// deliver queues the event if a topic matches, returning false if the
+// subscription had to be closed because its buffer is full.
+func (s *Subscription) deliver(event *Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed() {
+		return true
+	}
+	// a topic naming the pair decides the orientation over one for all pairs
+	matched, reversed := false, false
+	for _, topic := range s.topics {
+		ok, r := topic.match(event)
+		if ok {
+			matched, reversed = true, r
+			if topic.Pair != nil {
+				break
+			}
+		}
+	}
+	if !matched {
+		return true
+	}
+	if reversed {
+		event = event.Reversed()
+	}
+	select {
+	case s.events <- event:
+		return true
+	default:
+		s.reason = ClosedSlow
+		close(s.done)
+		return false
+	}
+}
+
+func (s *Subscription) close(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed() {
+		return
+	}
+	s.reason = reason
+	close(s.done)
+}
+
+func (s *Subscription) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,207 @@
+package stream
+
+import (
+	"testing"
+
+	"indexer/config"
+	"indexer/token"
+	"indexer/trading"
+
+	"github.com/rs/zerolog"
+)
+
+func newTestBroadcaster(buffer int) *Broadcaster {
+	return NewBroadcaster(config.StreamConfig{
+		MaxConnections:   2,
+		MaxSubscriptions: 2,
+		Buffer:           buffer,
+	}, zerolog.Nop())
+}
+
+func newTestTrade(base string, quote string) *trading.Trade {
+	return &trading.Trade{
+		Base:  token.Token{Symbol: base},
+		Quote: token.Token{Symbol: quote},
+	}
+}
+
+func subscribe(t *testing.T, b *Broadcaster, topics ...string) *Subscription {
+	t.Helper()
+	s, err := b.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range topics {
+		parsed, err := ParseTopic(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Add(parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// received drains the events queued for the subscription, as channel,
+// exchange and pair.
+func received(s *Subscription) []string {
+	events := []string{}
+	for {
+		select {
+		case event := <-s.Events():
+			events = append(events, event.Channel+":"+event.Exchange+":"+event.Pair)
+		default:
+			return events
+		}
+	}
+}
+
+func checkReceived(t *testing.T, s *Subscription, want ...string) {
+	t.Helper()
+	got := received(s)
+	if len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got events %v, want %v", got, want)
+		}
+	}
+}
+
+func TestParseTopic(t *testing.T) {
+	tests := []struct {
+		topic string
+		valid bool
+	}{
+		{"trades:osmosis", true},
+		{"candles:fin:ATOM/USDC", true},
+		{"trades", false},
+		{"trades:", false},
+		{"orders:osmosis", false},
+		{"trades:osmosis:ATOM", false},
+		{"trades:osmosis:/USDC", false},
+	}
+	for _, test := range tests {
+		topic, err := ParseTopic(test.topic)
+		if test.valid != (err == nil) {
+			t.Errorf("%s: got error %v, want valid %t", test.topic, err, test.valid)
+			continue
+		}
+		if test.valid && topic.String() != test.topic {
+			t.Errorf("got topic %s, want %s", topic, test.topic)
+		}
+	}
+}
+
+func TestPublishMatchesTopics(t *testing.T) {
+	b := newTestBroadcaster(10)
+	s := subscribe(t, b, "trades:osmosis:ATOM/USDC", "tickers:fin")
+	b.Publish(NewTradeEvent("osmosis", newTestTrade("ATOM", "USDC")))
+	b.Publish(NewTradeEvent("osmosis", newTestTrade("USDC", "ATOM")))
+	b.Publish(NewTradeEvent("osmosis", newTestTrade("OSMO", "USDC")))
+	b.Publish(NewTradeEvent("fin", newTestTrade("ATOM", "USDC")))
+	b.Publish(NewTickerEvent("fin", &trading.Ticker{BaseAsset: "KUJI", QuoteAsset: "USDC"}))
+	b.Publish(NewCandleEvent("fin", &trading.Candle{BaseAsset: "KUJI", QuoteAsset: "USDC"}))
+	checkReceived(t, s,
+		"trades:osmosis:ATOM/USDC",
+		"trades:osmosis:ATOM/USDC",
+		"tickers:fin:KUJI/USDC",
+	)
+}
+
+func TestPublishFollowsPairTopicOrientation(t *testing.T) {
+	b := newTestBroadcaster(10)
+	s := subscribe(t, b, "trades:osmosis", "trades:osmosis:USDC/ATOM")
+	b.Publish(NewTradeEvent("osmosis", newTestTrade("ATOM", "USDC")))
+	b.Publish(NewTradeEvent("osmosis", newTestTrade("OSMO", "USDC")))
+	checkReceived(t, s, "trades:osmosis:USDC/ATOM", "trades:osmosis:OSMO/USDC")
+}
+
+func TestSubscribeLimits(t *testing.T) {
+	b := newTestBroadcaster(10)
+	first := subscribe(t, b)
+	subscribe(t, b)
+	_, err := b.Subscribe()
+	if err == nil {
+		t.Fatal("got a connection over the limit")
+	}
+	b.Unsubscribe(first)
+	subscribe(t, b)
+	_, err = b.Subscribe()
+	if err == nil {
+		t.Fatal("got a connection over the limit after unsubscribing")
+	}
+}
+
+func TestSubscriptionLimits(t *testing.T) {
+	b := newTestBroadcaster(10)
+	s := subscribe(t, b, "trades:osmosis", "tickers:osmosis")
+	candles, err := ParseTopic("candles:osmosis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Add(candles)
+	if err == nil {
+		t.Fatal("got a subscription over the limit")
+	}
+	trades, err := ParseTopic("trades:osmosis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Add(trades)
+	if err != nil {
+		t.Errorf("adding a topic again: %v", err)
+	}
+	s.Remove(trades)
+	err = s.Add(candles)
+	if err != nil {
+		t.Errorf("adding a topic after removing one: %v", err)
+	}
+}
+
+func TestPublishDropsSlowConsumer(t *testing.T) {
+	b := newTestBroadcaster(2)
+	slow := subscribe(t, b, "trades:osmosis")
+	other := subscribe(t, b, "trades:fin")
+	for i := 0; i < 3; i++ {
+		b.Publish(NewTradeEvent("osmosis", newTestTrade("ATOM", "USDC")))
+	}
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("slow consumer was not closed")
+	}
+	if slow.Reason() != ClosedSlow {
+		t.Errorf("got reason %q, want %q", slow.Reason(), ClosedSlow)
+	}
+	checkReceived(t, slow, "trades:osmosis:ATOM/USDC", "trades:osmosis:ATOM/USDC")
+	// closed subscriptions get no more events, the others carry on
+	b.Publish(NewTradeEvent("osmosis", newTestTrade("ATOM", "USDC")))
+	b.Publish(NewTradeEvent("fin", newTestTrade("ATOM", "USDC")))
+	checkReceived(t, slow)
+	checkReceived(t, other, "trades:fin:ATOM/USDC")
+	select {
+	case <-other.Done():
+		t.Fatal("other consumer was closed")
+	default:
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := newTestBroadcaster(10)
+	s := subscribe(t, b, "trades:osmosis")
+	b.Unsubscribe(s)
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("subscription was not closed")
+	}
+	if s.Reason() != "" {
+		t.Errorf("got reason %q, want none", s.Reason())
+	}
+	b.Publish(NewTradeEvent("osmosis", newTestTrade("ATOM", "USDC")))
+	checkReceived(t, s)
+}
@@ -0,0 +1,240 @@
+package token
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+type (
+	Asset struct {
+		Denom       string   `json:"denom"`
+		Symbol      string   `json:"symbol"`
+		Exponent    int      `json:"exponent"`
+		CoingeckoId string   `json:"coingecko_id,omitempty"`
+		Chain       string   `json:"chain,omitempty"`
+		Keywords    []string `json:"keywords,omitempty"`
+	}
+
+	AssetSource interface {
+		Load() ([]Asset, error)
+		String() string
+	}
+
+	// CachedAssetSource is a source that can return its last good assets from
+	// disk without going to the network.
+	CachedAssetSource interface {
+		AssetSource
+		LoadCache() ([]Asset, error)
+	}
+
+	// AssetListSource loads a cosmos chain-registry assetlist.json from a URL or a
+	// local file path. Assets fetched from a URL are written to the cache file,
+	// if set, along with the validators for conditional requests.
+	AssetListSource struct {
+		Location  string
+		cacheFile string
+		fetcher   *Fetcher
+		mu        sync.Mutex
+		assets    []Asset
+		logger    zerolog.Logger
+	}
+
+	assetListCache struct {
+		Location     string  `json:"location"`
+		ETag         string  `json:"etag,omitempty"`
+		LastModified string  `json:"last_modified,omitempty"`
+		Assets       []Asset `json:"assets"`
+	}
+
+	StaticAssetSource []Asset
+
+	chainRegistryAssetList struct {
+		ChainName string               `json:"chain_name"`
+		Assets    []chainRegistryAsset `json:"assets"`
+	}
+
+	chainRegistryAsset struct {
+		Base       string `json:"base"`
+		Symbol     string `json:"symbol"`
+		Display    string `json:"display"`
+		DenomUnits []struct {
+			Denom    string `json:"denom"`
+			Exponent int    `json:"exponent"`
+		} `json:"denom_units"`
+		CoingeckoId string   `json:"coingecko_id"`
+		Keywords    []string `json:"keywords"`
+		Traces      []struct {
+			Counterparty struct {
+				ChainName string `json:"chain_name"`
+			} `json:"counterparty"`
+		} `json:"traces"`
+	}
+)
+
+// Rebase converts an amount of base denom units to display units under the
+// asset's symbol.
+func (a *Asset) Rebase(t *Token) *Token {
+	return t.Rebase(t.Amount.Scale()+a.Exponent, a.Symbol)
+}
+
+func (s *AssetListSource) String() string {
+	return s.Location
+}
+
+func NewAssetListSource(location string, cacheFile string, logger zerolog.Logger) *AssetListSource {
+	return &AssetListSource{
+		Location:  location,
+		cacheFile: cacheFile,
+		fetcher:   NewFetcher(DefaultFetchTimeout),
+		logger:    logger,
+	}
+}
+
+func (s *AssetListSource) isUrl() bool {
+	return strings.HasPrefix(s.Location, "http://") || strings.HasPrefix(s.Location, "https://")
+}
+
+func (s *AssetListSource) Load() ([]Asset, error) {
+	if !s.isUrl() {
+		file, err := os.Open(s.Location)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return DecodeAssetList(file)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, modified, err := s.fetcher.Fetch(s.Location)
+	if err != nil {
+		return nil, err
+	}
+	if !modified {
+		if s.assets != nil {
+			return s.assets, nil
+		}
+		// validators without assets to go with them are useless, start over
+		s.fetcher.SetValidators("", "")
+		body, _, err = s.fetcher.Fetch(s.Location)
+		if err != nil {
+			return nil, err
+		}
+	}
+	assets, err := DecodeAssetList(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.assets = assets
+	if s.cacheFile != "" {
+		err = s.saveCache()
+		if err != nil {
+			s.logger.Warn().Err(err).Str("file", s.cacheFile).Msg("failed to cache asset list")
+		}
+	}
+	return assets, nil
+}
+
+// LoadCache returns the assets saved by the last successful fetch. Local files
+// are read directly.
+func (s *AssetListSource) LoadCache() ([]Asset, error) {
+	if !s.isUrl() {
+		return s.Load()
+	}
+	if s.cacheFile == "" {
+		return nil, fmt.Errorf("no cache file configured")
+	}
+	b, err := os.ReadFile(s.cacheFile)
+	if err != nil {
+		return nil, err
+	}
+	cache := assetListCache{}
+	err = json.Unmarshal(b, &cache)
+	if err != nil {
+		return nil, err
+	}
+	if cache.Location != s.Location {
+		return nil, fmt.Errorf("cache is for %s", cache.Location)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.assets = cache.Assets
+	s.fetcher.SetValidators(cache.ETag, cache.LastModified)
+	return cache.Assets, nil
+}
+
+func (s *AssetListSource) saveCache() error {
+	etag, lastModified := s.fetcher.Validators()
+	b, err := json.Marshal(assetListCache{
+		Location:     s.Location,
+		ETag:         etag,
+		LastModified: lastModified,
+		Assets:       s.assets,
+	})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.cacheFile), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp := s.cacheFile + ".tmp"
+	err = os.WriteFile(tmp, b, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.cacheFile)
+}
+
+// DecodeAssetList reads a chain-registry assetlist.json. Assets without a
+// display denom unit are skipped since their amounts cannot be rebased.
+func DecodeAssetList(reader io.Reader) ([]Asset, error) {
+	root := chainRegistryAssetList{}
+	err := json.NewDecoder(reader).Decode(&root)
+	if err != nil {
+		return nil, err
+	}
+	if len(root.Assets) == 0 {
+		return nil, fmt.Errorf("asset list is empty")
+	}
+	assets := make([]Asset, 0, len(root.Assets))
+	for _, a := range root.Assets {
+		exponent := -1
+		for _, unit := range a.DenomUnits {
+			if unit.Denom == a.Display {
+				exponent = unit.Exponent
+			}
+		}
+		if exponent < 0 {
+			continue
+		}
+		chain := root.ChainName
+		if len(a.Traces) > 0 && a.Traces[len(a.Traces)-1].Counterparty.ChainName != "" {
+			chain = a.Traces[len(a.Traces)-1].Counterparty.ChainName
+		}
+		assets = append(assets, Asset{
+			Denom:       a.Base,
+			Symbol:      a.Symbol,
+			Exponent:    exponent,
+			CoingeckoId: a.CoingeckoId,
+			Chain:       chain,
+			Keywords:    a.Keywords,
+		})
+	}
+	return assets, nil
+}
+
+func (s StaticAssetSource) String() string {
+	return "static"
+}
+
+func (s StaticAssetSource) Load() ([]Asset, error) {
+	return s, nil
+}
@@ -0,0 +1,73 @@
+package token
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DefaultFetchTimeout = 30 * time.Second
+
+// Fetcher makes conditional GET requests, sending the validators of the last
+// successful response so unchanged documents are not downloaded again.
+type Fetcher struct {
+	client       *http.Client
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+}
+
+func NewFetcher(timeout time.Duration) *Fetcher {
+	return &Fetcher{
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Fetch returns the response body, or modified false if the server reports the
+// document unchanged since the last fetch.
+func (f *Fetcher) Fetch(url string) (body []byte, modified bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	etag, lastModified := f.Validators()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("unexpected status fetching %s: %s", url, res.Status)
+	}
+	body, err = io.ReadAll(res.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	f.SetValidators(res.Header.Get("ETag"), res.Header.Get("Last-Modified"))
+	return body, true, nil
+}
+
+func (f *Fetcher) Validators() (etag string, lastModified string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.etag, f.lastModified
+}
+
+func (f *Fetcher) SetValidators(etag string, lastModified string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.etag = etag
+	f.lastModified = lastModified
+}
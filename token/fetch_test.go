@@ -0,0 +1,177 @@
+package token
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	testEtag         = `"v1"`
+	testLastModified = "Mon, 01 Jan 2024 00:00:00 GMT"
+	testAssetList    = `{
+		"chain_name": "osmosis",
+		"assets": [{
+			"base": "uosmo",
+			"symbol": "OSMO",
+			"display": "osmo",
+			"denom_units": [{"denom": "uosmo", "exponent": 0}, {"denom": "osmo", "exponent": 6}]
+		}]
+	}`
+)
+
+// assetListServer serves testAssetList with validators, answering requests that
+// send them back with 304 Not Modified. It records how each request was
+// answered.
+type assetListServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+}
+
+func newAssetListServer(t *testing.T) *assetListServer {
+	s := &assetListServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		if r.Header.Get("If-None-Match") == testEtag && r.Header.Get("If-Modified-Since") == testLastModified {
+			status = http.StatusNotModified
+		}
+		s.mu.Lock()
+		s.statuses = append(s.statuses, status)
+		s.mu.Unlock()
+		w.Header().Set("ETag", testEtag)
+		w.Header().Set("Last-Modified", testLastModified)
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(testAssetList))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *assetListServer) checkStatuses(t *testing.T, statuses ...int) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.statuses) != len(statuses) {
+		t.Fatalf("got responses %v, want %v", s.statuses, statuses)
+	}
+	for i := range statuses {
+		if s.statuses[i] != statuses[i] {
+			t.Fatalf("got responses %v, want %v", s.statuses, statuses)
+		}
+	}
+}
+
+func TestFetcherNotModified(t *testing.T) {
+	server := newAssetListServer(t)
+	fetcher := NewFetcher(DefaultFetchTimeout)
+	body, modified, err := fetcher.Fetch(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified || string(body) != testAssetList {
+		t.Fatalf("got modified %t and %d bytes on the first fetch", modified, len(body))
+	}
+	etag, lastModified := fetcher.Validators()
+	if etag != testEtag || lastModified != testLastModified {
+		t.Errorf("got validators %s and %s", etag, lastModified)
+	}
+	body, modified, err = fetcher.Fetch(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modified || body != nil {
+		t.Errorf("got modified %t and %d bytes on the second fetch", modified, len(body))
+	}
+	server.checkStatuses(t, http.StatusOK, http.StatusNotModified)
+}
+
+func TestFetcherUnexpectedStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	_, _, err := NewFetcher(DefaultFetchTimeout).Fetch(server.URL)
+	if err == nil {
+		t.Error("got no error for 404")
+	}
+}
+
+func checkAssetList(t *testing.T, assets []Asset) {
+	t.Helper()
+	if len(assets) != 1 || assets[0].Denom != "uosmo" || assets[0].Symbol != "OSMO" || assets[0].Exponent != 6 {
+		t.Fatalf("got assets %v", assets)
+	}
+}
+
+func TestAssetListSourceCache(t *testing.T) {
+	server := newAssetListServer(t)
+	cacheFile := filepath.Join(t.TempDir(), "assets", "osmosis.json")
+	source := NewAssetListSource(server.URL, cacheFile, zerolog.Nop())
+	assets, err := source.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAssetList(t, assets)
+	// unchanged lists are served from memory
+	assets, err = source.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAssetList(t, assets)
+	_, err = os.Stat(cacheFile + ".tmp")
+	if !os.IsNotExist(err) {
+		t.Errorf("temporary cache file left behind: %v", err)
+	}
+
+	// a restarted source reads the cache and sends its validators
+	restarted := NewAssetListSource(server.URL, cacheFile, zerolog.Nop())
+	registry := NewAssetRegistry([]AssetSource{restarted}, nil, zerolog.Nop())
+	err = registry.LoadCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset, ok := registry.Asset("uosmo")
+	if !ok || asset.Symbol != "OSMO" {
+		t.Fatalf("got %v for uosmo from the cache", asset)
+	}
+	err = registry.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset, ok = registry.Asset("uosmo")
+	if !ok || asset.Symbol != "OSMO" {
+		t.Fatalf("got %v for uosmo after refreshing", asset)
+	}
+	server.checkStatuses(t, http.StatusOK, http.StatusNotModified, http.StatusNotModified)
+}
+
+func TestAssetListSourceCacheForOtherLocation(t *testing.T) {
+	server := newAssetListServer(t)
+	cacheFile := filepath.Join(t.TempDir(), "osmosis.json")
+	_, err := NewAssetListSource(server.URL, cacheFile, zerolog.Nop()).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewAssetListSource(server.URL+"/other", cacheFile, zerolog.Nop()).LoadCache()
+	if err == nil {
+		t.Error("got the cache of another location")
+	}
+}
+
+func TestAssetListSourceValidatorsWithoutAssets(t *testing.T) {
+	server := newAssetListServer(t)
+	source := NewAssetListSource(server.URL, "", zerolog.Nop())
+	source.fetcher.SetValidators(testEtag, testLastModified)
+	assets, err := source.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAssetList(t, assets)
+	server.checkStatuses(t, http.StatusNotModified, http.StatusOK)
+}
@@ -0,0 +1,252 @@
+package token
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+type (
+	// AssetRegistry maps denoms to asset metadata merged from its sources, later
+	// sources taking precedence. Refreshes build a new index and swap it in
+	// atomically, so lookups never block and never see a partial list.
+	AssetRegistry struct {
+		sources   []AssetSource
+		overrides map[string]string
+		extraMu   sync.Mutex
+		extra     map[string]Asset
+		index     atomic.Pointer[assetIndex]
+		logger    zerolog.Logger
+	}
+
+	assetIndex struct {
+		denoms  map[string]*Asset
+		symbols map[string]*Asset
+		assets  []*Asset
+	}
+)
+
+// NewAssetRegistry creates an empty registry. Overrides map denoms to the symbol
+// to use instead of the one given by the sources.
+func NewAssetRegistry(sources []AssetSource, overrides map[string]string, logger zerolog.Logger) *AssetRegistry {
+	r := &AssetRegistry{
+		sources:   sources,
+		overrides: overrides,
+		extra:     map[string]Asset{},
+		logger:    logger,
+	}
+	r.index.Store(&assetIndex{
+		denoms:  map[string]*Asset{},
+		symbols: map[string]*Asset{},
+	})
+	return r
+}
+
+// Refresh reloads every source. The current assets are kept if any source fails.
+func (r *AssetRegistry) Refresh() error {
+	merged := map[string]Asset{}
+	for _, source := range r.sources {
+		assets, err := source.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load assets from %s: %v", source, err)
+		}
+		for _, asset := range assets {
+			merged[asset.Denom] = asset
+		}
+	}
+	r.store(merged)
+	return nil
+}
+
+// LoadCache fills the registry from the cached copies of its sources, so trades
+// can be mapped before the first refresh reaches the network. Sources without a
+// usable cache are skipped.
+func (r *AssetRegistry) LoadCache() error {
+	merged := map[string]Asset{}
+	loaded := 0
+	for _, source := range r.sources {
+		var assets []Asset
+		var err error
+		cached, ok := source.(CachedAssetSource)
+		if ok {
+			assets, err = cached.LoadCache()
+		} else {
+			assets, err = source.Load()
+		}
+		if err != nil {
+			r.logger.Debug().Err(err).Str("source", source.String()).Msg("no cached assets")
+			continue
+		}
+		for _, asset := range assets {
+			merged[asset.Denom] = asset
+		}
+		loaded++
+	}
+	if loaded == 0 {
+		return fmt.Errorf("no cached assets")
+	}
+	r.store(merged)
+	return nil
+}
+
+func (r *AssetRegistry) store(merged map[string]Asset) {
+	r.extraMu.Lock()
+	defer r.extraMu.Unlock()
+	for denom, asset := range r.extra {
+		_, ok := merged[denom]
+		if !ok {
+			merged[denom] = asset
+		}
+	}
+	r.index.Store(r.build(merged))
+}
+
+// Add registers assets learned outside of the sources, e.g. from chain queries.
+// They are kept across refreshes unless a source lists the same denom.
+func (r *AssetRegistry) Add(assets ...Asset) {
+	r.extraMu.Lock()
+	defer r.extraMu.Unlock()
+	current := r.index.Load()
+	merged := make(map[string]Asset, len(current.assets)+len(assets))
+	for _, asset := range current.assets {
+		merged[asset.Denom] = *asset
+	}
+	for _, asset := range assets {
+		r.extra[asset.Denom] = asset
+		merged[asset.Denom] = asset
+	}
+	r.index.Store(r.build(merged))
+}
+
+func (r *AssetRegistry) build(merged map[string]Asset) *assetIndex {
+	denoms := make([]string, 0, len(merged))
+	for denom := range merged {
+		denoms = append(denoms, denom)
+	}
+	sort.Strings(denoms)
+	index := &assetIndex{
+		denoms:  make(map[string]*Asset, len(merged)),
+		symbols: make(map[string]*Asset, len(merged)),
+		assets:  make([]*Asset, 0, len(merged)),
+	}
+	bySymbol := map[string][]*Asset{}
+	for _, denom := range denoms {
+		asset := merged[denom]
+		symbol, ok := r.overrides[denom]
+		if ok {
+			asset.Symbol = symbol
+		}
+		index.denoms[denom] = &asset
+		// cw20 tokens are listed as cw20:<address> but appear in events by address
+		if address := strings.TrimPrefix(denom, "cw20:"); address != denom {
+			index.denoms[address] = &asset
+		}
+		index.assets = append(index.assets, &asset)
+		bySymbol[asset.Symbol] = append(bySymbol[asset.Symbol], &asset)
+	}
+	symbols := make([]string, 0, len(bySymbol))
+	for symbol := range bySymbol {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	renamed := []*Asset{}
+	for _, symbol := range symbols {
+		assets := bySymbol[symbol]
+		keep := r.symbolOwner(assets)
+		index.symbols[symbol] = assets[keep]
+		for i, asset := range assets {
+			if i != keep {
+				renamed = append(renamed, asset)
+			}
+		}
+	}
+	for _, asset := range renamed {
+		symbol := asset.Symbol
+		asset.Symbol = disambiguate(asset, index.symbols)
+		index.symbols[asset.Symbol] = asset
+		r.logger.Warn().
+			Str("denom", asset.Denom).
+			Str("symbol", symbol).
+			Str("renamed", asset.Symbol).
+			Str("owner", index.symbols[symbol].Denom).
+			Msg("renamed asset with duplicate symbol, set a symbol override to silence")
+	}
+	return index
+}
+
+// symbolOwner picks the asset that keeps a shared symbol: one with an explicit
+// override, then one native to the chain, then the lowest denom.
+func (r *AssetRegistry) symbolOwner(assets []*Asset) int {
+	owner := 0
+	rank := func(asset *Asset) int {
+		_, ok := r.overrides[asset.Denom]
+		if ok {
+			return 0
+		}
+		if !strings.HasPrefix(asset.Denom, "ibc/") {
+			return 1
+		}
+		return 2
+	}
+	for i := 1; i < len(assets); i++ {
+		if rank(assets[i]) < rank(assets[owner]) {
+			owner = i
+		}
+	}
+	return owner
+}
+
+// disambiguate suffixes a symbol with the chain the asset came from, falling
+// back to part of its denom if that is not unique either.
+func disambiguate(asset *Asset, taken map[string]*Asset) string {
+	if asset.Chain != "" {
+		symbol := asset.Symbol + "." + asset.Chain
+		_, ok := taken[symbol]
+		if !ok {
+			return symbol
+		}
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(asset.Denom, "ibc/"), "cw20:")
+	for n := 6; ; n++ {
+		if n >= len(id) {
+			return asset.Symbol + "." + id
+		}
+		symbol := asset.Symbol + "." + id[:n]
+		_, ok := taken[symbol]
+		if !ok {
+			return symbol
+		}
+	}
+}
+
+func (r *AssetRegistry) Asset(denom string) (*Asset, bool) {
+	asset, ok := r.index.Load().denoms[denom]
+	return asset, ok
+}
+
+func (r *AssetRegistry) AssetBySymbol(symbol string) (*Asset, bool) {
+	asset, ok := r.index.Load().symbols[symbol]
+	return asset, ok
+}
+
+// Assets lists every asset ordered by denom.
+func (r *AssetRegistry) Assets() []*Asset {
+	return r.index.Load().assets
+}
+
+func (r *AssetRegistry) Len() int {
+	return len(r.index.Load().assets)
+}
+
+// Rebase converts a token in base denom units to display units of its asset.
+func (r *AssetRegistry) Rebase(t *Token) (*Token, error) {
+	asset, ok := r.Asset(t.Symbol)
+	if !ok {
+		return nil, fmt.Errorf("unlisted denom %s", t.Symbol)
+	}
+	return asset.Rebase(t), nil
+}
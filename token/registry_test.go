@@ -0,0 +1,104 @@
+package token
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+var testUsdcAssets = StaticAssetSource{
+	{Denom: "uusdc", Symbol: "USDC", Exponent: 6, Chain: "noble"},
+	{Denom: "ibc/987654321", Symbol: "USDC", Exponent: 6, Chain: "axelar"},
+	{Denom: "ibc/ABCDEF123", Symbol: "USDC", Exponent: 6, Chain: "axelar"},
+	{Denom: "cw20:kujira1token", Symbol: "TOKEN", Exponent: 6, Chain: "kujira"},
+}
+
+func TestAssetRegistrySymbols(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		symbols   map[string]string
+	}{
+		{
+			name: "native asset keeps the symbol",
+			symbols: map[string]string{
+				"uusdc":         "USDC",
+				"ibc/987654321": "USDC.axelar",
+				"ibc/ABCDEF123": "USDC.ABCDEF",
+			},
+		},
+		{
+			name:      "override keeps the symbol",
+			overrides: map[string]string{"ibc/ABCDEF123": "USDC"},
+			symbols: map[string]string{
+				"ibc/ABCDEF123": "USDC",
+				"uusdc":         "USDC.noble",
+				"ibc/987654321": "USDC.axelar",
+			},
+		},
+		{
+			name:      "override renames",
+			overrides: map[string]string{"ibc/987654321": "axlUSDC"},
+			symbols: map[string]string{
+				"uusdc":         "USDC",
+				"ibc/987654321": "axlUSDC",
+				"ibc/ABCDEF123": "USDC.axelar",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := NewAssetRegistry([]AssetSource{testUsdcAssets}, test.overrides, zerolog.Nop())
+			err := registry.Refresh()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for denom, symbol := range test.symbols {
+				asset, ok := registry.Asset(denom)
+				if !ok {
+					t.Fatalf("%s not found", denom)
+				}
+				if asset.Symbol != symbol {
+					t.Errorf("got %s for %s, want %s", asset.Symbol, denom, symbol)
+				}
+				asset, ok = registry.AssetBySymbol(symbol)
+				if !ok || asset.Denom != denom {
+					t.Errorf("symbol %s does not map back to %s", symbol, denom)
+				}
+			}
+		})
+	}
+}
+
+func TestAssetRegistryCw20Address(t *testing.T) {
+	registry := NewAssetRegistry([]AssetSource{testUsdcAssets}, nil, zerolog.Nop())
+	err := registry.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset, ok := registry.Asset("kujira1token")
+	if !ok || asset.Symbol != "TOKEN" {
+		t.Errorf("got %v for the cw20 address, want TOKEN", asset)
+	}
+}
+
+func TestAssetRegistryKeepsAddedAssets(t *testing.T) {
+	registry := NewAssetRegistry([]AssetSource{testUsdcAssets}, nil, zerolog.Nop())
+	registry.Add(
+		Asset{Denom: "uatom", Symbol: "ATOM", Exponent: 6},
+		Asset{Denom: "uusdc", Symbol: "NOBLEUSDC", Exponent: 6},
+	)
+	err := registry.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset, ok := registry.Asset("uatom")
+	if !ok || asset.Symbol != "ATOM" {
+		t.Errorf("got %v for uatom, want ATOM", asset)
+	}
+	// the sources take precedence over added assets
+	asset, ok = registry.Asset("uusdc")
+	if !ok || asset.Symbol != "USDC" {
+		t.Errorf("got %v for uusdc, want USDC", asset)
+	}
+}
@@ -0,0 +1,170 @@
+package trading
+
+import (
+	gomath "math"
+	"time"
+
+	"indexer/math"
+
+	"github.com/ericlagergren/decimal"
+)
+
+type (
+	// Average keeps the time-weighted average price and exponential moving
+	// average of a pair over a window, updated with each trade instead of being
+	// recomputed from candles. The price is taken to hold from one trade until
+	// the next. Both orientations are tracked since the average of inverted
+	// prices is not the inverse of the average.
+	Average struct {
+		Window   time.Duration
+		segments []averageSegment
+		since    time.Time
+		price    averageSeries
+		inverse  averageSeries
+	}
+
+	AverageValue struct {
+		Window string      `json:"window"`
+		Twap   decimal.Big `json:"twap"`
+		Ema    decimal.Big `json:"ema"`
+		Time   time.Time   `json:"time"`
+	}
+
+	averageSegment struct {
+		start   time.Time
+		end     time.Time
+		price   decimal.Big
+		inverse decimal.Big
+	}
+
+	// averageSeries holds the running sum of price times seconds over the
+	// window's segments, and the EMA as of since.
+	averageSeries struct {
+		last decimal.Big
+		sum  decimal.Big
+		ema  decimal.Big
+	}
+)
+
+func NewAverage(window time.Duration) *Average {
+	return &Average{Window: window}
+}
+
+func (a *Average) Push(price *decimal.Big, t time.Time) {
+	if price.Sign() <= 0 || t.Before(a.since) {
+		return
+	}
+	inverse := new(decimal.Big).Quo(math.One, price)
+	if a.since.IsZero() {
+		a.since = t
+		a.price.last.Set(price)
+		a.price.ema.Set(price)
+		a.inverse.last.Set(inverse)
+		a.inverse.ema.Set(inverse)
+		return
+	}
+	segment := averageSegment{start: a.since, end: t}
+	segment.price.Set(&a.price.last)
+	segment.inverse.Set(&a.inverse.last)
+	a.segments = append(a.segments, segment)
+	a.price.advance(price, a.since, t, a.Window)
+	a.inverse.advance(inverse, a.since, t, a.Window)
+	a.since = t
+	a.Evict(t)
+}
+
+// Evict drops the segments that ended before the window preceding now.
+func (a *Average) Evict(now time.Time) {
+	cutoff := now.Add(-a.Window)
+	n := 0
+	for n < len(a.segments) && !a.segments[n].end.After(cutoff) {
+		segment := &a.segments[n]
+		a.price.remove(&segment.price, segment.start, segment.end)
+		a.inverse.remove(&segment.inverse, segment.start, segment.end)
+		n++
+	}
+	if n > 0 {
+		a.segments = append(a.segments[:0], a.segments[n:]...)
+	}
+}
+
+// Value returns the averages as of now, which must not be before the last
+// trade, or false if there was no trade yet.
+func (a *Average) Value(now time.Time, reversed bool) (*AverageValue, bool) {
+	if a.since.IsZero() || now.Before(a.since) {
+		return nil, false
+	}
+	series, segmentPrice := &a.price, func(s *averageSegment) *decimal.Big { return &s.price }
+	if reversed {
+		series, segmentPrice = &a.inverse, func(s *averageSegment) *decimal.Big { return &s.inverse }
+	}
+	value := &AverageValue{
+		Window: a.Window.String(),
+		Time:   now,
+	}
+	cutoff := now.Add(-a.Window)
+	start := a.since
+	if len(a.segments) > 0 {
+		start = a.segments[0].start
+	}
+	sum := new(decimal.Big).Set(&series.sum)
+	sum.Add(sum, weigh(&series.last, a.since, now))
+	// segments evicted lazily may still reach back past the window
+	for i := range a.segments {
+		segment := &a.segments[i]
+		if !segment.start.Before(cutoff) {
+			break
+		}
+		end := segment.end
+		if end.After(cutoff) {
+			end = cutoff
+		}
+		sum.Sub(sum, weigh(segmentPrice(segment), segment.start, end))
+	}
+	if start.Before(cutoff) {
+		start = cutoff
+	}
+	if a.since.Before(cutoff) {
+		// no trade within the window, the last price held throughout
+		sum = weigh(&series.last, cutoff, now)
+		start = cutoff
+	}
+	covered := now.Sub(start)
+	if covered <= 0 {
+		value.Twap.Set(&series.last)
+	} else {
+		value.Twap.Quo(sum, seconds(covered))
+	}
+	value.Ema.Set(decay(&series.ema, &series.last, a.since, now, a.Window))
+	return value, true
+}
+
+// advance closes the segment from since to t at the last price and moves on to
+// price.
+func (s *averageSeries) advance(price *decimal.Big, since time.Time, t time.Time, window time.Duration) {
+	s.sum.Add(&s.sum, weigh(&s.last, since, t))
+	s.ema.Set(decay(&s.ema, &s.last, since, t, window))
+	s.last.Set(price)
+}
+
+func (s *averageSeries) remove(price *decimal.Big, start time.Time, end time.Time) {
+	s.sum.Sub(&s.sum, weigh(price, start, end))
+}
+
+func weigh(price *decimal.Big, start time.Time, end time.Time) *decimal.Big {
+	weight := seconds(end.Sub(start))
+	return weight.Mul(weight, price)
+}
+
+// decay moves the ema towards the price held from since to t, with the window
+// as time constant.
+func decay(ema *decimal.Big, price *decimal.Big, since time.Time, t time.Time, window time.Duration) *decimal.Big {
+	factor := new(decimal.Big).SetFloat64(gomath.Exp(-t.Sub(since).Seconds() / window.Seconds()))
+	result := new(decimal.Big).Sub(ema, price)
+	result.Mul(result, factor)
+	return result.Add(result, price)
+}
+
+func seconds(d time.Duration) *decimal.Big {
+	return decimal.New(int64(d), 9)
+}
@@ -0,0 +1,124 @@
+package trading
+
+import (
+	gomath "math"
+	"testing"
+	"time"
+
+	"github.com/ericlagergren/decimal"
+)
+
+const averageWindow = 10 * time.Minute
+
+// averageTrade is a price at an offset in seconds from testStart.
+type averageTrade struct {
+	offset int
+	price  float64
+}
+
+// averageTrades includes a gap longer than the window.
+var averageTrades = []averageTrade{
+	{0, 10},
+	{90, 12},
+	{200, 11},
+	{420, 15},
+	{700, 9},
+	{760, 10},
+	{1500, 14},
+	{1550, 13},
+}
+
+// bruteTwap averages the price of every second from the first trade or the
+// start of the window, whichever is later, up to now.
+func bruteTwap(trades []averageTrade, now int, reversed bool) float64 {
+	start := int(gomath.Max(float64(trades[0].offset), float64(now-int(averageWindow.Seconds()))))
+	sum := 0.0
+	for s := start; s < now; s++ {
+		price := 0.0
+		for _, trade := range trades {
+			if trade.offset <= s {
+				price = trade.price
+			}
+		}
+		if reversed {
+			price = 1 / price
+		}
+		sum += price
+	}
+	if now == start {
+		price := trades[len(trades)-1].price
+		if reversed {
+			price = 1 / price
+		}
+		return price
+	}
+	return sum / float64(now-start)
+}
+
+// bruteEma sums the weight each held price has in the EMA as of now: the first
+// price its decay since the first trade, every later price its share of the
+// decay while it was held.
+func bruteEma(trades []averageTrade, now int, reversed bool) float64 {
+	weight := func(offset int) float64 {
+		return gomath.Exp(-float64(now-offset) / averageWindow.Seconds())
+	}
+	ema := 0.0
+	for i, trade := range trades {
+		price := trade.price
+		if reversed {
+			price = 1 / price
+		}
+		end := now
+		if i+1 < len(trades) {
+			end = trades[i+1].offset
+		}
+		if i == 0 {
+			ema += price * weight(trade.offset)
+		}
+		ema += price * (weight(end) - weight(trade.offset))
+	}
+	return ema
+}
+
+func checkAverage(t *testing.T, name string, got *decimal.Big, want float64) {
+	t.Helper()
+	value, _ := got.Float64()
+	if gomath.Abs(value-want) > 1e-9*want {
+		t.Errorf("got %s %v, want %v", name, value, want)
+	}
+}
+
+func TestAverageMatchesBruteForce(t *testing.T) {
+	for _, reversed := range []bool{false, true} {
+		average := NewAverage(averageWindow)
+		for i, trade := range averageTrades {
+			average.Push(decimal.New(int64(trade.price), 0), testStart.Add(time.Duration(trade.offset)*time.Second))
+			next := trade.offset + 2000
+			if i+1 < len(averageTrades) {
+				next = averageTrades[i+1].offset
+			}
+			// as of the trade, half way to the next one and just before it
+			for _, now := range []int{trade.offset, (trade.offset + next) / 2, next - 1} {
+				value, ok := average.Value(testStart.Add(time.Duration(now)*time.Second), reversed)
+				if !ok {
+					t.Fatalf("no average at %ds", now)
+				}
+				checkAverage(t, "twap", &value.Twap, bruteTwap(averageTrades[:i+1], now, reversed))
+				checkAverage(t, "ema", &value.Ema, bruteEma(averageTrades[:i+1], now, reversed))
+			}
+		}
+	}
+}
+
+func TestAverageWithoutTrades(t *testing.T) {
+	average := NewAverage(averageWindow)
+	_, ok := average.Value(testStart, false)
+	if ok {
+		t.Error("got an average without trades")
+	}
+	average.Push(decimal.New(10, 0), testStart.Add(time.Minute))
+	_, ok = average.Value(testStart, false)
+	if ok {
+		t.Error("got an average before the last trade")
+	}
+}
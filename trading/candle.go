@@ -12,34 +12,43 @@ import (
 
 type (
 	Candle struct {
-		BaseAsset   string      `json:"base_asset"`
-		QuoteAsset  string      `json:"quote_asset"`
-		BaseVolume  decimal.Big `json:"base_volume"`
-		QuoteVolume decimal.Big `json:"quote_volume"`
-		High        decimal.Big `json:"high"`
-		Low         decimal.Big `json:"low"`
-		Open        decimal.Big `json:"open"`
-		Close       decimal.Big `json:"close"`
-		Start       time.Time   `json:"start"`
-		End         time.Time   `json:"end"`
+		BaseAsset   string       `json:"base_asset"`
+		QuoteAsset  string       `json:"quote_asset"`
+		BaseVolume  decimal.Big  `json:"base_volume"`
+		QuoteVolume decimal.Big  `json:"quote_volume"`
+		UsdVolume   *decimal.Big `json:"usd_volume,omitempty"`
+		High        decimal.Big  `json:"high"`
+		Low         decimal.Big  `json:"low"`
+		Open        decimal.Big  `json:"open"`
+		Close       decimal.Big  `json:"close"`
+		Start       time.Time    `json:"start"`
+		End         time.Time    `json:"end"`
 	}
 
 	Candles struct {
-		Pair     token.Pair
-		interval time.Duration
-		period   time.Duration
-		candles  []Candle
-		cutoff   time.Time
+		Pair      token.Pair
+		interval  time.Duration
+		period    time.Duration
+		candles   []Candle
+		cutoff    time.Time
+		lastTrade time.Time
+		averages  []*Average
 	}
 )
 
-func NewCandles(pair *token.Pair, trades []*Trade, interval time.Duration, period time.Duration, end time.Time) (*Candles, error) {
+// NewCandles builds candles from trades ordered newest first, tracking averages
+// over each of the given windows.
+func NewCandles(pair *token.Pair, trades []*Trade, interval time.Duration, period time.Duration, end time.Time, windows ...time.Duration) (*Candles, error) {
 	size := int(period/interval) + 1
 	candles := &Candles{
 		interval: interval,
 		period:   period,
 		Pair:     *pair,
 		candles:  make([]Candle, size),
+		averages: make([]*Average, len(windows)),
+	}
+	for i, window := range windows {
+		candles.averages[i] = NewAverage(window)
 	}
 	candles.Reset(end)
 	return candles, candles.SetTrades(trades)
@@ -63,7 +72,11 @@ func (c *Candles) Reset(end time.Time) {
 
 func (c *Candles) shift(n int) {
 	end := len(c.candles) - 1
-	if n <= 0 || n >= end {
+	if n <= 0 {
+		return
+	}
+	if n >= end {
+		c.Reset(c.candles[0].End.Add(time.Duration(n) * c.interval))
 		return
 	}
 	for i := end; i >= n; i-- {
@@ -98,6 +111,9 @@ func (c *Candles) Extend(end time.Time) {
 	}
 	n := int(end.Sub(c.candles[0].End) / c.interval)
 	c.shift(n)
+	for _, average := range c.averages {
+		average.Evict(c.candles[0].Start)
+	}
 }
 
 func (c *Candles) SetTrades(trades []*Trade) error {
@@ -105,6 +121,9 @@ func (c *Candles) SetTrades(trades []*Trade) error {
 		return nil
 	}
 	c.cutoff = trades[0].Time
+	if trades[0].Time.After(c.lastTrade) {
+		c.lastTrade = trades[0].Time
+	}
 	end := c.candles[0].End
 	for _, trade := range trades {
 		if trade.Time.After(c.cutoff) {
@@ -133,6 +152,11 @@ func (c *Candles) SetTrades(trades []*Trade) error {
 		}
 	}
 	c.cutoff = c.candles[0].Start
+	for i := len(trades) - 1; i >= 0; i-- {
+		for _, average := range c.averages {
+			average.Push(trades[i].Price(), trades[i].Time)
+		}
+	}
 	return nil
 }
 
@@ -145,24 +169,29 @@ func (c *Candles) PushTrade(trade *Trade) error {
 	}
 	if trade.Time.After(c.candles[0].End) {
 		newStart := trade.Time.Truncate(c.interval)
-		c.shift(int(c.candles[0].Start.Sub(newStart) / c.interval))
+		c.shift(int(newStart.Sub(c.candles[0].Start) / c.interval))
 	}
 	if trade.Time.Before(c.cutoff) {
 		return fmt.Errorf("trade out of order")
 	}
 	c.cutoff = trade.Time
+	c.lastTrade = trade.Time
 	candle := &c.candles[0]
 	candle.BaseVolume.Add(&candle.BaseVolume, &trade.Base.Amount)
 	candle.QuoteVolume.Add(&candle.QuoteVolume, &trade.Quote.Amount)
-	candle.Open.Set(trade.Price())
-	if candle.Close.Cmp(math.Zero) == 0 {
-		candle.Close.Set(&candle.Open)
-		candle.High.Set(&candle.Open)
-		candle.Low.Set(&candle.Open)
-	} else if candle.Open.Cmp(&candle.High) > 0 {
-		candle.High.Set(&candle.Open)
-	} else if candle.Open.Cmp(&candle.Low) < 0 {
-		candle.Low.Set(&candle.Open)
+	// trades arrive oldest first here, unlike in SetTrades
+	candle.Close.Set(trade.Price())
+	if candle.Open.Cmp(math.Zero) == 0 {
+		candle.Open.Set(&candle.Close)
+		candle.High.Set(&candle.Close)
+		candle.Low.Set(&candle.Close)
+	} else if candle.Close.Cmp(&candle.High) > 0 {
+		candle.High.Set(&candle.Close)
+	} else if candle.Close.Cmp(&candle.Low) < 0 {
+		candle.Low.Set(&candle.Close)
+	}
+	for _, average := range c.averages {
+		average.Push(&candle.Close, trade.Time)
 	}
 	return nil
 }
@@ -178,6 +207,75 @@ func (c *Candles) ListRange(start int, end int) []*Candle {
 	return candles
 }
 
+// Averages returns the averages over the given windows as of now, or over every
+// tracked window if none are given, in the reverse orientation of the pair if
+// reversed is set. Windows without a trade yet are left out.
+func (c *Candles) Averages(now time.Time, reversed bool, windows ...time.Duration) ([]*AverageValue, error) {
+	averages := c.averages
+	if len(windows) > 0 {
+		averages = make([]*Average, len(windows))
+		for i, window := range windows {
+			for _, average := range c.averages {
+				if average.Window == window {
+					averages[i] = average
+				}
+			}
+			if averages[i] == nil {
+				return nil, fmt.Errorf("average window %s not tracked", window)
+			}
+		}
+	}
+	values := []*AverageValue{}
+	for _, average := range averages {
+		value, ok := average.Value(now, reversed)
+		if ok {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// Closed returns a copy of the most recent closed candle, or nil if there is
+// none.
+func (c *Candles) Closed() *Candle {
+	if len(c.candles) < 2 {
+		return nil
+	}
+	return c.candles[1].Copy()
+}
+
+// Range returns copies of the candles starting within [start, end), oldest
+// first.
+func (c *Candles) Range(start time.Time, end time.Time) []*Candle {
+	candles := []*Candle{}
+	for i := len(c.candles) - 1; i >= 0; i-- {
+		candle := &c.candles[i]
+		if candle.Start.Before(start) {
+			continue
+		}
+		if !candle.Start.Before(end) {
+			break
+		}
+		candles = append(candles, candle.Copy())
+	}
+	return candles
+}
+
+// Oldest is the start of the oldest candle kept.
+func (c *Candles) Oldest() time.Time {
+	return c.candles[len(c.candles)-1].Start
+}
+
+// Current is the candle new trades are added to, which is still open.
+func (c *Candles) Current() *Candle {
+	return c.candles[0].Copy()
+}
+
+// LastTrade is the time of the newest trade added, zero if there was none.
+func (c *Candles) LastTrade() time.Time {
+	return c.lastTrade
+}
+
 func (c *Candles) Len() int {
 	return len(c.candles)
 }
@@ -188,6 +286,7 @@ func (c *Candles) Ticker() *Ticker {
 		QuoteAsset: c.Pair.Quote,
 		Price:      c.candles[0].Close,
 		Time:       c.cutoff,
+		LastTrade:  c.lastTrade,
 	}
 	start := ticker.Time.Add(-24 * time.Hour)
 	for _, candle := range c.candles {
@@ -203,12 +302,32 @@ func (c *Candles) Ticker() *Ticker {
 	return ticker
 }
 
+// Copy returns a candle that shares no values with c, so that it can be read
+// while c is updated.
+func (c *Candle) Copy() *Candle {
+	candle := &Candle{
+		BaseAsset:  c.BaseAsset,
+		QuoteAsset: c.QuoteAsset,
+		UsdVolume:  c.UsdVolume,
+		Start:      c.Start,
+		End:        c.End,
+	}
+	candle.BaseVolume.Set(&c.BaseVolume)
+	candle.QuoteVolume.Set(&c.QuoteVolume)
+	candle.High.Set(&c.High)
+	candle.Low.Set(&c.Low)
+	candle.Open.Set(&c.Open)
+	candle.Close.Set(&c.Close)
+	return candle
+}
+
 func (c *Candle) Reversed() *Candle {
 	r := Candle{
 		BaseAsset:   c.QuoteAsset,
 		QuoteAsset:  c.BaseAsset,
 		BaseVolume:  c.QuoteVolume,
 		QuoteVolume: c.BaseVolume,
+		UsdVolume:   c.UsdVolume,
 		Start:       c.Start,
 		End:         c.End,
 	}
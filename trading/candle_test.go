@@ -0,0 +1,125 @@
+package trading
+
+import (
+	"testing"
+	"time"
+
+	"indexer/token"
+
+	"github.com/ericlagergren/decimal"
+)
+
+var (
+	testPair  = &token.Pair{Base: "ATOM", Quote: "USDC"}
+	testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func newTestTrade(t *testing.T, offset time.Duration, base string, quote string) *Trade {
+	t.Helper()
+	trade := &Trade{
+		Base:  token.Token{Symbol: testPair.Base},
+		Quote: token.Token{Symbol: testPair.Quote},
+		Time:  testStart.Add(offset),
+	}
+	_, ok := trade.Base.Amount.SetString(base)
+	if !ok {
+		t.Fatalf("invalid base amount %s", base)
+	}
+	_, ok = trade.Quote.Amount.SetString(quote)
+	if !ok {
+		t.Fatalf("invalid quote amount %s", quote)
+	}
+	return trade
+}
+
+// newTestCandles returns five minutes of one minute candles, the current one
+// starting at testStart.
+func newTestCandles(t *testing.T) *Candles {
+	t.Helper()
+	candles, err := NewCandles(testPair, nil, time.Minute, 5*time.Minute, testStart.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return candles
+}
+
+func pushTrades(t *testing.T, candles *Candles, trades ...*Trade) {
+	t.Helper()
+	for _, trade := range trades {
+		err := candles.PushTrade(trade)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkVolumes compares the start and base volume of the candles, newest first.
+func checkVolumes(t *testing.T, candles *Candles, start time.Time, volumes []int64) {
+	t.Helper()
+	if len(candles.candles) != len(volumes) {
+		t.Fatalf("got %d candles, want %d", len(candles.candles), len(volumes))
+	}
+	for i, volume := range volumes {
+		candle := &candles.candles[i]
+		want := start.Add(-time.Duration(i) * time.Minute)
+		if !candle.Start.Equal(want) || !candle.End.Equal(want.Add(time.Minute)) {
+			t.Errorf("candle %d: got %s to %s, want to start at %s", i, candle.Start, candle.End, want)
+		}
+		got, ok := candle.BaseVolume.Int64()
+		if !ok || got != volume {
+			t.Errorf("candle %d: got base volume %s, want %d", i, candle.BaseVolume.String(), volume)
+		}
+	}
+}
+
+func TestPushTradeShiftsCandles(t *testing.T) {
+	candles := newTestCandles(t)
+	pushTrades(t, candles,
+		newTestTrade(t, 30*time.Second, "1", "10"),
+		newTestTrade(t, 2*time.Minute+30*time.Second, "2", "20"),
+	)
+	checkVolumes(t, candles, testStart.Add(2*time.Minute), []int64{2, 0, 1, 0, 0, 0})
+}
+
+func TestPushTradeResetsCandlesAfterGap(t *testing.T) {
+	candles := newTestCandles(t)
+	pushTrades(t, candles,
+		newTestTrade(t, 30*time.Second, "1", "10"),
+		newTestTrade(t, 10*time.Minute+30*time.Second, "2", "20"),
+	)
+	checkVolumes(t, candles, testStart.Add(10*time.Minute), []int64{2, 0, 0, 0, 0, 0})
+}
+
+func TestExtendShiftsCandles(t *testing.T) {
+	candles := newTestCandles(t)
+	pushTrades(t, candles, newTestTrade(t, 30*time.Second, "1", "10"))
+	candles.Extend(testStart.Add(3 * time.Minute))
+	checkVolumes(t, candles, testStart.Add(2*time.Minute), []int64{0, 0, 1, 0, 0, 0})
+	candles.Extend(testStart.Add(time.Hour))
+	checkVolumes(t, candles, testStart.Add(59*time.Minute), []int64{0, 0, 0, 0, 0, 0})
+}
+
+func TestPushTradeOpensAtFirstAndClosesAtLast(t *testing.T) {
+	candles := newTestCandles(t)
+	pushTrades(t, candles,
+		newTestTrade(t, 10*time.Second, "1", "10"),
+		newTestTrade(t, 20*time.Second, "1", "12"),
+		newTestTrade(t, 30*time.Second, "1", "9"),
+		newTestTrade(t, 40*time.Second, "1", "11"),
+	)
+	candle := &candles.candles[0]
+	for _, check := range []struct {
+		name string
+		got  *decimal.Big
+		want int64
+	}{
+		{"open", &candle.Open, 10},
+		{"high", &candle.High, 12},
+		{"low", &candle.Low, 9},
+		{"close", &candle.Close, 11},
+	} {
+		if check.got.Cmp(decimal.New(check.want, 0)) != 0 {
+			t.Errorf("got %s %s, want %d", check.name, check.got.String(), check.want)
+		}
+	}
+}
@@ -11,8 +11,13 @@ type Ticker struct {
 	QuoteAsset string `json:"quote_asset"`
 	BaseVolume decimal.Big `json:"base_volume"`
 	QuoteVolume decimal.Big `json:"quote_volume"`
+	UsdVolume *decimal.Big `json:"usd_volume,omitempty"`
 	Price decimal.Big `json:"price"`
 	Time time.Time `json:"time"`
+	LastTrade time.Time `json:"last_trade"`
+	LastTradeAge *int64 `json:"last_trade_age,omitempty"`
+	Stale bool `json:"stale"`
+	Pool string `json:"pool,omitempty"`
 }
 
 func (t *Ticker) Reversed() *Ticker {
@@ -21,7 +26,12 @@ func (t *Ticker) Reversed() *Ticker {
 		QuoteAsset: t.BaseAsset,
 		BaseVolume: t.QuoteVolume,
 		QuoteVolume: t.BaseVolume,
+		UsdVolume: t.UsdVolume,
 		Time: t.Time,
+		LastTrade: t.LastTrade,
+		LastTradeAge: t.LastTradeAge,
+		Stale: t.Stale,
+		Pool: t.Pool,
 	}
 	if t.Price.Cmp(&decimal.Big{}) != 0 {
 		one := &decimal.Big{}
@@ -29,4 +39,20 @@ func (t *Ticker) Reversed() *Ticker {
 		r.Price.Quo(one, &t.Price)
 	}
 	return r
-}
\ No newline at end of file
+}
+
+// Fresh returns a copy of the ticker with the age of its last trade in seconds
+// as of now, marked stale if older than maxAge. Tickers without trades are
+// always stale.
+func (t *Ticker) Fresh(now time.Time, maxAge time.Duration) *Ticker {
+	r := *t
+	r.LastTradeAge = nil
+	r.Stale = true
+	if !t.LastTrade.IsZero() {
+		age := now.Sub(t.LastTrade)
+		seconds := int64(age / time.Second)
+		r.LastTradeAge = &seconds
+		r.Stale = age > maxAge
+	}
+	return &r
+}
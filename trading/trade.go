@@ -10,9 +10,25 @@ import (
 
 type (
 	Trade struct {
-		Base  token.Token `json:"base"`
-		Quote token.Token `json:"quote"`
-		Time  time.Time   `json:"time"`
+		Base     token.Token `json:"base"`
+		Quote    token.Token `json:"quote"`
+		Time     time.Time   `json:"time"`
+		Height   int64       `json:"height,omitempty"`
+		TxHash   string      `json:"tx_hash,omitempty"`
+		Pool     string      `json:"pool,omitempty"`
+		PoolType string      `json:"pool_type,omitempty"`
+		Routed   bool        `json:"routed,omitempty"`
+	}
+
+	// FlaggedTrade is a trade whose price deviated too far from its reference
+	// price, kept for review. Excluded trades never reached candles or tickers.
+	FlaggedTrade struct {
+		Trade
+		TradePrice decimal.Big `json:"price"`
+		Reason     string      `json:"reason"`
+		Reference  decimal.Big `json:"reference_price"`
+		Deviation  float64     `json:"deviation"`
+		Excluded   bool        `json:"excluded"`
 	}
 )
 
@@ -31,8 +47,13 @@ func (t *Trade) Pair() *token.Pair {
 
 func (t *Trade) Reversed() *Trade {
 	return &Trade{
-		Base:  t.Quote,
-		Quote: t.Base,
-		Time:  t.Time,
+		Base:     t.Quote,
+		Quote:    t.Base,
+		Time:     t.Time,
+		Height:   t.Height,
+		TxHash:   t.TxHash,
+		Pool:     t.Pool,
+		PoolType: t.PoolType,
+		Routed:   t.Routed,
 	}
 }